@@ -0,0 +1,551 @@
+package bilibili
+
+import (
+	"encoding/json"
+	"errors"
+	"mihiru-go/models"
+	"strconv"
+	"strings"
+)
+
+const (
+	DynamicTypeRepost        = 1
+	DynamicTypePicture       = 2
+	DynamicTypeText          = 4
+	DynamicTypeVideo         = 8
+	DynamicTypeShortVideo    = 16
+	DynamicTypeArticle       = 64
+	DynamicTypeAudio         = 256
+	DynamicTypeBangumi       = 512
+	DynamicTypeWebShare      = 2048
+	DynamicTypeBangumiSeason = 4097
+	DynamicTypeMovie         = 4098
+	DynamicTypeTvSeries      = 4099
+	DynamicTypeDocumentary   = 4100
+	DynamicTypeCinema        = 4101
+	DynamicTypeLiveRoom      = 4200
+	DynamicTypeMediaList     = 4300
+	DynamicTypeLive          = 4308
+)
+
+type Card struct {
+	Desc CardDesc `json:"desc"`
+	Card string   `json:"card"`
+}
+
+type CardDesc struct {
+	Uid          int64            `json:"uid"`
+	Type         int16            `json:"type"`
+	Rid          int64            `json:"rid"`
+	DynamicId    int64            `json:"dynamic_id"`
+	DynamicIdStr string           `json:"dynamic_id_str"`
+	Timestamp    int64            `json:"timestamp"`
+	OrigDyId     int64            `json:"orig_dy_id"`
+	OrigType     int16            `json:"orig_type"`
+	Bvid         string           `json:"bvid"`
+	UserProfile  *cardUserProfile `json:"user_profile"`
+	Origin       *CardDesc        `json:"origin"`
+}
+
+type cardUserProfile struct {
+	Info cardUser `json:"info"`
+}
+
+type cardUser struct {
+	Uid     int64  `json:"uid"`
+	Mid     int64  `json:"mid"`
+	Uname   string `json:"uname"`
+	Name    string `json:"name"`
+	Face    string `json:"face"`
+	HeadUrl string `json:"head_url"`
+}
+
+type cardCtrl struct {
+	Data     json.RawMessage `json:"data"`
+	Length   int             `json:"length"`
+	Location int             `json:"location"`
+	Type     int16           `json:"type"`
+}
+
+type cardPicture struct {
+	ImgSrc    string  `json:"img_src"`
+	ImgWidth  float64 `json:"img_width"`
+	ImgHeight float64 `json:"img_height"`
+	ImgSize   float64 `json:"img_size"`
+}
+
+type cardCover struct {
+	Default string `json:"default"`
+}
+
+type cardConverter func(desc *CardDesc, card []byte, dynamic *models.Dynamic) error
+
+func converterOf(dynamicType int16) cardConverter {
+	switch dynamicType {
+	case DynamicTypeRepost:
+		return convertRepostCard
+	case DynamicTypePicture:
+		return convertPictureCard
+	case DynamicTypeText:
+		return convertTextCard
+	case DynamicTypeVideo:
+		return convertVideoCard
+	case DynamicTypeShortVideo:
+		return convertShortVideoCard
+	case DynamicTypeArticle:
+		return convertArticleCard
+	case DynamicTypeAudio:
+		return convertAudioCard
+	case DynamicTypeBangumi, DynamicTypeBangumiSeason, DynamicTypeMovie, DynamicTypeTvSeries, DynamicTypeDocumentary, DynamicTypeCinema:
+		return convertBangumiCard
+	case DynamicTypeWebShare:
+		return convertWebShareCard
+	case DynamicTypeLiveRoom:
+		return convertLiveRoomCard
+	case DynamicTypeMediaList:
+		return convertMediaListCard
+	case DynamicTypeLive:
+		return convertLiveCard
+	}
+	return nil
+}
+
+// ParseDynamics accepts a raw response of the dynamic APIs (space_history, dynamic_new, get_dynamic_detail),
+// its data object, a card list or a single card, and converts every card into a models.Dynamic.
+func ParseDynamics(data []byte) ([]*models.Dynamic, error) {
+	cards, err := parseCards(data)
+	if err != nil {
+		return nil, err
+	}
+	dynamics := make([]*models.Dynamic, 0, len(cards))
+	for _, card := range cards {
+		dynamic, err := ConvertCard(card)
+		if err != nil {
+			return nil, err
+		}
+		dynamics = append(dynamics, dynamic)
+	}
+	return dynamics, nil
+}
+
+func ConvertCard(card *Card) (*models.Dynamic, error) {
+	dynamic := new(models.Dynamic)
+	desc := &card.Desc
+	dynamic.Type = desc.Type
+	dynamic.DynamicId = desc.DynamicId
+	if desc.DynamicIdStr != "" {
+		if dynamicId, err := strconv.ParseInt(desc.DynamicIdStr, 10, 64); err == nil {
+			dynamic.DynamicId = dynamicId
+		}
+	}
+	dynamic.Rid = desc.Rid
+	dynamic.Bvid = desc.Bvid
+	dynamic.Timestamp = desc.Timestamp
+	if desc.UserProfile != nil {
+		dynamic.UserProfile = desc.UserProfile.Info.toProfile()
+	}
+	converter := converterOf(desc.Type)
+	if converter == nil || card.Card == "" {
+		dynamic.Card = card.Card
+		return dynamic, nil
+	}
+	if err := converter(desc, []byte(card.Card), dynamic); err != nil {
+		return nil, err
+	}
+	return dynamic, nil
+}
+
+func parseCards(data []byte) ([]*Card, error) {
+	trimmed := strings.TrimSpace(string(data))
+	if strings.HasPrefix(trimmed, "[") {
+		var cards []*Card
+		if err := json.Unmarshal(data, &cards); err != nil {
+			return nil, err
+		}
+		return cards, nil
+	}
+	var envelope struct {
+		Code    *int            `json:"code"`
+		Message string          `json:"message"`
+		Data    json.RawMessage `json:"data"`
+		Cards   []*Card         `json:"cards"`
+		Card    *Card           `json:"card"`
+		Desc    *CardDesc       `json:"desc"`
+	}
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, err
+	}
+	if envelope.Code != nil && *envelope.Code != 0 {
+		return nil, errors.New("bilibili api returned error: " + envelope.Message)
+	}
+	if len(envelope.Data) > 0 && string(envelope.Data) != "null" {
+		return parseCards(envelope.Data)
+	}
+	if envelope.Cards != nil {
+		return envelope.Cards, nil
+	}
+	if envelope.Card != nil {
+		return []*Card{envelope.Card}, nil
+	}
+	if envelope.Desc != nil {
+		var card Card
+		if err := json.Unmarshal(data, &card); err != nil {
+			return nil, err
+		}
+		return []*Card{&card}, nil
+	}
+	return []*Card{}, nil
+}
+
+func convertRepostCard(desc *CardDesc, card []byte, dynamic *models.Dynamic) error {
+	var repost struct {
+		User cardUser `json:"user"`
+		Item struct {
+			Content  string `json:"content"`
+			Ctrl     string `json:"ctrl"`
+			OrigDyId int64  `json:"orig_dy_id"`
+			OrigType int16  `json:"orig_type"`
+			Tips     string `json:"tips"`
+		} `json:"item"`
+		Origin     string           `json:"origin"`
+		OriginUser *cardUserProfile `json:"origin_user"`
+	}
+	if err := json.Unmarshal(card, &repost); err != nil {
+		return err
+	}
+	dynamic.Content = repost.Item.Content
+	dynamic.Ctrl = parseCtrl(repost.Item.Ctrl)
+	fillUserProfile(dynamic, &repost.User)
+	originCard := new(Card)
+	if desc.Origin != nil {
+		originCard.Desc = *desc.Origin
+	} else {
+		originCard.Desc.DynamicId = desc.OrigDyId
+		originCard.Desc.Type = desc.OrigType
+	}
+	if originCard.Desc.DynamicId == 0 {
+		originCard.Desc.DynamicId = repost.Item.OrigDyId
+	}
+	if originCard.Desc.Type == 0 {
+		originCard.Desc.Type = repost.Item.OrigType
+	}
+	if originCard.Desc.UserProfile == nil && repost.OriginUser != nil {
+		originCard.Desc.UserProfile = repost.OriginUser
+	}
+	originCard.Card = repost.Origin
+	if originCard.Card == "" {
+		dynamic.Origin = &models.Dynamic{
+			Type:      originCard.Desc.Type,
+			DynamicId: originCard.Desc.DynamicId,
+			Content:   repost.Item.Tips,
+		}
+		return nil
+	}
+	origin, err := ConvertCard(originCard)
+	if err != nil {
+		return err
+	}
+	dynamic.Origin = origin
+	return nil
+}
+
+func convertPictureCard(_ *CardDesc, card []byte, dynamic *models.Dynamic) error {
+	var picture struct {
+		User cardUser `json:"user"`
+		Item struct {
+			Title       string        `json:"title"`
+			Description string        `json:"description"`
+			AtControl   string        `json:"at_control"`
+			Pictures    []cardPicture `json:"pictures"`
+		} `json:"item"`
+	}
+	if err := json.Unmarshal(card, &picture); err != nil {
+		return err
+	}
+	dynamic.Title = picture.Item.Title
+	dynamic.Content = picture.Item.Description
+	dynamic.Ctrl = parseCtrl(picture.Item.AtControl)
+	if picture.Item.Pictures != nil {
+		pictures := make([]models.DynamicPicture, 0, len(picture.Item.Pictures))
+		for _, p := range picture.Item.Pictures {
+			pictures = append(pictures, models.DynamicPicture{
+				ImgSrc:    p.ImgSrc,
+				ImgWidth:  int(p.ImgWidth),
+				ImgHeight: int(p.ImgHeight),
+				ImgSize:   int(p.ImgSize),
+			})
+		}
+		dynamic.Pictures = &pictures
+	}
+	fillUserProfile(dynamic, &picture.User)
+	return nil
+}
+
+func convertTextCard(_ *CardDesc, card []byte, dynamic *models.Dynamic) error {
+	var text struct {
+		User cardUser `json:"user"`
+		Item struct {
+			Content string `json:"content"`
+			Ctrl    string `json:"ctrl"`
+		} `json:"item"`
+	}
+	if err := json.Unmarshal(card, &text); err != nil {
+		return err
+	}
+	dynamic.Content = text.Item.Content
+	dynamic.Ctrl = parseCtrl(text.Item.Ctrl)
+	fillUserProfile(dynamic, &text.User)
+	return nil
+}
+
+func convertVideoCard(_ *CardDesc, card []byte, dynamic *models.Dynamic) error {
+	var video struct {
+		Aid     int64    `json:"aid"`
+		Title   string   `json:"title"`
+		Desc    string   `json:"desc"`
+		Pic     string   `json:"pic"`
+		Dynamic string   `json:"dynamic"`
+		Ctrl    string   `json:"ctrl"`
+		Owner   cardUser `json:"owner"`
+	}
+	if err := json.Unmarshal(card, &video); err != nil {
+		return err
+	}
+	if dynamic.Rid == 0 {
+		dynamic.Rid = video.Aid
+	}
+	dynamic.Title = video.Title
+	dynamic.Desc = video.Desc
+	dynamic.Pic = video.Pic
+	dynamic.Content = video.Dynamic
+	dynamic.Ctrl = parseCtrl(video.Ctrl)
+	fillUserProfile(dynamic, &video.Owner)
+	return nil
+}
+
+func convertShortVideoCard(_ *CardDesc, card []byte, dynamic *models.Dynamic) error {
+	var shortVideo struct {
+		User cardUser `json:"user"`
+		Item struct {
+			Description string    `json:"description"`
+			Cover       cardCover `json:"cover"`
+		} `json:"item"`
+	}
+	if err := json.Unmarshal(card, &shortVideo); err != nil {
+		return err
+	}
+	dynamic.Content = shortVideo.Item.Description
+	dynamic.Pic = shortVideo.Item.Cover.Default
+	fillUserProfile(dynamic, &shortVideo.User)
+	return nil
+}
+
+func convertArticleCard(_ *CardDesc, card []byte, dynamic *models.Dynamic) error {
+	var article struct {
+		Id        int64    `json:"id"`
+		Title     string   `json:"title"`
+		Summary   string   `json:"summary"`
+		BannerUrl string   `json:"banner_url"`
+		ImageUrls []string `json:"image_urls"`
+		Author    cardUser `json:"author"`
+	}
+	if err := json.Unmarshal(card, &article); err != nil {
+		return err
+	}
+	if dynamic.Rid == 0 {
+		dynamic.Rid = article.Id
+	}
+	dynamic.Title = article.Title
+	dynamic.Content = article.Summary
+	dynamic.Pic = article.BannerUrl
+	dynamic.ImageUrls = article.ImageUrls
+	fillUserProfile(dynamic, &article.Author)
+	return nil
+}
+
+func convertAudioCard(_ *CardDesc, card []byte, dynamic *models.Dynamic) error {
+	var audio struct {
+		Id     int64  `json:"id"`
+		Title  string `json:"title"`
+		Intro  string `json:"intro"`
+		Cover  string `json:"cover"`
+		Upper  string `json:"upper"`
+		UpId   int64  `json:"upId"`
+		Author string `json:"author"`
+	}
+	if err := json.Unmarshal(card, &audio); err != nil {
+		return err
+	}
+	if dynamic.Rid == 0 {
+		dynamic.Rid = audio.Id
+	}
+	dynamic.Title = audio.Title
+	dynamic.Content = audio.Intro
+	dynamic.Pic = audio.Cover
+	if audio.Author != "" {
+		dynamic.Desc = audio.Author
+	}
+	fillUserProfile(dynamic, &cardUser{Uid: audio.UpId, Name: audio.Upper})
+	return nil
+}
+
+func convertBangumiCard(_ *CardDesc, card []byte, dynamic *models.Dynamic) error {
+	var bangumi struct {
+		ApiSeasonInfo struct {
+			Title string `json:"title"`
+			Cover string `json:"cover"`
+		} `json:"apiSeasonInfo"`
+		NewDesc string `json:"new_desc"`
+		Index   string `json:"index_title"`
+		Cover   string `json:"cover"`
+	}
+	if err := json.Unmarshal(card, &bangumi); err != nil {
+		return err
+	}
+	dynamic.Title = bangumi.ApiSeasonInfo.Title
+	dynamic.Content = bangumi.NewDesc
+	dynamic.Desc = bangumi.Index
+	dynamic.Pic = bangumi.Cover
+	if dynamic.Pic == "" {
+		dynamic.Pic = bangumi.ApiSeasonInfo.Cover
+	}
+	return nil
+}
+
+func convertWebShareCard(_ *CardDesc, card []byte, dynamic *models.Dynamic) error {
+	var webShare struct {
+		User cardUser `json:"user"`
+		Vest struct {
+			Content string `json:"content"`
+			Ctrl    string `json:"ctrl"`
+		} `json:"vest"`
+		Sketch struct {
+			Title     string `json:"title"`
+			DescText  string `json:"desc_text"`
+			CoverUrl  string `json:"cover_url"`
+			TargetUrl string `json:"target_url"`
+		} `json:"sketch"`
+	}
+	if err := json.Unmarshal(card, &webShare); err != nil {
+		return err
+	}
+	dynamic.Content = webShare.Vest.Content
+	dynamic.Ctrl = parseCtrl(webShare.Vest.Ctrl)
+	dynamic.Title = webShare.Sketch.Title
+	dynamic.Desc = webShare.Sketch.DescText
+	dynamic.Pic = webShare.Sketch.CoverUrl
+	fillUserProfile(dynamic, &webShare.User)
+	return nil
+}
+
+func convertLiveRoomCard(_ *CardDesc, card []byte, dynamic *models.Dynamic) error {
+	var liveRoom struct {
+		RoomId     int64  `json:"roomid"`
+		Uid        int64  `json:"uid"`
+		Uname      string `json:"uname"`
+		Face       string `json:"face"`
+		Title      string `json:"title"`
+		Cover      string `json:"cover"`
+		AreaV2Name string `json:"area_v2_name"`
+	}
+	if err := json.Unmarshal(card, &liveRoom); err != nil {
+		return err
+	}
+	if dynamic.Rid == 0 {
+		dynamic.Rid = liveRoom.RoomId
+	}
+	dynamic.Title = liveRoom.Title
+	dynamic.Pic = liveRoom.Cover
+	dynamic.AreaV2Name = liveRoom.AreaV2Name
+	fillUserProfile(dynamic, &cardUser{Uid: liveRoom.Uid, Uname: liveRoom.Uname, Face: liveRoom.Face})
+	return nil
+}
+
+func convertMediaListCard(_ *CardDesc, card []byte, dynamic *models.Dynamic) error {
+	var mediaList struct {
+		Id    int64  `json:"id"`
+		Title string `json:"title"`
+		Intro string `json:"intro"`
+		Cover string `json:"cover"`
+	}
+	if err := json.Unmarshal(card, &mediaList); err != nil {
+		return err
+	}
+	if dynamic.Rid == 0 {
+		dynamic.Rid = mediaList.Id
+	}
+	dynamic.Title = mediaList.Title
+	dynamic.Content = mediaList.Intro
+	dynamic.Pic = mediaList.Cover
+	return nil
+}
+
+func convertLiveCard(_ *CardDesc, card []byte, dynamic *models.Dynamic) error {
+	var live struct {
+		LivePlayInfo struct {
+			RoomId   int64  `json:"room_id"`
+			Uid      int64  `json:"uid"`
+			Title    string `json:"title"`
+			Cover    string `json:"cover"`
+			AreaName string `json:"area_name"`
+		} `json:"live_play_info"`
+	}
+	if err := json.Unmarshal(card, &live); err != nil {
+		return err
+	}
+	if dynamic.Rid == 0 {
+		dynamic.Rid = live.LivePlayInfo.RoomId
+	}
+	dynamic.Title = live.LivePlayInfo.Title
+	dynamic.Pic = live.LivePlayInfo.Cover
+	dynamic.AreaV2Name = live.LivePlayInfo.AreaName
+	return nil
+}
+
+func parseCtrl(ctrl string) *[]models.DynamicCtrl {
+	if ctrl == "" {
+		return nil
+	}
+	var cardCtrls []cardCtrl
+	if err := json.Unmarshal([]byte(ctrl), &cardCtrls); err != nil {
+		return nil
+	}
+	ctrls := make([]models.DynamicCtrl, 0, len(cardCtrls))
+	for _, c := range cardCtrls {
+		// data is usually a string, but some ctrl types carry a number, which is kept as written
+		data := string(c.Data)
+		var text string
+		if err := json.Unmarshal(c.Data, &text); err == nil {
+			data = text
+		}
+		ctrls = append(ctrls, models.DynamicCtrl{Data: data, Length: c.Length, Location: c.Location, Type: c.Type})
+	}
+	return &ctrls
+}
+
+func fillUserProfile(dynamic *models.Dynamic, user *cardUser) {
+	if dynamic.UserProfile != nil {
+		return
+	}
+	profile := user.toProfile()
+	if profile.Uid != 0 || profile.Uname != "" {
+		dynamic.UserProfile = profile
+	}
+}
+
+func (u *cardUser) toProfile() *models.DynamicUserProfile {
+	profile := new(models.DynamicUserProfile)
+	profile.Uid = u.Uid
+	if profile.Uid == 0 {
+		profile.Uid = u.Mid
+	}
+	profile.Uname = u.Uname
+	if profile.Uname == "" {
+		profile.Uname = u.Name
+	}
+	profile.Face = u.Face
+	if profile.Face == "" {
+		profile.Face = u.HeadUrl
+	}
+	return profile
+}
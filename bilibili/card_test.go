@@ -0,0 +1,242 @@
+package bilibili
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"mihiru-go/models"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var (
+	mihiru   = &models.DynamicUserProfile{Uid: 10001, Uname: "米鲁", Face: "https://i0.hdslb.com/bfs/face/mihiru.jpg"}
+	xiaoMing = &models.DynamicUserProfile{Uid: 20002, Uname: "小明", Face: "https://i0.hdslb.com/bfs/face/xiaoming.jpg"}
+	xiaoHong = &models.DynamicUserProfile{Uid: 30003, Uname: "小红", Face: "https://i0.hdslb.com/bfs/face/xiaohong.jpg"}
+)
+
+func bangumiDynamic(dynamicType int16, dynamicId int64, timestamp int64, rid int64, title string, pic string) *models.Dynamic {
+	return &models.Dynamic{
+		Type:      dynamicType,
+		DynamicId: dynamicId,
+		Rid:       rid,
+		Timestamp: timestamp,
+		Title:     title,
+		Content:   "第1话 开始",
+		Desc:      "开始",
+		Pic:       pic,
+	}
+}
+
+func TestParseDynamics(t *testing.T) {
+	tests := []struct {
+		file string
+		want []*models.Dynamic
+	}{
+		{file: "text.json", want: []*models.Dynamic{{
+			Type:        DynamicTypeText,
+			DynamicId:   700000000000000001,
+			Rid:         700001,
+			Timestamp:   1620000000,
+			UserProfile: mihiru,
+			Content:     "@小明 早上好 “引用”[微笑]",
+			Ctrl: &[]models.DynamicCtrl{
+				{Data: "20002", Length: 3, Location: 0, Type: 1},
+				{Data: `"引用"`, Length: 4, Location: 9, Type: 3},
+			},
+		}}},
+		{file: "picture.json", want: []*models.Dynamic{{
+			Type:        DynamicTypePicture,
+			DynamicId:   700000000000000002,
+			Rid:         800001,
+			Timestamp:   1620000100,
+			UserProfile: mihiru,
+			Content:     "今天的照片 @小红",
+			Ctrl: &[]models.DynamicCtrl{
+				{Data: "30003", Length: 3, Location: 6, Type: 1},
+				{Data: "42", Length: 0, Location: 0, Type: 2},
+				{Data: `say "hi" 测试`, Length: 2, Location: 0, Type: 3},
+			},
+			Pictures: &[]models.DynamicPicture{
+				{ImgSrc: "https://i0.hdslb.com/bfs/album/p1.jpg", ImgWidth: 1080, ImgHeight: 1920, ImgSize: 523},
+				{ImgSrc: "https://i0.hdslb.com/bfs/album/p2.png", ImgWidth: 800, ImgHeight: 600, ImgSize: 88},
+			},
+		}}},
+		{file: "video.json", want: []*models.Dynamic{{
+			Type:        DynamicTypeVideo,
+			DynamicId:   700000000000000003,
+			Rid:         170001,
+			Bvid:        "BV1xx411c7mD",
+			Timestamp:   1620000200,
+			UserProfile: mihiru,
+			Title:       "视频标题",
+			Content:     "投稿了视频",
+			Desc:        "视频简介",
+			Pic:         "https://i0.hdslb.com/bfs/archive/v.jpg",
+		}}},
+		{file: "short_video.json", want: []*models.Dynamic{{
+			Type:        DynamicTypeShortVideo,
+			DynamicId:   700000000000000004,
+			Rid:         900001,
+			Timestamp:   1620000300,
+			UserProfile: mihiru,
+			Content:     "小视频",
+			Pic:         "https://i0.hdslb.com/bfs/vc/c.jpg",
+		}}},
+		{file: "article.json", want: []*models.Dynamic{{
+			Type:        DynamicTypeArticle,
+			DynamicId:   700000000000000005,
+			Rid:         9001,
+			Timestamp:   1620000400,
+			UserProfile: mihiru,
+			Title:       "专栏标题",
+			Content:     "专栏摘要",
+			Pic:         "https://i0.hdslb.com/bfs/article/b.jpg",
+			ImageUrls:   []string{"https://i0.hdslb.com/bfs/article/1.jpg", "https://i0.hdslb.com/bfs/article/2.jpg"},
+		}}},
+		{file: "audio.json", want: []*models.Dynamic{{
+			Type:        DynamicTypeAudio,
+			DynamicId:   700000000000000006,
+			Rid:         80001,
+			Timestamp:   1620000500,
+			UserProfile: &models.DynamicUserProfile{Uid: 10001, Uname: "米鲁"},
+			Title:       "翻唱",
+			Content:     "歌曲简介",
+			Desc:        "原唱者",
+			Pic:         "https://i0.hdslb.com/bfs/music/c.jpg",
+		}}},
+		{file: "bangumi.json", want: []*models.Dynamic{
+			bangumiDynamic(DynamicTypeBangumi, 700000000000000007, 1620000600, 4001, "番剧", "https://i0.hdslb.com/bfs/bangumi/e.jpg"),
+			bangumiDynamic(DynamicTypeBangumiSeason, 700000000000000008, 1620000601, 4002, "番剧季度", "https://i0.hdslb.com/bfs/bangumi/s.jpg"),
+			bangumiDynamic(DynamicTypeMovie, 700000000000000009, 1620000602, 4003, "电影", "https://i0.hdslb.com/bfs/bangumi/e.jpg"),
+			bangumiDynamic(DynamicTypeTvSeries, 700000000000000010, 1620000603, 4004, "电视剧", "https://i0.hdslb.com/bfs/bangumi/e.jpg"),
+			bangumiDynamic(DynamicTypeDocumentary, 700000000000000011, 1620000604, 4005, "纪录片", "https://i0.hdslb.com/bfs/bangumi/e.jpg"),
+			bangumiDynamic(DynamicTypeCinema, 700000000000000012, 1620000605, 4006, "影视", "https://i0.hdslb.com/bfs/bangumi/e.jpg"),
+		}},
+		{file: "web_share.json", want: []*models.Dynamic{{
+			Type:        DynamicTypeWebShare,
+			DynamicId:   700000000000000013,
+			Timestamp:   1620000700,
+			UserProfile: mihiru,
+			Title:       "网页标题",
+			Content:     "分享 @小明",
+			Desc:        "网页描述",
+			Pic:         "https://i0.hdslb.com/bfs/share/c.jpg",
+			Ctrl:        &[]models.DynamicCtrl{{Data: "20002", Length: 3, Location: 3, Type: 1}},
+		}}},
+		{file: "live_room.json", want: []*models.Dynamic{{
+			Type:        DynamicTypeLiveRoom,
+			DynamicId:   700000000000000014,
+			Rid:         5001,
+			Timestamp:   1620000800,
+			UserProfile: mihiru,
+			Title:       "直播间标题",
+			Pic:         "https://i0.hdslb.com/bfs/live/c.jpg",
+			AreaV2Name:  "虚拟主播",
+		}}},
+		{file: "media_list.json", want: []*models.Dynamic{{
+			Type:        DynamicTypeMediaList,
+			DynamicId:   700000000000000015,
+			Rid:         60001,
+			Timestamp:   1620000900,
+			UserProfile: mihiru,
+			Title:       "收藏夹",
+			Content:     "收藏夹简介",
+			Pic:         "https://i0.hdslb.com/bfs/archive/m.jpg",
+		}}},
+		{file: "live.json", want: []*models.Dynamic{{
+			Type:        DynamicTypeLive,
+			DynamicId:   700000000000000016,
+			Rid:         5001,
+			Timestamp:   1620001000,
+			UserProfile: mihiru,
+			Title:       "正在直播",
+			Pic:         "https://i0.hdslb.com/bfs/live/new.jpg",
+			AreaV2Name:  "虚拟主播",
+		}}},
+		{file: "repost.json", want: []*models.Dynamic{{
+			Type:        DynamicTypeRepost,
+			DynamicId:   700000000000000020,
+			Rid:         700000000000000020,
+			Timestamp:   1620002000,
+			UserProfile: mihiru,
+			Content:     "转发 //@小红:好看",
+			Ctrl:        &[]models.DynamicCtrl{{Data: "30003", Length: 3, Location: 5, Type: 1}},
+			Origin: &models.Dynamic{
+				Type:        DynamicTypePicture,
+				DynamicId:   700000000000000021,
+				Rid:         800002,
+				Timestamp:   1619990000,
+				UserProfile: xiaoHong,
+				Content:     "新衣服",
+				Pictures:    &[]models.DynamicPicture{{ImgSrc: "https://i0.hdslb.com/bfs/album/p3.jpg", ImgWidth: 1080, ImgHeight: 1080, ImgSize: 300}},
+			},
+		}}},
+		{file: "repost_nested.json", want: []*models.Dynamic{{
+			Type:        DynamicTypeRepost,
+			DynamicId:   700000000000000030,
+			Rid:         700000000000000030,
+			Timestamp:   1620003000,
+			UserProfile: mihiru,
+			Content:     "再转发",
+			Origin: &models.Dynamic{
+				Type:        DynamicTypeRepost,
+				DynamicId:   700000000000000032,
+				Rid:         700000000000000032,
+				Timestamp:   1619985000,
+				UserProfile: xiaoHong,
+				Content:     "转发一下",
+				Origin: &models.Dynamic{
+					Type:        DynamicTypeText,
+					DynamicId:   700000000000000031,
+					UserProfile: xiaoMing,
+					Content:     "最初的动态",
+				},
+			},
+		}}},
+		{file: "repost_deleted.json", want: []*models.Dynamic{{
+			Type:        DynamicTypeRepost,
+			DynamicId:   700000000000000040,
+			Rid:         700000000000000040,
+			Timestamp:   1620004000,
+			UserProfile: mihiru,
+			Content:     "转发",
+			Origin: &models.Dynamic{
+				Type:      DynamicTypePicture,
+				DynamicId: 700000000000000041,
+				Content:   "源动态已被作者删除",
+			},
+		}}},
+		{file: "unknown.json", want: []*models.Dynamic{{
+			Type:        4303,
+			DynamicId:   700000000000000050,
+			Rid:         1001,
+			Timestamp:   1620005000,
+			UserProfile: mihiru,
+			Card:        `{"id":1001,"title":"课程标题","subtitle":"课程副标题","cover":"https://i0.hdslb.com/bfs/cheese/c.jpg","url":"https://www.bilibili.com/cheese/play/ss1001","ep_count":10,"up_info":{"avatar":"https://i0.hdslb.com/bfs/face/mihiru.jpg","name":"米鲁"}}`,
+		}}},
+	}
+	for _, test := range tests {
+		t.Run(test.file, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join("testdata", test.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			dynamics, err := ParseDynamics(data)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(dynamics, test.want) {
+				got, _ := json.MarshalIndent(dynamics, "", "  ")
+				want, _ := json.MarshalIndent(test.want, "", "  ")
+				t.Errorf("unexpected dynamics\ngot:  %s\nwant: %s", got, want)
+			}
+		})
+	}
+}
+
+func TestParseDynamicsError(t *testing.T) {
+	if _, err := ParseDynamics([]byte(`{"code":-400,"message":"请求错误","data":null}`)); err == nil {
+		t.Error("expected an error for a failed response")
+	}
+}
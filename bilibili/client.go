@@ -0,0 +1,167 @@
+package bilibili
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultDynamicBaseUrl = "https://api.vc.bilibili.com"
+const defaultLiveBaseUrl = "https://api.live.bilibili.com"
+const defaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/91.0.4472.124 Safari/537.36"
+
+type Client struct {
+	DynamicBaseUrl string
+	LiveBaseUrl    string
+	UserAgent      string
+	HttpClient     *http.Client
+}
+
+type SpaceHistoryPage struct {
+	Dynamics   []byte
+	HasMore    bool
+	NextOffset int64
+}
+
+type RoomInfo struct {
+	RoomId     int64
+	Uid        int64
+	Title      string
+	LiveStatus int
+	LiveTime   int64
+}
+
+const LiveStatusLive = 1
+
+func NewClient(dynamicBaseUrl string, liveBaseUrl string, userAgent string) *Client {
+	if dynamicBaseUrl == "" {
+		dynamicBaseUrl = defaultDynamicBaseUrl
+	}
+	if liveBaseUrl == "" {
+		liveBaseUrl = defaultLiveBaseUrl
+	}
+	if userAgent == "" {
+		userAgent = defaultUserAgent
+	}
+	return &Client{
+		DynamicBaseUrl: strings.TrimSuffix(dynamicBaseUrl, "/"),
+		LiveBaseUrl:    strings.TrimSuffix(liveBaseUrl, "/"),
+		UserAgent:      userAgent,
+		HttpClient:     &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+func (c *Client) SpaceHistory(uid int64, offsetDynamicId int64) (*SpaceHistoryPage, error) {
+	query := url.Values{}
+	query.Set("host_uid", strconv.FormatInt(uid, 10))
+	query.Set("offset_dynamic_id", strconv.FormatInt(offsetDynamicId, 10))
+	body, err := c.get(c.DynamicBaseUrl + "/dynamic_svr/v1/dynamic_svr/space_history?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			HasMore    int             `json:"has_more"`
+			NextOffset json.Number     `json:"next_offset"`
+			Cards      json.RawMessage `json:"cards"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	if response.Code != 0 {
+		return nil, fmt.Errorf("space_history returned %d: %s", response.Code, response.Message)
+	}
+	page := new(SpaceHistoryPage)
+	page.Dynamics = response.Data.Cards
+	if len(page.Dynamics) == 0 || string(page.Dynamics) == "null" {
+		page.Dynamics = []byte("[]")
+	}
+	page.HasMore = response.Data.HasMore == 1
+	page.NextOffset, _ = response.Data.NextOffset.Int64()
+	return page, nil
+}
+
+func (c *Client) RoomInfoByUid(uid int64) (*RoomInfo, error) {
+	body, err := c.get(c.LiveBaseUrl + "/room/v1/Room/getRoomInfoOld?mid=" + strconv.FormatInt(uid, 10))
+	if err != nil {
+		return nil, err
+	}
+	var oldResponse struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			RoomId int64 `json:"roomid"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(body, &oldResponse); err != nil {
+		return nil, err
+	}
+	if oldResponse.Code != 0 {
+		return nil, fmt.Errorf("getRoomInfoOld returned %d: %s", oldResponse.Code, oldResponse.Message)
+	}
+	if oldResponse.Data.RoomId == 0 {
+		return nil, errors.New("user has no live room")
+	}
+	body, err = c.get(c.LiveBaseUrl + "/room/v1/Room/get_info?room_id=" + strconv.FormatInt(oldResponse.Data.RoomId, 10))
+	if err != nil {
+		return nil, err
+	}
+	var response struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+		Data    struct {
+			RoomId     int64  `json:"room_id"`
+			Uid        int64  `json:"uid"`
+			Title      string `json:"title"`
+			LiveStatus int    `json:"live_status"`
+			LiveTime   string `json:"live_time"`
+		} `json:"data"`
+	}
+	if err = json.Unmarshal(body, &response); err != nil {
+		return nil, err
+	}
+	if response.Code != 0 {
+		return nil, fmt.Errorf("get_info returned %d: %s", response.Code, response.Message)
+	}
+	roomInfo := &RoomInfo{
+		RoomId:     response.Data.RoomId,
+		Uid:        response.Data.Uid,
+		Title:      response.Data.Title,
+		LiveStatus: response.Data.LiveStatus,
+	}
+	if response.Data.LiveStatus == LiveStatusLive {
+		loc, _ := time.LoadLocation("Asia/Shanghai")
+		liveTime, err := time.ParseInLocation("2006-01-02 15:04:05", response.Data.LiveTime, loc)
+		if err == nil {
+			roomInfo.LiveTime = liveTime.Unix()
+		}
+	}
+	return roomInfo, nil
+}
+
+func (c *Client) get(requestUrl string) ([]byte, error) {
+	request, err := http.NewRequest(http.MethodGet, requestUrl, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", c.UserAgent)
+	request.Header.Set("Accept", "application/json")
+	response, err := c.HttpClient.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s returned %d", requestUrl, response.StatusCode)
+	}
+	return ioutil.ReadAll(response.Body)
+}
@@ -0,0 +1,133 @@
+package bilibili
+
+import (
+	"html"
+	"mihiru-go/models"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const ctrlTypeMention = 1
+
+var markupPattern = regexp.MustCompile(`#([^#\n]{1,64})#|\[([^\[\]\n]{1,20})\]|https?://[^\s<>"'\x{3000}-\x{303f}\x{ff00}-\x{ffef}]+`)
+
+// Tokenize splits the content of a dynamic into tokens. The ranges of ctrls are counted in UTF-16 code units
+// like the javascript strings of the web client, so an emoji out of the BMP takes 2 and a CJK character 1.
+// Topics, emojis and links are recognized in the text out of the ranges, invalid or overlapping ranges are ignored
+func Tokenize(content string, ctrls []models.DynamicCtrl) []*models.RichTextToken {
+	units := utf16.Encode([]rune(content))
+	sorted := append([]models.DynamicCtrl{}, ctrls...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Location < sorted[j].Location
+	})
+	tokens := []*models.RichTextToken{}
+	position := 0
+	for _, ctrl := range sorted {
+		if ctrl.Type != ctrlTypeMention || ctrl.Location < position || ctrl.Length <= 0 || ctrl.Location+ctrl.Length > len(units) {
+			continue
+		}
+		start, end := surrogateBoundary(units, ctrl.Location, false), surrogateBoundary(units, ctrl.Location+ctrl.Length, true)
+		if start < position {
+			continue
+		}
+		tokens = append(tokens, tokenizeText(string(utf16.Decode(units[position:start])))...)
+		token := &models.RichTextToken{Type: models.RichTextTokenMention, Text: string(utf16.Decode(units[start:end]))}
+		if uid, err := strconv.ParseInt(ctrl.Data, 10, 64); err == nil {
+			token.Uid = uid
+			token.Url = SpaceUrl(uid)
+		}
+		tokens = append(tokens, token)
+		position = end
+	}
+	return append(tokens, tokenizeText(string(utf16.Decode(units[position:])))...)
+}
+
+// RenderHtml renders the tokens as escaped html, line breaks become <br>
+func RenderHtml(tokens []*models.RichTextToken) string {
+	var builder strings.Builder
+	for _, token := range tokens {
+		text := strings.ReplaceAll(html.EscapeString(token.Text), "\n", "<br>")
+		switch {
+		case token.Type == models.RichTextTokenEmoji:
+			builder.WriteString(`<span class="emoji">` + text + `</span>`)
+		case token.Type != models.RichTextTokenText && token.Url != "":
+			rel := "noopener"
+			if token.Type == models.RichTextTokenLink {
+				rel = "noopener nofollow"
+			}
+			builder.WriteString(`<a class="` + token.Type + `" href="` + html.EscapeString(token.Url) + `" target="_blank" rel="` + rel + `">` + text + `</a>`)
+		default:
+			builder.WriteString(text)
+		}
+	}
+	return builder.String()
+}
+
+func tokenizeText(text string) []*models.RichTextToken {
+	var tokens []*models.RichTextToken
+	position := 0
+	for _, match := range markupPattern.FindAllStringSubmatchIndex(text, -1) {
+		if match[0] > position {
+			tokens = append(tokens, &models.RichTextToken{Type: models.RichTextTokenText, Text: text[position:match[0]]})
+		}
+		end := match[1]
+		if match[2] < 0 && match[4] < 0 {
+			end = match[0] + len(trimLinkPunctuation(text[match[0]:end]))
+		}
+		token := &models.RichTextToken{Text: text[match[0]:end]}
+		switch {
+		case match[2] >= 0:
+			token.Type = models.RichTextTokenTopic
+			token.Name = text[match[2]:match[3]]
+			token.Url = "https://t.bilibili.com/topic/name/" + url.PathEscape(token.Name)
+		case match[4] >= 0:
+			token.Type = models.RichTextTokenEmoji
+			token.Name = text[match[4]:match[5]]
+		default:
+			token.Type = models.RichTextTokenLink
+			token.Url = token.Text
+		}
+		tokens = append(tokens, token)
+		position = end
+	}
+	if position < len(text) {
+		tokens = append(tokens, &models.RichTextToken{Type: models.RichTextTokenText, Text: text[position:]})
+	}
+	return tokens
+}
+
+// trimLinkPunctuation drops the punctuation ending a sentence after a link, a closing parenthesis is kept when it is
+// paired inside the link like https://en.wikipedia.org/wiki/Go_(programming_language)
+func trimLinkPunctuation(link string) string {
+	for link != "" {
+		last := link[len(link)-1]
+		if last == ')' && strings.Count(link, "(") >= strings.Count(link, ")") {
+			break
+		}
+		if !strings.ContainsRune(".,;:!?)", rune(last)) {
+			break
+		}
+		link = link[:len(link)-1]
+	}
+	return link
+}
+
+// surrogateBoundary moves an index off the middle of a surrogate pair, forward for the end of a range
+func surrogateBoundary(units []uint16, index int, forward bool) int {
+	if index <= 0 || index >= len(units) || !utf16.IsSurrogate(rune(units[index])) || units[index] < 0xdc00 {
+		return index
+	}
+	if forward {
+		return index + 1
+	}
+	return index - 1
+}
+
+// SpaceUrl is the personal page of the user
+func SpaceUrl(uid int64) string {
+	return "https://space.bilibili.com/" + strconv.FormatInt(uid, 10)
+}
@@ -0,0 +1,137 @@
+package bilibili
+
+import (
+	"encoding/json"
+	"mihiru-go/models"
+	"reflect"
+	"testing"
+)
+
+func mention(location int, length int, uid string) models.DynamicCtrl {
+	return models.DynamicCtrl{Data: uid, Length: length, Location: location, Type: ctrlTypeMention}
+}
+
+func TestTokenize(t *testing.T) {
+	xiaoMingToken := &models.RichTextToken{Type: models.RichTextTokenMention, Text: "@小明", Uid: 20002, Url: "https://space.bilibili.com/20002"}
+	tests := []struct {
+		name    string
+		content string
+		ctrls   []models.DynamicCtrl
+		want    []*models.RichTextToken
+	}{
+		{
+			name:    "mention after an emoji out of the BMP",
+			content: "😀@小明 你好",
+			ctrls:   []models.DynamicCtrl{mention(2, 3, "20002")},
+			want: []*models.RichTextToken{
+				{Type: models.RichTextTokenText, Text: "😀"},
+				xiaoMingToken,
+				{Type: models.RichTextTokenText, Text: " 你好"},
+			},
+		},
+		{
+			name:    "range ending inside a surrogate pair",
+			content: "@小明😀好",
+			ctrls:   []models.DynamicCtrl{mention(0, 4, "20002")},
+			want: []*models.RichTextToken{
+				{Type: models.RichTextTokenMention, Text: "@小明😀", Uid: 20002, Url: "https://space.bilibili.com/20002"},
+				{Type: models.RichTextTokenText, Text: "好"},
+			},
+		},
+		{
+			name:    "range starting inside a surrogate pair",
+			content: "😀@小明",
+			ctrls:   []models.DynamicCtrl{mention(1, 4, "20002")},
+			want: []*models.RichTextToken{
+				{Type: models.RichTextTokenMention, Text: "😀@小明", Uid: 20002, Url: "https://space.bilibili.com/20002"},
+			},
+		},
+		{
+			name:    "overlapping ranges",
+			content: "@小明@小红 hi",
+			ctrls:   []models.DynamicCtrl{mention(2, 4, "30003"), mention(0, 3, "20002")},
+			want: []*models.RichTextToken{
+				xiaoMingToken,
+				{Type: models.RichTextTokenText, Text: "@小红 hi"},
+			},
+		},
+		{
+			name:    "invalid ranges",
+			content: "@小明",
+			ctrls: []models.DynamicCtrl{
+				mention(2, 10, "20002"),
+				mention(-1, 2, "20002"),
+				mention(0, 0, "20002"),
+				{Data: "42", Length: 3, Location: 0, Type: 2},
+			},
+			want: []*models.RichTextToken{{Type: models.RichTextTokenText, Text: "@小明"}},
+		},
+		{
+			name:    "mention without uid",
+			content: "@小明",
+			ctrls:   []models.DynamicCtrl{mention(0, 3, "")},
+			want:    []*models.RichTextToken{{Type: models.RichTextTokenMention, Text: "@小明"}},
+		},
+		{
+			name:    "topic, emoji and link",
+			content: "#话题# [微笑] 看 https://b23.tv/abc",
+			want: []*models.RichTextToken{
+				{Type: models.RichTextTokenTopic, Text: "#话题#", Name: "话题", Url: "https://t.bilibili.com/topic/name/%E8%AF%9D%E9%A2%98"},
+				{Type: models.RichTextTokenText, Text: " "},
+				{Type: models.RichTextTokenEmoji, Text: "[微笑]", Name: "微笑"},
+				{Type: models.RichTextTokenText, Text: " 看 "},
+				{Type: models.RichTextTokenLink, Text: "https://b23.tv/abc", Url: "https://b23.tv/abc"},
+			},
+		},
+		{
+			name:    "punctuation after a link",
+			content: "看 https://b23.tv/abc?x=1. 和 https://b23.tv/def!?",
+			want: []*models.RichTextToken{
+				{Type: models.RichTextTokenText, Text: "看 "},
+				{Type: models.RichTextTokenLink, Text: "https://b23.tv/abc?x=1", Url: "https://b23.tv/abc?x=1"},
+				{Type: models.RichTextTokenText, Text: ". 和 "},
+				{Type: models.RichTextTokenLink, Text: "https://b23.tv/def", Url: "https://b23.tv/def"},
+				{Type: models.RichTextTokenText, Text: "!?"},
+			},
+		},
+		{
+			name:    "parenthesis around a link",
+			content: "(https://en.wikipedia.org/wiki/Go_(programming_language)), (https://b23.tv/abc)",
+			want: []*models.RichTextToken{
+				{Type: models.RichTextTokenText, Text: "("},
+				{Type: models.RichTextTokenLink, Text: "https://en.wikipedia.org/wiki/Go_(programming_language)", Url: "https://en.wikipedia.org/wiki/Go_(programming_language)"},
+				{Type: models.RichTextTokenText, Text: "), ("},
+				{Type: models.RichTextTokenLink, Text: "https://b23.tv/abc", Url: "https://b23.tv/abc"},
+				{Type: models.RichTextTokenText, Text: ")"},
+			},
+		},
+		{
+			name:    "link ended by full width punctuation",
+			content: "https://b23.tv/abc，好",
+			want: []*models.RichTextToken{
+				{Type: models.RichTextTokenLink, Text: "https://b23.tv/abc", Url: "https://b23.tv/abc"},
+				{Type: models.RichTextTokenText, Text: "，好"},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokens := Tokenize(test.content, test.ctrls)
+			if !reflect.DeepEqual(tokens, test.want) {
+				got, _ := json.Marshal(tokens)
+				want, _ := json.Marshal(test.want)
+				t.Errorf("unexpected tokens\ngot:  %s\nwant: %s", got, want)
+			}
+		})
+	}
+}
+
+func TestRenderHtml(t *testing.T) {
+	content := "<b>@小明</b> & \"[微笑]\"\nhttps://example.com/?a=1&b='2'"
+	tokens := Tokenize(content, []models.DynamicCtrl{mention(3, 3, "20002")})
+	want := `&lt;b&gt;<a class="mention" href="https://space.bilibili.com/20002" target="_blank" rel="noopener">@小明</a>&lt;/b&gt; &amp; &#34;<span class="emoji">[微笑]</span>&#34;<br>` +
+		`<a class="link" href="https://example.com/?a=1&amp;b=" target="_blank" rel="noopener nofollow">https://example.com/?a=1&amp;b=</a>&#39;2&#39;`
+	if got := RenderHtml(tokens); got != want {
+		t.Errorf("unexpected html\ngot:  %s\nwant: %s", got, want)
+	}
+}
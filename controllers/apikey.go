@@ -0,0 +1,63 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"mihiru-go/dto"
+	"mihiru-go/services"
+	"mihiru-go/util"
+	"net/http"
+)
+
+type ApiKeyController interface {
+	Add(c *gin.Context)
+	List(c *gin.Context)
+	Delete(c *gin.Context)
+}
+
+type apiKeyController struct {
+	service services.ApiKeyService
+}
+
+func NewApiKeyController(service services.ApiKeyService) ApiKeyController {
+	return apiKeyController{service: service}
+}
+
+func (a apiKeyController) Add(c *gin.Context) {
+	var apiKeyDto dto.ApiKeyDto
+	if err := c.BindJSON(&apiKeyDto); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	apiKeyVo, err := a.service.Add(util.GetOperator(c), &apiKeyDto)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, apiKeyVo)
+}
+
+func (a apiKeyController) List(c *gin.Context) {
+	apiKeys, err := a.service.List()
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, apiKeys)
+}
+
+func (a apiKeyController) Delete(c *gin.Context) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	err = a.service.Delete(util.GetOperator(c), hex)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
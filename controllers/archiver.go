@@ -0,0 +1,39 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"mihiru-go/services"
+	"mihiru-go/util"
+	"net/http"
+)
+
+type ArchiverController interface {
+	Status(c *gin.Context)
+	Run(c *gin.Context)
+}
+
+type archiverController struct {
+	service services.ArchiverService
+}
+
+func NewArchiverController(service services.ArchiverService) ArchiverController {
+	return archiverController{service: service}
+}
+
+func (a archiverController) Status(c *gin.Context) {
+	status, err := a.service.Status()
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, status)
+}
+
+func (a archiverController) Run(c *gin.Context) {
+	result, err := a.service.Run()
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
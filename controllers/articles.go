@@ -35,7 +35,7 @@ func (m articlesController) Add(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
 		return
 	}
-	articleVo, err := m.articleService.Add(&articleDto)
+	articleVo, err := m.articleService.Add(util.GetOperator(c), &articleDto)
 	if err != nil {
 		util.ErrorResponse(c, err)
 		return
@@ -59,7 +59,7 @@ func (m articlesController) Update(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的id参数"})
 		return
 	}
-	articleVo, err := m.articleService.Update(intId, &articleDto)
+	articleVo, err := m.articleService.Update(util.GetOperator(c), intId, &articleDto)
 	if err != nil {
 		util.ErrorResponse(c, err)
 		return
@@ -85,7 +85,7 @@ func (m articlesController) Get(c *gin.Context) {
 		return
 	}
 	if articleVo.Hide > 0 {
-		if !m.checkIsAdmin(c) {
+		if !m.checkPermission(c, models.PermissionArticleReadHidden) {
 			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "无权访问"})
 			return
 		}
@@ -118,7 +118,7 @@ func (m articlesController) Search(c *gin.Context) {
 	if err := c.BindJSON(&articleSearchParams); err != nil {
 		return
 	}
-	if articleSearchParams.ShowHide != nil && *articleSearchParams.ShowHide && !m.checkIsAdmin(c) {
+	if articleSearchParams.ShowHide != nil && *articleSearchParams.ShowHide && !m.checkPermission(c, models.PermissionArticleReadHidden) {
 		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "无权访问"})
 		return
 	}
@@ -130,7 +130,7 @@ func (m articlesController) Search(c *gin.Context) {
 	c.JSON(http.StatusOK, result)
 }
 
-func (m articlesController) checkIsAdmin(c *gin.Context) bool {
+func (m articlesController) checkPermission(c *gin.Context, permission string) bool {
 	token := c.GetHeader("authorization")
 	if token == "" {
 		return false
@@ -139,12 +139,5 @@ func (m articlesController) checkIsAdmin(c *gin.Context) bool {
 	if user == nil {
 		return false
 	}
-	hasPermission := false
-	for _, userRole := range user.Roles {
-		if userRole == "admin" {
-			hasPermission = true
-			break
-		}
-	}
-	return hasPermission
+	return user.HasPermission(permission)
 }
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"log"
+	"mihiru-go/models"
+	"mihiru-go/services"
+	"mihiru-go/util"
+	"net/http"
+)
+
+type AuditController interface {
+	Search(c *gin.Context)
+}
+
+type auditController struct {
+	service services.AuditService
+}
+
+func NewAuditController(service services.AuditService) AuditController {
+	return auditController{service: service}
+}
+
+func (a auditController) Search(c *gin.Context) {
+	var auditSearchParams models.AuditSearchParams
+	if err := c.ShouldBindQuery(&auditSearchParams); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	result, err := a.service.Search(&auditSearchParams)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,34 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"mihiru-go/services"
+	"mihiru-go/util"
+	"net/http"
+)
+
+type CrawlerController interface {
+	Status(c *gin.Context)
+	Run(c *gin.Context)
+}
+
+type crawlerController struct {
+	service services.CrawlerService
+}
+
+func NewCrawlerController(service services.CrawlerService) CrawlerController {
+	return crawlerController{service: service}
+}
+
+func (cc crawlerController) Status(c *gin.Context) {
+	c.JSON(http.StatusOK, cc.service.Status())
+}
+
+func (cc crawlerController) Run(c *gin.Context) {
+	result, err := cc.service.Run()
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,197 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"mihiru-go/dto"
+	"mihiru-go/models"
+	"mihiru-go/util"
+	"net/http"
+	"strconv"
+)
+
+func (m memoryController) AddLiveCut(c *gin.Context) {
+	var cut models.LiveCut
+	if err := c.BindJSON(&cut); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	id, version, _, ok := liveEditParams(c)
+	if !ok {
+		return
+	}
+	position, ok := positionParam(c)
+	if !ok {
+		return
+	}
+	liveVo, err := m.service.AddLiveCut(util.GetOperator(c), id, version, position, &cut)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, liveVo)
+}
+
+func (m memoryController) UpdateLiveCut(c *gin.Context) {
+	var cut models.LiveCut
+	if err := c.BindJSON(&cut); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	id, version, indexes, ok := liveEditParams(c, "cut")
+	if !ok {
+		return
+	}
+	liveVo, err := m.service.UpdateLiveCut(util.GetOperator(c), id, version, indexes[0], &cut)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, liveVo)
+}
+
+func (m memoryController) DeleteLiveCut(c *gin.Context) {
+	id, version, indexes, ok := liveEditParams(c, "cut")
+	if !ok {
+		return
+	}
+	liveVo, err := m.service.DeleteLiveCut(util.GetOperator(c), id, version, indexes[0])
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, liveVo)
+}
+
+func (m memoryController) ReorderLiveCuts(c *gin.Context) {
+	var orderDto dto.OrderDto
+	if err := c.BindJSON(&orderDto); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	id, version, _, ok := liveEditParams(c)
+	if !ok {
+		return
+	}
+	liveVo, err := m.service.ReorderLiveCuts(util.GetOperator(c), id, version, orderDto.Order)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, liveVo)
+}
+
+func (m memoryController) AddLiveCutDialogue(c *gin.Context) {
+	var dialogue models.LiveCutDialogue
+	if err := c.BindJSON(&dialogue); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	id, version, indexes, ok := liveEditParams(c, "cut")
+	if !ok {
+		return
+	}
+	position, ok := positionParam(c)
+	if !ok {
+		return
+	}
+	liveVo, err := m.service.AddLiveCutDialogue(util.GetOperator(c), id, version, indexes[0], position, &dialogue)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, liveVo)
+}
+
+func (m memoryController) UpdateLiveCutDialogue(c *gin.Context) {
+	var dialogue models.LiveCutDialogue
+	if err := c.BindJSON(&dialogue); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	id, version, indexes, ok := liveEditParams(c, "cut", "dialogue")
+	if !ok {
+		return
+	}
+	liveVo, err := m.service.UpdateLiveCutDialogue(util.GetOperator(c), id, version, indexes[0], indexes[1], &dialogue)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, liveVo)
+}
+
+func (m memoryController) DeleteLiveCutDialogue(c *gin.Context) {
+	id, version, indexes, ok := liveEditParams(c, "cut", "dialogue")
+	if !ok {
+		return
+	}
+	liveVo, err := m.service.DeleteLiveCutDialogue(util.GetOperator(c), id, version, indexes[0], indexes[1])
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, liveVo)
+}
+
+func (m memoryController) ReorderLiveCutDialogues(c *gin.Context) {
+	var orderDto dto.OrderDto
+	if err := c.BindJSON(&orderDto); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	id, version, indexes, ok := liveEditParams(c, "cut")
+	if !ok {
+		return
+	}
+	liveVo, err := m.service.ReorderLiveCutDialogues(util.GetOperator(c), id, version, indexes[0], orderDto.Order)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, liveVo)
+}
+
+// liveEditParams parses the id of the live, the version query parameter (the last_modified of the live)
+// and the index path parameters, the request is aborted when any of them is invalid
+func liveEditParams(c *gin.Context, indexNames ...string) (primitive.ObjectID, int64, []int, bool) {
+	id, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return id, 0, nil, false
+	}
+	version, err := strconv.ParseInt(c.Query("version"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "缺少version参数"})
+		return id, 0, nil, false
+	}
+	indexes := make([]int, len(indexNames))
+	for i, name := range indexNames {
+		if indexes[i], err = strconv.Atoi(c.Param(name)); err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+			return id, 0, nil, false
+		}
+	}
+	return id, version, indexes, true
+}
+
+// positionParam parses the optional position query parameter, nil for appending
+func positionParam(c *gin.Context) (*int, bool) {
+	value := c.Query("position")
+	if value == "" {
+		return nil, true
+	}
+	position, err := strconv.Atoi(value)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的位置参数"})
+		return nil, false
+	}
+	return &position, true
+}
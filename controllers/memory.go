@@ -4,20 +4,54 @@ import (
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"log"
+	"mihiru-go/bilibili"
 	"mihiru-go/models"
 	"mihiru-go/services"
+	"mihiru-go/subtitle"
 	"mihiru-go/util"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type MemoryController interface {
 	AddDynamic(c *gin.Context)
 	UpdateDynamic(c *gin.Context)
+	UpsertDynamic(c *gin.Context)
+	BatchUpsertDynamic(c *gin.Context)
+	ImportRawDynamic(c *gin.Context)
 	AddLive(c *gin.Context)
 	UpdateLive(c *gin.Context)
+	DeleteDynamic(c *gin.Context)
+	RestoreDynamic(c *gin.Context)
+	PurgeDynamic(c *gin.Context)
+	DeleteLive(c *gin.Context)
+	RestoreLive(c *gin.Context)
+	PurgeLive(c *gin.Context)
 	Days(c *gin.Context)
+	Months(c *gin.Context)
+	Years(c *gin.Context)
+	Heatmap(c *gin.Context)
+	Search(c *gin.Context)
+	Timeline(c *gin.Context)
+	CoStreamers(c *gin.Context)
+	CoStreamerLives(c *gin.Context)
+	Export(c *gin.Context)
+	Import(c *gin.Context)
+	CutSubtitle(c *gin.Context)
+	ImportCutSubtitle(c *gin.Context)
+	AddLiveCut(c *gin.Context)
+	UpdateLiveCut(c *gin.Context)
+	DeleteLiveCut(c *gin.Context)
+	ReorderLiveCuts(c *gin.Context)
+	AddLiveCutDialogue(c *gin.Context)
+	UpdateLiveCutDialogue(c *gin.Context)
+	DeleteLiveCutDialogue(c *gin.Context)
+	ReorderLiveCutDialogues(c *gin.Context)
 	Day(c *gin.Context)
+	DayItems(c *gin.Context)
+	OnThisDay(c *gin.Context)
 }
 
 type memoryController struct {
@@ -35,7 +69,7 @@ func (m memoryController) AddDynamic(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
 		return
 	}
-	dynamicVo, err := m.service.AddDynamic(&dynamic)
+	dynamicVo, err := m.service.AddDynamic(util.GetOperator(c), &dynamic)
 	if err != nil {
 		util.ErrorResponse(c, err)
 		return
@@ -56,7 +90,7 @@ func (m memoryController) UpdateDynamic(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
 		return
 	}
-	dynamicVo, err := m.service.UpdateDynamic(hex, &dynamic)
+	dynamicVo, err := m.service.UpdateDynamic(util.GetOperator(c), hex, &dynamic)
 	if err != nil {
 		util.ErrorResponse(c, err)
 		return
@@ -64,6 +98,68 @@ func (m memoryController) UpdateDynamic(c *gin.Context) {
 	c.JSON(http.StatusOK, dynamicVo)
 }
 
+func (m memoryController) UpsertDynamic(c *gin.Context) {
+	var dynamic models.Dynamic
+	if err := c.BindJSON(&dynamic); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	dynamicId, err := strconv.ParseInt(c.Param("dynamicId"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的dynamic_id参数"})
+		return
+	}
+	if dynamic.DynamicId == 0 {
+		dynamic.DynamicId = dynamicId
+	} else if dynamic.DynamicId != dynamicId {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "dynamic_id与路径参数不一致"})
+		return
+	}
+	upsertVo, err := m.service.UpsertDynamic(util.GetOperator(c), &dynamic)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, upsertVo)
+}
+
+func (m memoryController) BatchUpsertDynamic(c *gin.Context) {
+	var dynamics []*models.Dynamic
+	if err := c.BindJSON(&dynamics); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	result, err := m.service.BatchUpsertDynamic(util.GetOperator(c), dynamics)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (m memoryController) ImportRawDynamic(c *gin.Context) {
+	data, err := c.GetRawData()
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "读取请求内容失败"})
+		return
+	}
+	dynamics, err := bilibili.ParseDynamics(data)
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无法解析的动态数据"})
+		return
+	}
+	result, err := m.service.BatchUpsertDynamic(util.GetOperator(c), dynamics)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
 func (m memoryController) AddLive(c *gin.Context) {
 	var live models.Live
 	if err := c.BindJSON(&live); err != nil {
@@ -71,7 +167,7 @@ func (m memoryController) AddLive(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
 		return
 	}
-	liveVo, err := m.service.AddLive(&live)
+	liveVo, err := m.service.AddLive(util.GetOperator(c), &live)
 	if err != nil {
 		util.ErrorResponse(c, err)
 		return
@@ -92,7 +188,7 @@ func (m memoryController) UpdateLive(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
 		return
 	}
-	liveVo, err := m.service.UpdateLive(hex, &live)
+	liveVo, err := m.service.UpdateLive(util.GetOperator(c), hex, &live)
 	if err != nil {
 		util.ErrorResponse(c, err)
 		return
@@ -100,30 +196,341 @@ func (m memoryController) UpdateLive(c *gin.Context) {
 	c.JSON(http.StatusOK, liveVo)
 }
 
+func (m memoryController) DeleteDynamic(c *gin.Context) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	err = m.service.DeleteDynamic(util.GetOperator(c), hex)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (m memoryController) RestoreDynamic(c *gin.Context) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	err = m.service.RestoreDynamic(util.GetOperator(c), hex)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (m memoryController) PurgeDynamic(c *gin.Context) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	err = m.service.PurgeDynamic(util.GetOperator(c), hex)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (m memoryController) DeleteLive(c *gin.Context) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	err = m.service.DeleteLive(util.GetOperator(c), hex)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (m memoryController) RestoreLive(c *gin.Context) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	err = m.service.RestoreLive(util.GetOperator(c), hex)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (m memoryController) PurgeLive(c *gin.Context) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	err = m.service.PurgeLive(util.GetOperator(c), hex)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
 func (m memoryController) Days(c *gin.Context) {
-	days, version, err := m.service.Days()
+	loc, err := m.service.Location(c.Query("tz"))
 	if err != nil {
 		util.ErrorResponse(c, err)
 		return
 	}
-	etag := strconv.FormatInt(version, 10)
-	c.Header("ETag", etag)
-	c.Header("Cache-Control", "public, max-age=300, must-revalidate")
-	if match := c.GetHeader("If-None-Match"); match == etag {
-		c.AbortWithStatus(http.StatusNotModified)
+	days, version, err := m.service.Days(loc, c.Query("from"), c.Query("to"))
+	if err != nil {
+		util.ErrorResponse(c, err)
 		return
 	}
-	c.JSON(http.StatusOK, days)
+	versionedResponse(c, memoryETag(version, loc), days)
+}
+
+func (m memoryController) Months(c *gin.Context) {
+	loc, err := m.service.Location(c.Query("tz"))
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	months, version, err := m.service.Months(loc, c.Query("from"), c.Query("to"))
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	versionedResponse(c, memoryETag(version, loc), months)
+}
+
+func (m memoryController) Years(c *gin.Context) {
+	loc, err := m.service.Location(c.Query("tz"))
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	years, version, err := m.service.Years(loc)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	versionedResponse(c, memoryETag(version, loc), years)
+}
+
+func (m memoryController) Heatmap(c *gin.Context) {
+	loc, err := m.service.Location(c.Query("tz"))
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	heatmap, version, err := m.service.Heatmap(c.Param("year"), loc)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	versionedResponse(c, memoryETag(version, loc), heatmap)
+}
+
+func (m memoryController) Search(c *gin.Context) {
+	var searchParams models.MemorySearchParams
+	if err := c.ShouldBindQuery(&searchParams); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	result, err := m.service.Search(&searchParams)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (m memoryController) Timeline(c *gin.Context) {
+	var timelineParams models.MemoryTimelineParams
+	if err := c.ShouldBindQuery(&timelineParams); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	result, err := m.service.Timeline(&timelineParams)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (m memoryController) CoStreamers(c *gin.Context) {
+	loc, err := m.service.Location(c.Query("tz"))
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	coStreamers, version, err := m.service.CoStreamers(loc)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	versionedResponse(c, memoryETag(version, loc), coStreamers)
+}
+
+func (m memoryController) CoStreamerLives(c *gin.Context) {
+	uid, err := strconv.ParseInt(c.Param("uid"), 10, 64)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	var pageParams models.PageParams
+	if err := c.ShouldBindQuery(&pageParams); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	livePage, err := m.service.CoStreamerLives(uid, &pageParams)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, livePage)
+}
+
+func (m memoryController) Export(c *gin.Context) {
+	var exportParams models.MemoryExportParams
+	if err := c.ShouldBindQuery(&exportParams); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	c.Header("Content-Type", "application/x-ndjson")
+	c.Header("Content-Disposition", "attachment; filename=\"memory-"+time.Now().Format("20060102")+".ndjson\"")
+	err := m.service.Export(&exportParams, c.Writer)
+	if err == nil {
+		return
+	}
+	if c.Writer.Written() {
+		// the response has started, the client sees a truncated file
+		util.LogError(err)
+		return
+	}
+	c.Writer.Header().Del("Content-Type")
+	c.Writer.Header().Del("Content-Disposition")
+	util.ErrorResponse(c, err)
+}
+
+func (m memoryController) Import(c *gin.Context) {
+	dryRun, err := strconv.ParseBool(c.DefaultQuery("dry_run", "false"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	result, err := m.service.Import(util.GetOperator(c), c.Request.Body, dryRun)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (m memoryController) CutSubtitle(c *gin.Context) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	cutIndex, err := strconv.Atoi(c.Param("cut"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	format := c.DefaultQuery("format", subtitle.FormatSrt)
+	data, fileName, err := m.service.CutSubtitle(hex, cutIndex, format)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.Header("Content-Disposition", "attachment; filename=\""+fileName+"\"")
+	c.Data(http.StatusOK, subtitle.ContentType(format), data)
+}
+
+func (m memoryController) ImportCutSubtitle(c *gin.Context) {
+	id, version, indexes, ok := liveEditParams(c, "cut")
+	if !ok {
+		return
+	}
+	data, err := c.GetRawData()
+	if err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "读取请求内容失败"})
+		return
+	}
+	liveVo, err := m.service.ImportCutSubtitle(util.GetOperator(c), id, version, indexes[0], c.Query("format"), data)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, liveVo)
 }
 
 func (m memoryController) Day(c *gin.Context) {
+	loc, err := m.service.Location(c.Query("tz"))
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
 	day := c.Param("day")
-	data, version, err := m.service.Day(day)
+	data, version, err := m.service.Day(day, loc)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	dayResponse(c, memoryETag(version, loc), data)
+}
+
+func (m memoryController) DayItems(c *gin.Context) {
+	loc, err := m.service.Location(c.Query("tz"))
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	var kinds []string
+	if kind := c.Query("kind"); kind != "" {
+		kinds = strings.Split(kind, ",")
+	}
+	render := c.Query("render")
+	items, version, err := m.service.DayItems(c.Param("day"), loc, kinds, render)
 	if err != nil {
 		util.ErrorResponse(c, err)
 		return
 	}
-	etag := strconv.FormatInt(version, 10)
+	etag := memoryETag(version, loc)
+	if render != "" {
+		etag += "-" + render
+	}
+	dayResponse(c, etag, items)
+}
+
+func (m memoryController) OnThisDay(c *gin.Context) {
+	loc, err := m.service.Location(c.Query("tz"))
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	data, version, err := m.service.OnThisDay(c.Param("date"), loc)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	dayResponse(c, version+"-"+loc.String(), data)
+}
+
+// dayResponse is cached for a long time when the version is given in the v parameter
+func dayResponse(c *gin.Context, etag string, data interface{}) {
 	c.Header("ETag", etag)
 	if c.Query("v") != "" {
 		c.Header("Cache-Control", "public, max-age=31536000, must-revalidate")
@@ -136,3 +543,17 @@ func (m memoryController) Day(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, data)
 }
+
+func memoryETag(version int64, loc *time.Location) string {
+	return strconv.FormatInt(version, 10) + "-" + loc.String()
+}
+
+func versionedResponse(c *gin.Context, etag string, data interface{}) {
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "public, max-age=300, must-revalidate")
+	if match := c.GetHeader("If-None-Match"); match == etag {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+	c.JSON(http.StatusOK, data)
+}
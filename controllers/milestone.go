@@ -0,0 +1,99 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"mihiru-go/models"
+	"mihiru-go/services"
+	"mihiru-go/util"
+	"net/http"
+)
+
+type MilestoneController interface {
+	Add(c *gin.Context)
+	Update(c *gin.Context)
+	Delete(c *gin.Context)
+	Get(c *gin.Context)
+	List(c *gin.Context)
+}
+
+type milestoneController struct {
+	service services.MilestoneService
+}
+
+func NewMilestoneController(service services.MilestoneService) MilestoneController {
+	return milestoneController{service: service}
+}
+
+func (m milestoneController) Add(c *gin.Context) {
+	var milestone models.Milestone
+	if err := c.BindJSON(&milestone); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	milestoneVo, err := m.service.Add(util.GetOperator(c), &milestone)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, milestoneVo)
+}
+
+func (m milestoneController) Update(c *gin.Context) {
+	var milestone models.Milestone
+	if err := c.BindJSON(&milestone); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	milestoneVo, err := m.service.Update(util.GetOperator(c), hex, &milestone)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, milestoneVo)
+}
+
+func (m milestoneController) Delete(c *gin.Context) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	err = m.service.Delete(util.GetOperator(c), hex)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (m milestoneController) Get(c *gin.Context) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	milestoneVo, err := m.service.Get(hex)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, milestoneVo)
+}
+
+func (m milestoneController) List(c *gin.Context) {
+	milestones, err := m.service.List()
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, milestones)
+}
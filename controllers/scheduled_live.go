@@ -0,0 +1,102 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"mihiru-go/ical"
+	"mihiru-go/models"
+	"mihiru-go/services"
+	"mihiru-go/util"
+	"net/http"
+)
+
+type ScheduledLiveController interface {
+	Add(c *gin.Context)
+	Update(c *gin.Context)
+	Delete(c *gin.Context)
+	List(c *gin.Context)
+	Calendar(c *gin.Context)
+}
+
+type scheduledLiveController struct {
+	service services.ScheduledLiveService
+}
+
+func NewScheduledLiveController(service services.ScheduledLiveService) ScheduledLiveController {
+	return scheduledLiveController{service: service}
+}
+
+func (s scheduledLiveController) Add(c *gin.Context) {
+	var scheduledLive models.ScheduledLive
+	if err := c.BindJSON(&scheduledLive); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	data, err := s.service.Add(util.GetOperator(c), &scheduledLive)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, data)
+}
+
+func (s scheduledLiveController) Update(c *gin.Context) {
+	var scheduledLive models.ScheduledLive
+	if err := c.BindJSON(&scheduledLive); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	data, err := s.service.Update(util.GetOperator(c), hex, &scheduledLive)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, data)
+}
+
+func (s scheduledLiveController) Delete(c *gin.Context) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	err = s.service.Delete(util.GetOperator(c), hex)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (s scheduledLiveController) List(c *gin.Context) {
+	data, err := s.service.List(c.Query("all") == "true")
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, data)
+}
+
+func (s scheduledLiveController) Calendar(c *gin.Context) {
+	data, etag, err := s.service.Calendar()
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.Header("ETag", etag)
+	c.Header("Cache-Control", "public, max-age=300, must-revalidate")
+	if match := c.GetHeader("If-None-Match"); match == etag {
+		c.AbortWithStatus(http.StatusNotModified)
+		return
+	}
+	c.Header("Content-Disposition", "inline; filename=\"lives.ics\"")
+	c.Data(http.StatusOK, ical.ContentType, data)
+}
@@ -0,0 +1,100 @@
+package controllers
+
+import (
+	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"mihiru-go/models"
+	"mihiru-go/services"
+	"mihiru-go/util"
+	"net/http"
+	"strconv"
+)
+
+type UploadController interface {
+	Create(c *gin.Context)
+	WriteChunk(c *gin.Context)
+	Get(c *gin.Context)
+	List(c *gin.Context)
+	Delete(c *gin.Context)
+}
+
+type uploadController struct {
+	service services.UploadService
+}
+
+func NewUploadController(service services.UploadService) UploadController {
+	return uploadController{service: service}
+}
+
+func (u uploadController) Create(c *gin.Context) {
+	var upload models.UploadBaseFields
+	if err := c.BindJSON(&upload); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	uploadVo, err := u.service.Create(util.GetOperator(c), &upload)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, uploadVo)
+}
+
+// WriteChunk takes the raw bytes of one chunk as the request body, offset is the number of bytes already received
+func (u uploadController) WriteChunk(c *gin.Context) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	offset, err := strconv.ParseInt(c.Query("offset"), 10, 64)
+	if err != nil || offset < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的offset参数"})
+		return
+	}
+	uploadVo, err := u.service.WriteChunk(hex, offset, c.Request.Body)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, uploadVo)
+}
+
+func (u uploadController) Get(c *gin.Context) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	uploadVo, err := u.service.Get(hex)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, uploadVo)
+}
+
+func (u uploadController) List(c *gin.Context) {
+	data, err := u.service.List(c.Query("orphan") == "true")
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, data)
+}
+
+func (u uploadController) Delete(c *gin.Context) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	err = u.service.Delete(util.GetOperator(c), hex)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
@@ -2,25 +2,42 @@ package controllers
 
 import (
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"log"
+	"mihiru-go/config"
 	"mihiru-go/dto"
+	"mihiru-go/models"
 	"mihiru-go/services"
 	"mihiru-go/util"
 	"net/http"
+	"net/url"
 )
 
 type UserController interface {
 	Add(c *gin.Context)
 	Login(c *gin.Context)
 	ChangePassword(c *gin.Context)
+	List(c *gin.Context)
+	Get(c *gin.Context)
+	Me(c *gin.Context)
+	Update(c *gin.Context)
+	Enable(c *gin.Context)
+	Disable(c *gin.Context)
+	Delete(c *gin.Context)
+	Permissions(c *gin.Context)
+	Roles(c *gin.Context)
+	SetVoiceLivers(c *gin.Context)
+	OidcLogin(c *gin.Context)
+	OidcCallback(c *gin.Context)
 }
 
 type userController struct {
-	service services.UserService
+	service     services.UserService
+	oidcService services.OidcService
 }
 
-func NewUserController(service services.UserService) UserController {
-	return userController{service: service}
+func NewUserController(service services.UserService, oidcService services.OidcService) UserController {
+	return userController{service: service, oidcService: oidcService}
 }
 
 func (u userController) Add(c *gin.Context) {
@@ -30,7 +47,7 @@ func (u userController) Add(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
 		return
 	}
-	userVo, err := u.service.Add(&userDto)
+	userVo, err := u.service.Add(util.GetOperator(c), &userDto)
 	if err != nil {
 		util.ErrorResponse(c, err)
 		return
@@ -45,7 +62,7 @@ func (u userController) ChangePassword(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
 		return
 	}
-	err := u.service.ChangePassword(c.GetHeader("authorization"), changePasswordDto)
+	err := u.service.ChangePassword(util.GetOperator(c), c.GetHeader("authorization"), changePasswordDto)
 	if err != nil {
 		util.ErrorResponse(c, err)
 		return
@@ -67,3 +84,166 @@ func (u userController) Login(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{"token": token, "name": name})
 }
+
+func (u userController) List(c *gin.Context) {
+	var pageParams models.PageParams
+	if err := c.ShouldBindQuery(&pageParams); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	result, err := u.service.List(&pageParams)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, result)
+}
+
+func (u userController) Get(c *gin.Context) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	userVo, err := u.service.Get(hex)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, userVo)
+}
+
+func (u userController) Me(c *gin.Context) {
+	loginUser := util.GetLoginUser(c)
+	if loginUser.ApiKeyOwner != nil {
+		// an api key is not a user, it is described by its name, prefix and permissions
+		c.JSON(http.StatusOK, loginUser)
+		return
+	}
+	userVo, err := u.service.Get(loginUser.ID)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, userVo)
+}
+
+func (u userController) Update(c *gin.Context) {
+	var updateUserDto dto.UpdateUserDto
+	if err := c.BindJSON(&updateUserDto); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	userVo, err := u.service.Update(util.GetOperator(c), hex, &updateUserDto)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, userVo)
+}
+
+func (u userController) Enable(c *gin.Context) {
+	u.setDisabled(c, false)
+}
+
+func (u userController) Disable(c *gin.Context) {
+	u.setDisabled(c, true)
+}
+
+func (u userController) Delete(c *gin.Context) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	err = u.service.Delete(util.GetOperator(c), hex)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.Status(http.StatusOK)
+}
+
+func (u userController) Permissions(c *gin.Context) {
+	c.JSON(http.StatusOK, util.GetLoginUser(c).Permissions)
+}
+
+func (u userController) Roles(c *gin.Context) {
+	c.JSON(http.StatusOK, u.service.Roles())
+}
+
+func (u userController) SetVoiceLivers(c *gin.Context) {
+	var voiceLiversDto dto.VoiceLiversDto
+	if err := c.BindJSON(&voiceLiversDto); err != nil {
+		log.Println(err.Error())
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "错误的参数格式"})
+		return
+	}
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	userVo, err := u.service.SetVoiceLivers(util.GetOperator(c), hex, voiceLiversDto.Livers)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, userVo)
+}
+
+func (u userController) OidcLogin(c *gin.Context) {
+	authorizationUrl, err := u.oidcService.AuthorizationUrl()
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.Redirect(http.StatusFound, authorizationUrl)
+}
+
+func (u userController) OidcCallback(c *gin.Context) {
+	if providerError := c.Query("error"); providerError != "" {
+		log.Println("oidc provider error: " + providerError + " " + c.Query("error_description"))
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "第三方登录失败"})
+		return
+	}
+	identity, err := u.oidcService.Callback(c.Query("code"), c.Query("state"))
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	token, name, err := u.service.LoginByOidc(identity)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	if frontendUrl := config.GetConfigs().GetString("oidc.frontend-url"); frontendUrl != "" {
+		fragment := url.Values{}
+		fragment.Set("token", token)
+		fragment.Set("name", name)
+		c.Redirect(http.StatusFound, frontendUrl+"#"+fragment.Encode())
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"token": token, "name": name})
+}
+
+func (u userController) setDisabled(c *gin.Context, disabled bool) {
+	hex, err := primitive.ObjectIDFromHex(c.Param("id"))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
+		return
+	}
+	userVo, err := u.service.SetDisabled(util.GetOperator(c), hex, disabled)
+	if err != nil {
+		util.ErrorResponse(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, userVo)
+}
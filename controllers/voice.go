@@ -59,7 +59,7 @@ func (v voiceController) AddVoice(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "请上传MP3格式音频文件"})
 		return
 	}
-	err = v.service.AddVoice(&voice, file, c)
+	err = v.service.AddVoice(util.GetOperator(c), &voice, file, c)
 	if err != nil {
 		util.ErrorResponse(c, err)
 		return
@@ -104,7 +104,7 @@ func (v voiceController) UpdateVoice(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "请上传MP3格式音频文件"})
 		return
 	}
-	err = v.service.UpdateVoice(hex, &voice, file, c)
+	err = v.service.UpdateVoice(util.GetOperator(c), hex, &voice, file, c)
 	if err != nil {
 		util.ErrorResponse(c, err)
 		return
@@ -119,7 +119,7 @@ func (v voiceController) DeleteVoice(c *gin.Context) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"message": "无效的ID格式"})
 		return
 	}
-	err = v.service.DeleteVoice(hex)
+	err = v.service.DeleteVoice(util.GetOperator(c), hex)
 	if err != nil {
 		util.ErrorResponse(c, err)
 		return
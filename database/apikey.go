@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"mihiru-go/models"
+)
+
+const collectionNameApiKey = "apikey"
+
+type ApiKeyDatabase interface {
+	InsertApiKey(apiKey *models.ApiKeyWithObjectId) error
+	DeleteApiKey(id primitive.ObjectID) (*models.ApiKeyWithObjectId, error)
+	GetApiKeyByHash(hash string) (*models.ApiKeyWithObjectId, error)
+	ListApiKey() ([]*models.ApiKeyWithObjectId, error)
+	UpdateApiKeyLastUsedTime(id primitive.ObjectID, lastUsedTime int64) error
+}
+
+// createApiKeyIndexes makes the authentication lookup by hash use an index
+func (d *MongoDatabase) createApiKeyIndexes() error {
+	collection := d.DB.Collection(collectionNameApiKey)
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "hash", Value: 1}},
+		Options: options.Index().SetName("hash_unique").SetUnique(true),
+	})
+	return err
+}
+
+func (d *MongoDatabase) InsertApiKey(apiKey *models.ApiKeyWithObjectId) error {
+	collection := d.DB.Collection(collectionNameApiKey)
+	insertResult, err := collection.InsertOne(context.Background(), apiKey.ApiKey)
+	if err != nil {
+		return err
+	}
+	apiKey.ID = insertResult.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (d *MongoDatabase) DeleteApiKey(id primitive.ObjectID) (*models.ApiKeyWithObjectId, error) {
+	var apiKey *models.ApiKeyWithObjectId
+	collection := d.DB.Collection(collectionNameApiKey)
+	err := collection.FindOneAndDelete(context.Background(), bson.D{{Key: "_id", Value: id}}).Decode(&apiKey)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+func (d *MongoDatabase) GetApiKeyByHash(hash string) (*models.ApiKeyWithObjectId, error) {
+	var apiKey *models.ApiKeyWithObjectId
+	collection := d.DB.Collection(collectionNameApiKey)
+	err := collection.FindOne(context.Background(), bson.D{{Key: "hash", Value: hash}}).Decode(&apiKey)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return apiKey, nil
+}
+
+func (d *MongoDatabase) ListApiKey() ([]*models.ApiKeyWithObjectId, error) {
+	collection := d.DB.Collection(collectionNameApiKey)
+	cursor, err := collection.Find(context.Background(), bson.D{},
+		&options.FindOptions{Sort: bson.D{{Key: "addTime", Value: -1}}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var data []*models.ApiKeyWithObjectId
+	for cursor.Next(context.Background()) {
+		var apiKey *models.ApiKeyWithObjectId
+		if err = cursor.Decode(&apiKey); err != nil {
+			return nil, err
+		}
+		data = append(data, apiKey)
+	}
+
+	return data, nil
+}
+
+func (d *MongoDatabase) UpdateApiKeyLastUsedTime(id primitive.ObjectID, lastUsedTime int64) error {
+	collection := d.DB.Collection(collectionNameApiKey)
+	_, err := collection.UpdateByID(context.Background(), id, bson.M{"$set": bson.D{{Key: "lastUsedTime", Value: lastUsedTime}}})
+	return err
+}
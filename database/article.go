@@ -19,6 +19,7 @@ type ArticleDatabase interface {
 	GetArticle(id int64) (*models.ArticleWithObjectId, error)
 	SearchArticle(articleSearchParams *models.ArticleSearchParams) (*models.ArticlePage, error)
 	ListAllTag() ([]string, error)
+	QueryArticleByPublishDay(monthDay string, timezone string) ([]*models.Article, error)
 }
 
 func (d *MongoDatabase) SearchArticle(articleSearchParams *models.ArticleSearchParams) (*models.ArticlePage, error) {
@@ -156,3 +157,37 @@ func (d *MongoDatabase) ListAllTag() ([]string, error) {
 	}
 	return tags, nil
 }
+
+// QueryArticleByPublishDay returns the visible articles published on the month and day (formatted as 01.02) of any year
+func (d *MongoDatabase) QueryArticleByPublishDay(monthDay string, timezone string) ([]*models.Article, error) {
+	collection := d.DB.Collection(collectionNameArticle)
+	filter := bson.D{
+		{Key: "hide", Value: int8(0)},
+		{Key: "$expr", Value: bson.M{"$eq": bson.A{
+			bson.M{"$dateToString": bson.M{
+				"format":   "%m.%d",
+				"date":     bson.M{"$toDate": "$publishTime"},
+				"timezone": timezone,
+			}},
+			monthDay,
+		}}},
+	}
+	cursor, err := collection.Find(context.Background(), filter,
+		&options.FindOptions{
+			Sort:       bson.D{{Key: "publishTime", Value: 1}},
+			Projection: bson.M{"content": 0},
+		})
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var data []*models.Article
+	for cursor.Next(context.Background()) {
+		var article *models.Article
+		if err := cursor.Decode(&article); err != nil {
+			return nil, err
+		}
+		data = append(data, article)
+	}
+	return data, nil
+}
@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"mihiru-go/models"
+)
+
+const collectionNameAudit = "audit"
+
+type AuditDatabase interface {
+	InsertAuditLog(auditLog *models.AuditLog) error
+	SearchAuditLog(auditSearchParams *models.AuditSearchParams) (*models.AuditLogPage, error)
+	DeleteAuditLogBefore(timestamp int64) (int64, error)
+}
+
+func (d *MongoDatabase) InsertAuditLog(auditLog *models.AuditLog) error {
+	collection := d.DB.Collection(collectionNameAudit)
+	_, err := collection.InsertOne(context.Background(), auditLog)
+	return err
+}
+
+func (d *MongoDatabase) SearchAuditLog(auditSearchParams *models.AuditSearchParams) (*models.AuditLogPage, error) {
+	pageSize, pageIndex := pageValues(&auditSearchParams.PageParams)
+	skip := pageSize * pageIndex
+	filter := bson.D{}
+	if auditSearchParams.ActorId != "" {
+		actorId, err := primitive.ObjectIDFromHex(auditSearchParams.ActorId)
+		if err != nil {
+			return nil, err
+		}
+		filter = append(filter, bson.E{Key: "actor.id", Value: actorId})
+	}
+	if auditSearchParams.Action != "" {
+		filter = append(filter, bson.E{Key: "action", Value: auditSearchParams.Action})
+	}
+	if auditSearchParams.TargetType != "" {
+		filter = append(filter, bson.E{Key: "target_type", Value: auditSearchParams.TargetType})
+	}
+	if auditSearchParams.TargetId != "" {
+		filter = append(filter, bson.E{Key: "target_id", Value: auditSearchParams.TargetId})
+	}
+	if auditSearchParams.StartTime != nil {
+		filter = append(filter, bson.E{Key: "timestamp", Value: bson.M{"$gte": *auditSearchParams.StartTime}})
+	}
+	if auditSearchParams.EndTime != nil {
+		filter = append(filter, bson.E{Key: "timestamp", Value: bson.M{"$lt": *auditSearchParams.EndTime}})
+	}
+	collection := d.DB.Collection(collectionNameAudit)
+	count, err := collection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := collection.Find(context.Background(), filter,
+		&options.FindOptions{
+			Skip:  &skip,
+			Sort:  bson.D{{Key: "timestamp", Value: -1}},
+			Limit: &pageSize,
+		})
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var data []*models.AuditLogWithObjectId
+	for cursor.Next(context.Background()) {
+		var auditLog *models.AuditLogWithObjectId
+		if err = cursor.Decode(&auditLog); err != nil {
+			return nil, err
+		}
+		data = append(data, auditLog)
+	}
+	auditLogPage := new(models.AuditLogPage)
+	auditLogPage.PageResult = newPageResult(pageSize, pageIndex, count)
+	auditLogPage.Data = data
+	return auditLogPage, nil
+}
+
+func (d *MongoDatabase) DeleteAuditLogBefore(timestamp int64) (int64, error) {
+	collection := d.DB.Collection(collectionNameAudit)
+	deleteResult, err := collection.DeleteMany(context.Background(), bson.D{{Key: "timestamp", Value: bson.M{"$lt": timestamp}}})
+	if err != nil {
+		return 0, err
+	}
+	return deleteResult.DeletedCount, nil
+}
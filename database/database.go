@@ -2,11 +2,14 @@ package database
 
 import (
 	"context"
+	"errors"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"log"
+	"mihiru-go/models"
 	"sort"
+	"strings"
 	"time"
 )
 
@@ -40,6 +43,30 @@ func New(uri, username, password, dbname string) (*MongoDatabase, error) {
 	return &MongoDatabase{DB: db, Client: client, Context: ctx, escapeStrings: escapeStrings}, nil
 }
 
+// EnsureIndexes creates every index independently, so one failure does not leave the others missing.
+// The failures are returned together, since the unique indexes guard the upserts
+func (d *MongoDatabase) EnsureIndexes() error {
+	var failures []string
+	for _, createIndexes := range []func() error{
+		d.createDynamicIdIndex,
+		d.createDynamicTimestampIndex,
+		d.createLiveJoinUserIndex,
+		d.createLiveTimestampIndex,
+		d.createMilestoneIndexes,
+		d.createScheduledLiveIndexes,
+		d.createMediaIndexes,
+		d.createApiKeyIndexes,
+	} {
+		if err := createIndexes(); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New("create index failed: " + strings.Join(failures, "; "))
+	}
+	return nil
+}
+
 func (d *MongoDatabase) Close() {
 	err := d.Client.Disconnect(d.Context)
 	if err != nil {
@@ -53,3 +80,27 @@ func CloseCursor(cursor *mongo.Cursor, ctx context.Context) {
 		log.Println(err.Error())
 	}
 }
+
+func pageValues(pageParams *models.PageParams) (int64, int64) {
+	pageSize := int64(10)
+	pageIndex := int64(0)
+	if pageParams.PageSize != nil && *pageParams.PageSize > 0 {
+		pageSize = *pageParams.PageSize
+	}
+	if pageParams.PageIndex != nil && *pageParams.PageIndex > 0 {
+		pageIndex = *pageParams.PageIndex
+	}
+	return pageSize, pageIndex
+}
+
+func newPageResult(pageSize int64, pageIndex int64, count int64) models.PageResult {
+	var pageResult models.PageResult
+	pageResult.PageSize = &pageSize
+	pageResult.PageIndex = &pageIndex
+	pageResult.Count = count
+	pageResult.PageCount = count / pageSize
+	if count%pageSize > 0 {
+		pageResult.PageCount++
+	}
+	return pageResult
+}
@@ -2,11 +2,13 @@ package database
 
 import (
 	"context"
+	"fmt"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"mihiru-go/models"
-	"time"
+	"regexp"
 )
 
 const collectionNameDynamic = "dynamic"
@@ -14,8 +16,85 @@ const collectionNameDynamic = "dynamic"
 type DynamicDatabase interface {
 	InsertDynamic(dynamic *models.DynamicWithObjectId) error
 	UpdateDynamic(dynamic *models.DynamicWithObjectId) error
+	GetDynamicById(id primitive.ObjectID) (*models.DynamicWithObjectId, error)
+	GetDynamicByDynamicId(dynamicId int64) (*models.DynamicWithObjectId, error)
 	QueryDynamicByTimestamp(startTimestamp int64, endTimeStamp int64) ([]*models.DynamicWithObjectId, error)
-	CountDynamicByDay() ([]*models.DayCount, error)
+	CountDynamicByPeriod(format string, timezone string) ([]*models.PeriodTypeCount, error)
+	SearchDynamic(keywords []string, startTimestamp int64, endTimestamp int64, limit int64) ([]*models.DynamicWithObjectId, error)
+	QueryDynamicByLastModified(since int64) ([]*models.DynamicWithObjectId, error)
+	QueryDynamicByCursor(timestamp int64, after bool, limit int64) ([]*models.DynamicWithObjectId, error)
+	EachDynamic(startTimestamp int64, endTimestamp int64, visit func(dynamic *models.DynamicWithObjectId) error) error
+	MaxDynamicLastModifiedByTimestamp(startTimestamp int64, endTimeStamp int64) (int64, error)
+	DeleteDynamic(id primitive.ObjectID, deleteTime int64) (bool, error)
+	RestoreDynamic(id primitive.ObjectID, lastModified int64) (bool, error)
+	PurgeDynamic(id primitive.ObjectID) (bool, error)
+}
+
+// createDynamicIdIndex fails on databases that already hold duplicated dynamic ids, see DedupeDynamics
+func (d *MongoDatabase) createDynamicIdIndex() error {
+	collection := d.DB.Collection(collectionNameDynamic)
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys: bson.D{{Key: "dynamic_id", Value: 1}},
+		Options: options.Index().
+			SetName("dynamic_id_unique").
+			SetUnique(true).
+			SetPartialFilterExpression(bson.D{{Key: "dynamic_id", Value: bson.M{"$gt": 0}}}),
+	})
+	if mongo.IsDuplicateKeyError(err) {
+		return fmt.Errorf("%w, remove the duplicated dynamics with the dedupe-dynamics command first", err)
+	}
+	return err
+}
+
+func (d *MongoDatabase) createDynamicTimestampIndex() error {
+	return d.createMemoryTimestampIndex(collectionNameDynamic)
+}
+
+// DedupeDynamics keeps one dynamic of every duplicated dynamic_id, the not deleted one modified last,
+// and removes the others. It returns the duplicated dynamic ids, dryRun removes nothing
+func (d *MongoDatabase) DedupeDynamics(dryRun bool) ([]int64, int64, error) {
+	collection := d.DB.Collection(collectionNameDynamic)
+	cursor, err := collection.Aggregate(context.Background(), mongo.Pipeline{
+		{{Key: "$match", Value: bson.D{{Key: "dynamic_id", Value: bson.M{"$gt": 0}}}}},
+		// a missing deleted field sorts before false, both are kept before the deleted ones
+		{{Key: "$sort", Value: bson.D{{Key: "deleted", Value: 1}, {Key: "last_modified", Value: -1}, {Key: "_id", Value: -1}}}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: "$dynamic_id"},
+			{Key: "ids", Value: bson.M{"$push": "$_id"}},
+		}}},
+		{{Key: "$match", Value: bson.D{{Key: "ids.1", Value: bson.M{"$exists": true}}}}},
+		{{Key: "$sort", Value: bson.D{{Key: "_id", Value: 1}}}},
+	}, options.Aggregate().SetAllowDiskUse(true))
+	if err != nil {
+		return nil, 0, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var dynamicIds []int64
+	var removeIds bson.A
+	for cursor.Next(context.Background()) {
+		var duplicate struct {
+			DynamicId int64                `bson:"_id"`
+			Ids       []primitive.ObjectID `bson:"ids"`
+		}
+		if err = cursor.Decode(&duplicate); err != nil {
+			return nil, 0, err
+		}
+		dynamicIds = append(dynamicIds, duplicate.DynamicId)
+		for _, id := range duplicate.Ids[1:] {
+			removeIds = append(removeIds, id)
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return nil, 0, err
+	}
+	if dryRun || len(removeIds) == 0 {
+		return dynamicIds, int64(len(removeIds)), nil
+	}
+	deleteResult, err := collection.DeleteMany(context.Background(), bson.D{{Key: "_id", Value: bson.M{"$in": removeIds}}})
+	if err != nil {
+		return nil, 0, err
+	}
+	return dynamicIds, deleteResult.DeletedCount, nil
 }
 
 func (d *MongoDatabase) InsertDynamic(dynamic *models.DynamicWithObjectId) error {
@@ -34,10 +113,30 @@ func (d *MongoDatabase) UpdateDynamic(dynamic *models.DynamicWithObjectId) error
 	return err
 }
 
+func (d *MongoDatabase) GetDynamicById(id primitive.ObjectID) (*models.DynamicWithObjectId, error) {
+	var dynamic *models.DynamicWithObjectId
+	collection := d.DB.Collection(collectionNameDynamic)
+	err := collection.FindOne(context.Background(), bson.D{{Key: "_id", Value: id}}).Decode(&dynamic)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return dynamic, nil
+}
+
+func (d *MongoDatabase) GetDynamicByDynamicId(dynamicId int64) (*models.DynamicWithObjectId, error) {
+	var dynamic *models.DynamicWithObjectId
+	collection := d.DB.Collection(collectionNameDynamic)
+	err := collection.FindOne(context.Background(), bson.D{{Key: "dynamic_id", Value: dynamicId}}).Decode(&dynamic)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return dynamic, nil
+}
+
 func (d *MongoDatabase) QueryDynamicByTimestamp(startTimestamp int64, endTimeStamp int64) ([]*models.DynamicWithObjectId, error) {
 	collection := d.DB.Collection(collectionNameDynamic)
 	cursor, err := collection.Find(context.Background(),
-		bson.D{{Key: "timestamp", Value: bson.M{"$gte": startTimestamp}}, {Key: "timestamp", Value: bson.M{"$lt": endTimeStamp}}},
+		memoryTimestampFilter(startTimestamp, endTimeStamp),
 		&options.FindOptions{Sort: bson.D{{Key: "timestamp", Value: 1}}},
 	)
 	if err != nil {
@@ -56,40 +155,122 @@ func (d *MongoDatabase) QueryDynamicByTimestamp(startTimestamp int64, endTimeSta
 	return data, nil
 }
 
-func (d *MongoDatabase) CountDynamicByDay() ([]*models.DayCount, error) {
+func (d *MongoDatabase) CountDynamicByPeriod(format string, timezone string) ([]*models.PeriodTypeCount, error) {
+	return d.countMemoryByPeriod(collectionNameDynamic, "$type", format, timezone)
+}
+
+func (d *MongoDatabase) MaxDynamicLastModifiedByTimestamp(startTimestamp int64, endTimeStamp int64) (int64, error) {
+	return d.maxMemoryLastModifiedByTimestamp(collectionNameDynamic, startTimestamp, endTimeStamp)
+}
+
+func (d *MongoDatabase) DeleteDynamic(id primitive.ObjectID, deleteTime int64) (bool, error) {
+	return d.deleteMemory(collectionNameDynamic, id, deleteTime)
+}
+
+func (d *MongoDatabase) RestoreDynamic(id primitive.ObjectID, lastModified int64) (bool, error) {
+	return d.restoreMemory(collectionNameDynamic, id, lastModified)
+}
+
+func (d *MongoDatabase) PurgeDynamic(id primitive.ObjectID) (bool, error) {
+	return d.purgeMemory(collectionNameDynamic, id)
+}
+
+func (d *MongoDatabase) SearchDynamic(keywords []string, startTimestamp int64, endTimestamp int64, limit int64) ([]*models.DynamicWithObjectId, error) {
 	collection := d.DB.Collection(collectionNameDynamic)
-	cursor, err := collection.Aggregate(context.Background(), bson.A{
-		bson.M{
-			"$group": bson.M{
-				"_id": bson.M{
-					"$dateToString": bson.M{
-						"format": "%Y.%m.%d",
-						"date": bson.M{
-							"$add": bson.A{primitive.NewDateTimeFromTime(time.Unix(0, 0)), bson.M{
-								"$multiply": bson.A{"$timestamp", 1000},
-							}},
-						},
-						"timezone": "+08",
-					},
-				},
-				"count":   bson.M{"$sum": 1},
-				"version": bson.M{"$max": "$last_modified"},
-			},
-		},
-		bson.M{"$sort": bson.M{"_id": 1}},
-	})
+	filter := memoryRangeFilter(startTimestamp, endTimestamp)
+	var and bson.A
+	for _, keyword := range keywords {
+		regex := primitive.Regex{Pattern: regexp.QuoteMeta(keyword), Options: "i"}
+		var or bson.A
+		for _, field := range []string{"title", "content", "desc"} {
+			or = append(or, bson.D{{Key: field, Value: regex}})
+		}
+		and = append(and, bson.D{{Key: "$or", Value: or}})
+	}
+	if and != nil {
+		filter = append(filter, bson.E{Key: "$and", Value: and})
+	}
+	cursor, err := collection.Find(context.Background(), filter,
+		options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var data []*models.DynamicWithObjectId
+	for cursor.Next(context.Background()) {
+		var dynamic *models.DynamicWithObjectId
+		if err = cursor.Decode(&dynamic); err != nil {
+			return nil, err
+		}
+		data = append(data, dynamic)
+	}
+
+	return data, nil
+}
+
+func (d *MongoDatabase) QueryDynamicByLastModified(since int64) ([]*models.DynamicWithObjectId, error) {
+	collection := d.DB.Collection(collectionNameDynamic)
+	cursor, err := collection.Find(context.Background(),
+		memoryLastModifiedFilter(since),
+		&options.FindOptions{Sort: bson.D{{Key: "last_modified", Value: 1}}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var data []*models.DynamicWithObjectId
+	for cursor.Next(context.Background()) {
+		var dynamic *models.DynamicWithObjectId
+		if err = cursor.Decode(&dynamic); err != nil {
+			return nil, err
+		}
+		data = append(data, dynamic)
+	}
+
+	return data, nil
+}
+
+func (d *MongoDatabase) QueryDynamicByCursor(timestamp int64, after bool, limit int64) ([]*models.DynamicWithObjectId, error) {
+	collection := d.DB.Collection(collectionNameDynamic)
+	filter, findOptions := memoryCursorQuery(timestamp, after, limit)
+	cursor, err := collection.Find(context.Background(), filter, findOptions)
 	if err != nil {
 		return nil, err
 	}
 	defer CloseCursor(cursor, context.Background())
-	var data []*models.DayCount
+	var data []*models.DynamicWithObjectId
 	for cursor.Next(context.Background()) {
-		var dayCount *models.DayCount
-		if err = cursor.Decode(&dayCount); err != nil {
+		var dynamic *models.DynamicWithObjectId
+		if err = cursor.Decode(&dynamic); err != nil {
 			return nil, err
 		}
-		data = append(data, dayCount)
+		data = append(data, dynamic)
 	}
 
 	return data, nil
 }
+
+// EachDynamic visits the not deleted dynamics in [startTimestamp, endTimestamp) ordered by timestamp, 0 for unbounded,
+// without loading all of them at once
+func (d *MongoDatabase) EachDynamic(startTimestamp int64, endTimestamp int64, visit func(dynamic *models.DynamicWithObjectId) error) error {
+	collection := d.DB.Collection(collectionNameDynamic)
+	cursor, err := collection.Find(context.Background(),
+		memoryRangeFilter(startTimestamp, endTimestamp),
+		&options.FindOptions{Sort: bson.D{{Key: "timestamp", Value: 1}}},
+	)
+	if err != nil {
+		return err
+	}
+	defer CloseCursor(cursor, context.Background())
+	for cursor.Next(context.Background()) {
+		var dynamic *models.DynamicWithObjectId
+		if err = cursor.Decode(&dynamic); err != nil {
+			return err
+		}
+		if err = visit(dynamic); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
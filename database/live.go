@@ -4,9 +4,10 @@ import (
 	"context"
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"mihiru-go/models"
-	"time"
+	"regexp"
 )
 
 const collectionNameLive = "live"
@@ -14,8 +15,44 @@ const collectionNameLive = "live"
 type LiveDatabase interface {
 	InsertLive(live *models.LiveWithObjectId) error
 	UpdateLive(live *models.LiveWithObjectId) error
+	GetLiveById(id primitive.ObjectID) (*models.LiveWithObjectId, error)
+	GetLiveByUserAndTimestamp(uid int64, timestamp int64) (*models.LiveWithObjectId, error)
+	GetLiveByTimestampAndTitle(timestamp int64, title string) (*models.LiveWithObjectId, error)
 	QueryLiveByTimestamp(startTimestamp int64, endTimeStamp int64) ([]*models.LiveWithObjectId, error)
-	CountLiveByDay() ([]*models.DayCount, error)
+	CountLiveByPeriod(format string, timezone string) ([]*models.PeriodTypeCount, error)
+	SearchLive(keywords []string, speaker string, startTimestamp int64, endTimestamp int64, limit int64) ([]*models.LiveWithObjectId, error)
+	InsertLiveCut(id primitive.ObjectID, version int64, position int, cut *models.LiveCut, lastModified int64) (bool, error)
+	UpdateLiveCut(id primitive.ObjectID, version int64, cutIndex int, cut *models.LiveCut, lastModified int64) (bool, error)
+	DeleteLiveCut(id primitive.ObjectID, version int64, cutIndex int, lastModified int64) (bool, error)
+	ReorderLiveCuts(id primitive.ObjectID, version int64, order []int, lastModified int64) (bool, error)
+	InsertLiveCutDialogue(id primitive.ObjectID, version int64, cutIndex int, position int, dialogue *models.LiveCutDialogue, lastModified int64) (bool, error)
+	UpdateLiveCutDialogue(id primitive.ObjectID, version int64, cutIndex int, dialogueIndex int, dialogue *models.LiveCutDialogue, lastModified int64) (bool, error)
+	DeleteLiveCutDialogue(id primitive.ObjectID, version int64, cutIndex int, dialogueIndex int, lastModified int64) (bool, error)
+	ReorderLiveCutDialogues(id primitive.ObjectID, version int64, cutIndex int, order []int, lastModified int64) (bool, error)
+	ReplaceLiveCutDialogues(id primitive.ObjectID, version int64, cutIndex int, dialogues []models.LiveCutDialogue, lastModified int64) (bool, error)
+	QueryLiveByLastModified(since int64) ([]*models.LiveWithObjectId, error)
+	QueryLiveByCursor(timestamp int64, after bool, limit int64) ([]*models.LiveWithObjectId, error)
+	EachLive(startTimestamp int64, endTimestamp int64, visit func(live *models.LiveWithObjectId) error) error
+	CountLiveByJoinUser(timezone string) ([]*models.CoStreamer, error)
+	QueryLiveByJoinUser(uid int64, pageParams *models.PageParams) (*models.LivePage, error)
+	ListLiveMediaFiles() ([]string, error)
+	MaxLiveLastModifiedByTimestamp(startTimestamp int64, endTimeStamp int64) (int64, error)
+	DeleteLive(id primitive.ObjectID, deleteTime int64) (bool, error)
+	RestoreLive(id primitive.ObjectID, lastModified int64) (bool, error)
+	PurgeLive(id primitive.ObjectID) (bool, error)
+}
+
+func (d *MongoDatabase) createLiveJoinUserIndex() error {
+	collection := d.DB.Collection(collectionNameLive)
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "join_user_profiles.uid", Value: 1}, {Key: "timestamp", Value: -1}},
+		Options: options.Index().SetName("join_uid_timestamp"),
+	})
+	return err
+}
+
+func (d *MongoDatabase) createLiveTimestampIndex() error {
+	return d.createMemoryTimestampIndex(collectionNameLive)
 }
 
 func (d *MongoDatabase) InsertLive(live *models.LiveWithObjectId) error {
@@ -34,10 +71,40 @@ func (d *MongoDatabase) UpdateLive(live *models.LiveWithObjectId) error {
 	return err
 }
 
+func (d *MongoDatabase) GetLiveById(id primitive.ObjectID) (*models.LiveWithObjectId, error) {
+	var live *models.LiveWithObjectId
+	collection := d.DB.Collection(collectionNameLive)
+	err := collection.FindOne(context.Background(), bson.D{{Key: "_id", Value: id}}).Decode(&live)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return live, nil
+}
+
+func (d *MongoDatabase) GetLiveByUserAndTimestamp(uid int64, timestamp int64) (*models.LiveWithObjectId, error) {
+	var live *models.LiveWithObjectId
+	collection := d.DB.Collection(collectionNameLive)
+	err := collection.FindOne(context.Background(), bson.D{{Key: "user_profile.uid", Value: uid}, {Key: "timestamp", Value: timestamp}}).Decode(&live)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return live, nil
+}
+
+func (d *MongoDatabase) GetLiveByTimestampAndTitle(timestamp int64, title string) (*models.LiveWithObjectId, error) {
+	var live *models.LiveWithObjectId
+	collection := d.DB.Collection(collectionNameLive)
+	err := collection.FindOne(context.Background(), bson.D{{Key: "timestamp", Value: timestamp}, {Key: "title", Value: title}}).Decode(&live)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return live, nil
+}
+
 func (d *MongoDatabase) QueryLiveByTimestamp(startTimestamp int64, endTimeStamp int64) ([]*models.LiveWithObjectId, error) {
 	collection := d.DB.Collection(collectionNameLive)
 	cursor, err := collection.Find(context.Background(),
-		bson.D{{Key: "timestamp", Value: bson.M{"$gte": startTimestamp}}, {Key: "timestamp", Value: bson.M{"$lt": endTimeStamp}}},
+		memoryTimestampFilter(startTimestamp, endTimeStamp),
 		&options.FindOptions{Sort: bson.D{{Key: "timestamp", Value: 1}}},
 	)
 	if err != nil {
@@ -56,40 +123,240 @@ func (d *MongoDatabase) QueryLiveByTimestamp(startTimestamp int64, endTimeStamp
 	return data, nil
 }
 
-func (d *MongoDatabase) CountLiveByDay() ([]*models.DayCount, error) {
+func (d *MongoDatabase) CountLiveByPeriod(format string, timezone string) ([]*models.PeriodTypeCount, error) {
+	return d.countMemoryByPeriod(collectionNameLive, 0, format, timezone)
+}
+
+func (d *MongoDatabase) MaxLiveLastModifiedByTimestamp(startTimestamp int64, endTimeStamp int64) (int64, error) {
+	return d.maxMemoryLastModifiedByTimestamp(collectionNameLive, startTimestamp, endTimeStamp)
+}
+
+func (d *MongoDatabase) DeleteLive(id primitive.ObjectID, deleteTime int64) (bool, error) {
+	return d.deleteMemory(collectionNameLive, id, deleteTime)
+}
+
+func (d *MongoDatabase) RestoreLive(id primitive.ObjectID, lastModified int64) (bool, error) {
+	return d.restoreMemory(collectionNameLive, id, lastModified)
+}
+
+func (d *MongoDatabase) PurgeLive(id primitive.ObjectID) (bool, error) {
+	return d.purgeMemory(collectionNameLive, id)
+}
+
+func (d *MongoDatabase) SearchLive(keywords []string, speaker string, startTimestamp int64, endTimestamp int64, limit int64) ([]*models.LiveWithObjectId, error) {
+	collection := d.DB.Collection(collectionNameLive)
+	filter := memoryRangeFilter(startTimestamp, endTimestamp)
+	var and bson.A
+	for _, keyword := range keywords {
+		regex := primitive.Regex{Pattern: regexp.QuoteMeta(keyword), Options: "i"}
+		var or bson.A
+		for _, field := range []string{"title", "content", "cuts.dialogues.text", "cuts.dialogues.speaker"} {
+			or = append(or, bson.D{{Key: field, Value: regex}})
+		}
+		and = append(and, bson.D{{Key: "$or", Value: or}})
+	}
+	if and != nil {
+		filter = append(filter, bson.E{Key: "$and", Value: and})
+	}
+	if speaker != "" {
+		filter = append(filter, bson.E{Key: "cuts.dialogues.speaker", Value: speaker})
+	}
+	cursor, err := collection.Find(context.Background(), filter,
+		options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var data []*models.LiveWithObjectId
+	for cursor.Next(context.Background()) {
+		var live *models.LiveWithObjectId
+		if err = cursor.Decode(&live); err != nil {
+			return nil, err
+		}
+		data = append(data, live)
+	}
+
+	return data, nil
+}
+
+func (d *MongoDatabase) QueryLiveByLastModified(since int64) ([]*models.LiveWithObjectId, error) {
 	collection := d.DB.Collection(collectionNameLive)
+	cursor, err := collection.Find(context.Background(),
+		memoryLastModifiedFilter(since),
+		&options.FindOptions{Sort: bson.D{{Key: "last_modified", Value: 1}}},
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var data []*models.LiveWithObjectId
+	for cursor.Next(context.Background()) {
+		var live *models.LiveWithObjectId
+		if err = cursor.Decode(&live); err != nil {
+			return nil, err
+		}
+		data = append(data, live)
+	}
+
+	return data, nil
+}
+
+func (d *MongoDatabase) QueryLiveByCursor(timestamp int64, after bool, limit int64) ([]*models.LiveWithObjectId, error) {
+	collection := d.DB.Collection(collectionNameLive)
+	filter, findOptions := memoryCursorQuery(timestamp, after, limit)
+	cursor, err := collection.Find(context.Background(), filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var data []*models.LiveWithObjectId
+	for cursor.Next(context.Background()) {
+		var live *models.LiveWithObjectId
+		if err = cursor.Decode(&live); err != nil {
+			return nil, err
+		}
+		data = append(data, live)
+	}
+
+	return data, nil
+}
+
+// EachLive visits the not deleted lives in [startTimestamp, endTimestamp) ordered by timestamp, 0 for unbounded,
+// without loading all of them at once
+func (d *MongoDatabase) EachLive(startTimestamp int64, endTimestamp int64, visit func(live *models.LiveWithObjectId) error) error {
+	collection := d.DB.Collection(collectionNameLive)
+	cursor, err := collection.Find(context.Background(),
+		memoryRangeFilter(startTimestamp, endTimestamp),
+		&options.FindOptions{Sort: bson.D{{Key: "timestamp", Value: 1}}},
+	)
+	if err != nil {
+		return err
+	}
+	defer CloseCursor(cursor, context.Background())
+	for cursor.Next(context.Background()) {
+		var live *models.LiveWithObjectId
+		if err = cursor.Decode(&live); err != nil {
+			return err
+		}
+		if err = visit(live); err != nil {
+			return err
+		}
+	}
+	return cursor.Err()
+}
+
+// CountLiveByJoinUser sums up the lives of every joined user by year, the version of a user
+// is the max last_modified of the lives they joined including the deleted ones
+func (d *MongoDatabase) CountLiveByJoinUser(timezone string) ([]*models.CoStreamer, error) {
+	collection := d.DB.Collection(collectionNameLive)
+	notDeleted := func(value interface{}) bson.M {
+		return bson.M{"$cond": bson.A{bson.M{"$eq": bson.A{"$deleted", true}}, nil, value}}
+	}
 	cursor, err := collection.Aggregate(context.Background(), bson.A{
+		bson.M{"$match": bson.M{"join_user_profiles.uid": bson.M{"$exists": true}}},
+		// the profile of the latest live wins
+		bson.M{"$sort": bson.D{{Key: "timestamp", Value: 1}}},
+		bson.M{"$unwind": "$join_user_profiles"},
 		bson.M{
 			"$group": bson.M{
 				"_id": bson.M{
-					"$dateToString": bson.M{
-						"format": "%Y.%m.%d",
-						"date": bson.M{
-							"$add": bson.A{primitive.NewDateTimeFromTime(time.Unix(0, 0)), bson.M{
-								"$multiply": bson.A{"$timestamp", 1000},
-							}},
-						},
-						"timezone": "+08",
-					},
+					"uid":  "$join_user_profiles.uid",
+					"year": memoryPeriodExpression("%Y", timezone),
 				},
-				"count":   bson.M{"$sum": 1},
-				"version": bson.M{"$max": "$last_modified"},
+				"uname": bson.M{"$last": "$join_user_profiles.uname"},
+				"face":  bson.M{"$last": "$join_user_profiles.face"},
+				"count": bson.M{"$sum": bson.M{
+					"$cond": bson.A{bson.M{"$eq": bson.A{"$deleted", true}}, 0, 1},
+				}},
+				"first_timestamp": bson.M{"$min": notDeleted("$timestamp")},
+				"last_timestamp":  bson.M{"$max": notDeleted("$timestamp")},
+				"version":         bson.M{"$max": "$last_modified"},
+			},
+		},
+		bson.M{"$sort": bson.D{{Key: "_id.year", Value: 1}}},
+		bson.M{
+			"$group": bson.M{
+				"_id":             "$_id.uid",
+				"uname":           bson.M{"$last": "$uname"},
+				"face":            bson.M{"$last": "$face"},
+				"count":           bson.M{"$sum": "$count"},
+				"first_timestamp": bson.M{"$min": "$first_timestamp"},
+				"last_timestamp":  bson.M{"$max": "$last_timestamp"},
+				"version":         bson.M{"$max": "$version"},
+				"years":           bson.M{"$push": bson.M{"year": "$_id.year", "count": "$count"}},
 			},
 		},
-		bson.M{"$sort": bson.M{"_id": 1}},
+		bson.M{"$project": bson.M{"_id": 0, "uid": "$_id", "uname": 1, "face": 1, "count": 1,
+			"first_timestamp": bson.M{"$ifNull": bson.A{"$first_timestamp", 0}},
+			"last_timestamp":  bson.M{"$ifNull": bson.A{"$last_timestamp", 0}},
+			"version":         1, "years": 1,
+		}},
+		bson.M{"$sort": bson.D{{Key: "count", Value: -1}, {Key: "last_timestamp", Value: -1}}},
 	})
 	if err != nil {
 		return nil, err
 	}
 	defer CloseCursor(cursor, context.Background())
-	var data []*models.DayCount
+	var data []*models.CoStreamer
 	for cursor.Next(context.Background()) {
-		var dayCount *models.DayCount
-		if err = cursor.Decode(&dayCount); err != nil {
+		var coStreamer *models.CoStreamer
+		if err = cursor.Decode(&coStreamer); err != nil {
 			return nil, err
 		}
-		data = append(data, dayCount)
+		data = append(data, coStreamer)
 	}
 
 	return data, nil
 }
+
+func (d *MongoDatabase) QueryLiveByJoinUser(uid int64, pageParams *models.PageParams) (*models.LivePage, error) {
+	pageSize, pageIndex := pageValues(pageParams)
+	skip := pageSize * pageIndex
+	collection := d.DB.Collection(collectionNameLive)
+	filter := bson.D{{Key: "join_user_profiles.uid", Value: uid}, {Key: "deleted", Value: bson.M{"$ne": true}}}
+	count, err := collection.CountDocuments(context.Background(), filter)
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := collection.Find(context.Background(), filter,
+		&options.FindOptions{
+			Skip:  &skip,
+			Sort:  bson.D{{Key: "timestamp", Value: -1}},
+			Limit: &pageSize,
+		})
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	data := []*models.LiveWithObjectId{}
+	for cursor.Next(context.Background()) {
+		var live *models.LiveWithObjectId
+		if err = cursor.Decode(&live); err != nil {
+			return nil, err
+		}
+		data = append(data, live)
+	}
+	livePage := new(models.LivePage)
+	livePage.PageResult = newPageResult(pageSize, pageIndex, count)
+	livePage.Data = data
+	return livePage, nil
+}
+
+// ListLiveMediaFiles returns the full records and cut files referenced by any live, including the deleted ones
+func (d *MongoDatabase) ListLiveMediaFiles() ([]string, error) {
+	collection := d.DB.Collection(collectionNameLive)
+	var files []string
+	for _, field := range []string{"full_record", "cuts.file"} {
+		values, err := collection.Distinct(context.Background(), field, bson.D{})
+		if err != nil {
+			return nil, err
+		}
+		for _, value := range values {
+			if file, ok := value.(string); ok && file != "" {
+				files = append(files, file)
+			}
+		}
+	}
+	return files, nil
+}
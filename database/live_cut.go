@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"math"
+	"mihiru-go/models"
+	"strconv"
+)
+
+// the cut and dialogue updates below only apply when the live is not deleted and its last_modified
+// still equals version, they return false when another change came first.
+// Inserting, deleting and reordering use pipeline updates, so that the other cuts and dialogues are not sent again
+
+func (d *MongoDatabase) InsertLiveCut(id primitive.ObjectID, version int64, position int, cut *models.LiveCut, lastModified int64) (bool, error) {
+	cuts := bson.M{"$ifNull": bson.A{"$cuts", bson.A{}}}
+	return d.updateLiveCuts(id, version, arraySplice(cuts, position, 0, bson.A{bson.M{"$literal": cut}}), lastModified)
+}
+
+// UpdateLiveCut updates the fields of a cut except its dialogues
+func (d *MongoDatabase) UpdateLiveCut(id primitive.ObjectID, version int64, cutIndex int, cut *models.LiveCut, lastModified int64) (bool, error) {
+	cutPath := "cuts." + strconv.Itoa(cutIndex)
+	return d.updateLive(id, version, cutPath, bson.M{"$set": bson.M{
+		cutPath + ".type":  cut.Type,
+		cutPath + ".file":  cut.File,
+		cutPath + ".title": cut.Title,
+		"last_modified":    lastModified,
+	}})
+}
+
+func (d *MongoDatabase) DeleteLiveCut(id primitive.ObjectID, version int64, cutIndex int, lastModified int64) (bool, error) {
+	return d.updateLiveCuts(id, version, arraySplice("$cuts", cutIndex, 1, bson.A{}), lastModified)
+}
+
+// ReorderLiveCuts moves the cut at order[i] to i, order has to be a permutation of the indexes of the cuts
+func (d *MongoDatabase) ReorderLiveCuts(id primitive.ObjectID, version int64, order []int, lastModified int64) (bool, error) {
+	return d.updateLiveCuts(id, version, arrayReorder("$cuts", order), lastModified)
+}
+
+func (d *MongoDatabase) InsertLiveCutDialogue(id primitive.ObjectID, version int64, cutIndex int, position int, dialogue *models.LiveCutDialogue, lastModified int64) (bool, error) {
+	dialogues := bson.M{"$ifNull": bson.A{cutDialogues(cutIndex), bson.A{}}}
+	return d.updateLiveCuts(id, version, replaceCutDialogues(cutIndex, arraySplice(dialogues, position, 0, bson.A{bson.M{"$literal": dialogue}})), lastModified)
+}
+
+func (d *MongoDatabase) UpdateLiveCutDialogue(id primitive.ObjectID, version int64, cutIndex int, dialogueIndex int, dialogue *models.LiveCutDialogue, lastModified int64) (bool, error) {
+	dialoguePath := "cuts." + strconv.Itoa(cutIndex) + ".dialogues." + strconv.Itoa(dialogueIndex)
+	return d.updateLive(id, version, dialoguePath, bson.M{"$set": bson.M{
+		dialoguePath:    dialogue,
+		"last_modified": lastModified,
+	}})
+}
+
+// ReplaceLiveCutDialogues sets all dialogues of the cut at once, used by the subtitle import
+func (d *MongoDatabase) ReplaceLiveCutDialogues(id primitive.ObjectID, version int64, cutIndex int, dialogues []models.LiveCutDialogue, lastModified int64) (bool, error) {
+	cutPath := "cuts." + strconv.Itoa(cutIndex)
+	return d.updateLive(id, version, cutPath, bson.M{"$set": bson.M{
+		cutPath + ".dialogues": dialogues,
+		"last_modified":        lastModified,
+	}})
+}
+
+func (d *MongoDatabase) DeleteLiveCutDialogue(id primitive.ObjectID, version int64, cutIndex int, dialogueIndex int, lastModified int64) (bool, error) {
+	return d.updateLiveCuts(id, version, replaceCutDialogues(cutIndex, arraySplice(cutDialogues(cutIndex), dialogueIndex, 1, bson.A{})), lastModified)
+}
+
+// ReorderLiveCutDialogues moves the dialogue at order[i] to i, order has to be a permutation of the indexes of the dialogues
+func (d *MongoDatabase) ReorderLiveCutDialogues(id primitive.ObjectID, version int64, cutIndex int, order []int, lastModified int64) (bool, error) {
+	return d.updateLiveCuts(id, version, replaceCutDialogues(cutIndex, arrayReorder(cutDialogues(cutIndex), order)), lastModified)
+}
+
+// updateLive applies the update when the element at path exists
+func (d *MongoDatabase) updateLive(id primitive.ObjectID, version int64, path string, update bson.M) (bool, error) {
+	collection := d.DB.Collection(collectionNameLive)
+	updateResult, err := collection.UpdateOne(context.Background(),
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "deleted", Value: bson.M{"$ne": true}},
+			{Key: "last_modified", Value: version},
+			{Key: path, Value: bson.M{"$exists": true}},
+		},
+		update,
+	)
+	if err != nil {
+		return false, err
+	}
+	return updateResult.MatchedCount > 0, nil
+}
+
+// updateLiveCuts replaces the cuts with the result of the expression
+func (d *MongoDatabase) updateLiveCuts(id primitive.ObjectID, version int64, cuts interface{}, lastModified int64) (bool, error) {
+	collection := d.DB.Collection(collectionNameLive)
+	updateResult, err := collection.UpdateOne(context.Background(),
+		bson.D{
+			{Key: "_id", Value: id},
+			{Key: "deleted", Value: bson.M{"$ne": true}},
+			{Key: "last_modified", Value: version},
+		},
+		bson.A{bson.M{"$set": bson.M{"cuts": cuts, "last_modified": lastModified}}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return updateResult.MatchedCount > 0, nil
+}
+
+// cutDialogues is the dialogues of the cut at cutIndex, the path $cuts.dialogues is not used
+// since it skips the cuts without the field and shifts the indexes
+func cutDialogues(cutIndex int) bson.M {
+	return bson.M{"$let": bson.M{
+		"vars": bson.M{"cut": bson.M{"$arrayElemAt": bson.A{"$cuts", cutIndex}}},
+		"in":   "$$cut.dialogues",
+	}}
+}
+
+// replaceCutDialogues is the cuts with the dialogues of the cut at cutIndex replaced by the expression
+func replaceCutDialogues(cutIndex int, dialogues interface{}) bson.M {
+	cut := bson.M{"$mergeObjects": bson.A{
+		bson.M{"$arrayElemAt": bson.A{"$cuts", cutIndex}},
+		bson.M{"dialogues": dialogues},
+	}}
+	return arraySplice("$cuts", cutIndex, 1, bson.A{cut})
+}
+
+// arraySplice is the array with deleteCount elements from start replaced by the elements
+func arraySplice(array interface{}, start int, deleteCount int, elements bson.A) bson.M {
+	var head interface{} = bson.A{}
+	if start > 0 {
+		head = bson.M{"$slice": bson.A{array, 0, start}}
+	}
+	tail := bson.M{"$slice": bson.A{array, start + deleteCount, math.MaxInt32}}
+	return bson.M{"$concatArrays": bson.A{head, elements, tail}}
+}
+
+func arrayReorder(array interface{}, order []int) bson.M {
+	return bson.M{"$map": bson.M{
+		"input": order,
+		"as":    "index",
+		"in":    bson.M{"$arrayElemAt": bson.A{array, "$$index"}},
+	}}
+}
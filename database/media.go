@@ -0,0 +1,145 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"mihiru-go/models"
+)
+
+const collectionNameMedia = "media"
+
+type MediaDatabase interface {
+	AddPendingMedia(urls []string, addTime int64) (int64, error)
+	ListPendingMedia(maxAttempts int, limit int64) ([]*models.MediaWithObjectId, error)
+	UpdateMedia(media *models.MediaWithObjectId) error
+	GetMediaByUrls(urls []string) ([]*models.MediaWithObjectId, error)
+	GetDoneMediaByHash(hash string) (*models.MediaWithObjectId, error)
+	CountMediaByStatus() ([]*models.MediaStatusCount, error)
+}
+
+func (d *MongoDatabase) createMediaIndexes() error {
+	collection := d.DB.Collection(collectionNameMedia)
+	_, err := collection.Indexes().CreateMany(context.Background(), []mongo.IndexModel{
+		{
+			Keys:    bson.D{{Key: "url", Value: 1}},
+			Options: options.Index().SetName("url_unique").SetUnique(true),
+		},
+		{
+			Keys:    bson.D{{Key: "status", Value: 1}, {Key: "attempts", Value: 1}},
+			Options: options.Index().SetName("status_attempts"),
+		},
+		{
+			Keys:    bson.D{{Key: "hash", Value: 1}},
+			Options: options.Index().SetName("hash"),
+		},
+	})
+	return err
+}
+
+// AddPendingMedia inserts the urls which are not recorded yet and returns the number of inserted ones
+func (d *MongoDatabase) AddPendingMedia(urls []string, addTime int64) (int64, error) {
+	if len(urls) == 0 {
+		return 0, nil
+	}
+	collection := d.DB.Collection(collectionNameMedia)
+	var writeModels []mongo.WriteModel
+	for _, url := range urls {
+		media := models.Media{Url: url, Status: models.MediaStatusPending, AddTime: addTime, UpdateTime: addTime}
+		writeModels = append(writeModels, mongo.NewUpdateOneModel().
+			SetFilter(bson.D{{Key: "url", Value: url}}).
+			SetUpdate(bson.M{"$setOnInsert": media}).
+			SetUpsert(true))
+	}
+	result, err := collection.BulkWrite(context.Background(), writeModels, options.BulkWrite().SetOrdered(false))
+	if err != nil {
+		return 0, err
+	}
+	return result.UpsertedCount, nil
+}
+
+func (d *MongoDatabase) ListPendingMedia(maxAttempts int, limit int64) ([]*models.MediaWithObjectId, error) {
+	collection := d.DB.Collection(collectionNameMedia)
+	cursor, err := collection.Find(context.Background(),
+		bson.D{
+			{Key: "status", Value: bson.M{"$in": bson.A{models.MediaStatusPending, models.MediaStatusFailed}}},
+			{Key: "attempts", Value: bson.M{"$lt": maxAttempts}},
+		},
+		options.Find().SetSort(bson.D{{Key: "attempts", Value: 1}, {Key: "add_time", Value: 1}}).SetLimit(limit),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var data []*models.MediaWithObjectId
+	for cursor.Next(context.Background()) {
+		var media *models.MediaWithObjectId
+		if err = cursor.Decode(&media); err != nil {
+			return nil, err
+		}
+		data = append(data, media)
+	}
+
+	return data, nil
+}
+
+func (d *MongoDatabase) UpdateMedia(media *models.MediaWithObjectId) error {
+	collection := d.DB.Collection(collectionNameMedia)
+	_, err := collection.UpdateByID(context.Background(), media.ID, bson.M{"$set": media.Media})
+	return err
+}
+
+func (d *MongoDatabase) GetMediaByUrls(urls []string) ([]*models.MediaWithObjectId, error) {
+	if len(urls) == 0 {
+		return nil, nil
+	}
+	collection := d.DB.Collection(collectionNameMedia)
+	cursor, err := collection.Find(context.Background(), bson.D{{Key: "url", Value: bson.M{"$in": urls}}})
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var data []*models.MediaWithObjectId
+	for cursor.Next(context.Background()) {
+		var media *models.MediaWithObjectId
+		if err = cursor.Decode(&media); err != nil {
+			return nil, err
+		}
+		data = append(data, media)
+	}
+
+	return data, nil
+}
+
+func (d *MongoDatabase) GetDoneMediaByHash(hash string) (*models.MediaWithObjectId, error) {
+	var media *models.MediaWithObjectId
+	collection := d.DB.Collection(collectionNameMedia)
+	err := collection.FindOne(context.Background(), bson.D{{Key: "hash", Value: hash}, {Key: "status", Value: models.MediaStatusDone}}).Decode(&media)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return media, nil
+}
+
+func (d *MongoDatabase) CountMediaByStatus() ([]*models.MediaStatusCount, error) {
+	collection := d.DB.Collection(collectionNameMedia)
+	cursor, err := collection.Aggregate(context.Background(), bson.A{
+		bson.M{"$group": bson.M{"_id": "$status", "count": bson.M{"$sum": 1}, "size": bson.M{"$sum": "$size"}}},
+		bson.M{"$sort": bson.M{"_id": 1}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var data []*models.MediaStatusCount
+	for cursor.Next(context.Background()) {
+		var statusCount *models.MediaStatusCount
+		if err = cursor.Decode(&statusCount); err != nil {
+			return nil, err
+		}
+		data = append(data, statusCount)
+	}
+
+	return data, nil
+}
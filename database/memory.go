@@ -0,0 +1,166 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"mihiru-go/models"
+	"time"
+)
+
+// the helpers below are shared by the collections of memory items,
+// which all have timestamp (in seconds), last_modified and the soft delete fields
+
+// countMemoryByPeriod counts the not deleted items by period and type, typeExpression is the type of an item,
+// the version of a period is the max last_modified of all of its items including the deleted ones
+func (d *MongoDatabase) countMemoryByPeriod(collectionName string, typeExpression interface{}, format string, timezone string) ([]*models.PeriodTypeCount, error) {
+	collection := d.DB.Collection(collectionName)
+	cursor, err := collection.Aggregate(context.Background(), bson.A{
+		bson.M{
+			"$group": bson.M{
+				"_id": bson.M{
+					"period": memoryPeriodExpression(format, timezone),
+					"type":   typeExpression,
+				},
+				"count": bson.M{"$sum": bson.M{
+					"$cond": bson.A{bson.M{"$eq": bson.A{"$deleted", true}}, 0, 1},
+				}},
+				"version": bson.M{"$max": "$last_modified"},
+			},
+		},
+		bson.M{"$project": bson.M{"_id": 0, "period": "$_id.period", "type": "$_id.type", "count": 1, "version": 1}},
+		bson.M{"$sort": bson.D{{Key: "period", Value: 1}, {Key: "type", Value: 1}}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var data []*models.PeriodTypeCount
+	for cursor.Next(context.Background()) {
+		var periodTypeCount *models.PeriodTypeCount
+		if err = cursor.Decode(&periodTypeCount); err != nil {
+			return nil, err
+		}
+		data = append(data, periodTypeCount)
+	}
+
+	return data, nil
+}
+
+// memoryPeriodExpression formats the timestamp (in seconds) of an item in the timezone
+func memoryPeriodExpression(format string, timezone string) bson.M {
+	return bson.M{
+		"$dateToString": bson.M{
+			"format": format,
+			"date": bson.M{
+				"$add": bson.A{primitive.NewDateTimeFromTime(time.Unix(0, 0)), bson.M{
+					"$multiply": bson.A{"$timestamp", 1000},
+				}},
+			},
+			"timezone": timezone,
+		},
+	}
+}
+
+// maxMemoryLastModifiedByTimestamp includes the deleted items
+func (d *MongoDatabase) maxMemoryLastModifiedByTimestamp(collectionName string, startTimestamp int64, endTimeStamp int64) (int64, error) {
+	var item *models.LastModifiedFields
+	collection := d.DB.Collection(collectionName)
+	err := collection.FindOne(context.Background(),
+		bson.D{{Key: "timestamp", Value: bson.M{"$gte": startTimestamp, "$lt": endTimeStamp}}},
+		options.FindOne().SetSort(bson.D{{Key: "last_modified", Value: -1}}).SetProjection(bson.D{{Key: "last_modified", Value: 1}}),
+	).Decode(&item)
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return item.LastModified, nil
+}
+
+func (d *MongoDatabase) deleteMemory(collectionName string, id primitive.ObjectID, deleteTime int64) (bool, error) {
+	collection := d.DB.Collection(collectionName)
+	updateResult, err := collection.UpdateOne(context.Background(),
+		bson.D{{Key: "_id", Value: id}, {Key: "deleted", Value: bson.M{"$ne": true}}},
+		bson.M{"$set": bson.M{"deleted": true, "delete_time": deleteTime, "last_modified": deleteTime}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return updateResult.ModifiedCount > 0, nil
+}
+
+func (d *MongoDatabase) restoreMemory(collectionName string, id primitive.ObjectID, lastModified int64) (bool, error) {
+	collection := d.DB.Collection(collectionName)
+	updateResult, err := collection.UpdateOne(context.Background(),
+		bson.D{{Key: "_id", Value: id}, {Key: "deleted", Value: true}},
+		bson.M{"$set": bson.M{"last_modified": lastModified}, "$unset": bson.M{"deleted": "", "delete_time": ""}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return updateResult.ModifiedCount > 0, nil
+}
+
+// purgeMemory removes an item only when it is soft deleted
+func (d *MongoDatabase) purgeMemory(collectionName string, id primitive.ObjectID) (bool, error) {
+	collection := d.DB.Collection(collectionName)
+	deleteResult, err := collection.DeleteOne(context.Background(), bson.D{{Key: "_id", Value: id}, {Key: "deleted", Value: true}})
+	if err != nil {
+		return false, err
+	}
+	return deleteResult.DeletedCount > 0, nil
+}
+
+// memoryCursorQuery selects the not deleted items strictly before the timestamp, the latest first,
+// or strictly after it when after is true, the earliest first
+func memoryCursorQuery(timestamp int64, after bool, limit int64) (bson.D, *options.FindOptions) {
+	operator, order := "$lt", -1
+	if after {
+		operator, order = "$gt", 1
+	}
+	filter := bson.D{
+		{Key: "timestamp", Value: bson.M{operator: timestamp}},
+		{Key: "deleted", Value: bson.M{"$ne": true}},
+	}
+	return filter, options.Find().SetSort(bson.D{{Key: "timestamp", Value: order}}).SetLimit(limit)
+}
+
+func (d *MongoDatabase) createMemoryTimestampIndex(collectionName string) error {
+	collection := d.DB.Collection(collectionName)
+	_, err := collection.Indexes().CreateOne(context.Background(), mongo.IndexModel{
+		Keys:    bson.D{{Key: "timestamp", Value: 1}},
+		Options: options.Index().SetName("timestamp"),
+	})
+	return err
+}
+
+// memoryRangeFilter selects the not deleted items in [startTimestamp, endTimestamp), 0 for unbounded
+func memoryRangeFilter(startTimestamp int64, endTimestamp int64) bson.D {
+	filter := bson.D{{Key: "deleted", Value: bson.M{"$ne": true}}}
+	timestampFilter := bson.M{}
+	if startTimestamp > 0 {
+		timestampFilter["$gte"] = startTimestamp
+	}
+	if endTimestamp > 0 {
+		timestampFilter["$lt"] = endTimestamp
+	}
+	if len(timestampFilter) > 0 {
+		filter = append(filter, bson.E{Key: "timestamp", Value: timestampFilter})
+	}
+	return filter
+}
+
+func memoryTimestampFilter(startTimestamp int64, endTimeStamp int64) bson.D {
+	return bson.D{
+		{Key: "timestamp", Value: bson.M{"$gte": startTimestamp, "$lt": endTimeStamp}},
+		{Key: "deleted", Value: bson.M{"$ne": true}},
+	}
+}
+
+func memoryLastModifiedFilter(since int64) bson.D {
+	return bson.D{{Key: "last_modified", Value: bson.M{"$gt": since}}, {Key: "deleted", Value: bson.M{"$ne": true}}}
+}
@@ -0,0 +1,102 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"mihiru-go/models"
+)
+
+const collectionNameMilestone = "milestone"
+
+type MilestoneDatabase interface {
+	InsertMilestone(milestone *models.MilestoneWithObjectId) error
+	UpdateMilestone(milestone *models.MilestoneWithObjectId) error
+	GetMilestoneById(id primitive.ObjectID) (*models.MilestoneWithObjectId, error)
+	ListMilestone() ([]*models.MilestoneWithObjectId, error)
+	QueryMilestoneByTimestamp(startTimestamp int64, endTimeStamp int64) ([]*models.MilestoneWithObjectId, error)
+	QueryMilestoneByLastModified(since int64) ([]*models.MilestoneWithObjectId, error)
+	QueryMilestoneByCursor(timestamp int64, after bool, limit int64) ([]*models.MilestoneWithObjectId, error)
+	CountMilestoneByPeriod(format string, timezone string) ([]*models.PeriodTypeCount, error)
+	MaxMilestoneLastModifiedByTimestamp(startTimestamp int64, endTimeStamp int64) (int64, error)
+	DeleteMilestone(id primitive.ObjectID, deleteTime int64) (bool, error)
+}
+
+func (d *MongoDatabase) createMilestoneIndexes() error {
+	return d.createMemoryTimestampIndex(collectionNameMilestone)
+}
+
+func (d *MongoDatabase) InsertMilestone(milestone *models.MilestoneWithObjectId) error {
+	collection := d.DB.Collection(collectionNameMilestone)
+	insertResult, err := collection.InsertOne(context.Background(), milestone.MilestoneWithLastModified)
+	if err != nil {
+		return err
+	}
+	milestone.ID = insertResult.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (d *MongoDatabase) UpdateMilestone(milestone *models.MilestoneWithObjectId) error {
+	collection := d.DB.Collection(collectionNameMilestone)
+	_, err := collection.UpdateByID(context.Background(), milestone.ID, bson.M{"$set": milestone.MilestoneWithLastModified})
+	return err
+}
+
+func (d *MongoDatabase) GetMilestoneById(id primitive.ObjectID) (*models.MilestoneWithObjectId, error) {
+	var milestone *models.MilestoneWithObjectId
+	collection := d.DB.Collection(collectionNameMilestone)
+	err := collection.FindOne(context.Background(), bson.D{{Key: "_id", Value: id}}).Decode(&milestone)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return milestone, nil
+}
+
+func (d *MongoDatabase) ListMilestone() ([]*models.MilestoneWithObjectId, error) {
+	return d.findMilestone(bson.D{{Key: "deleted", Value: bson.M{"$ne": true}}}, options.Find().SetSort(bson.D{{Key: "timestamp", Value: -1}}))
+}
+
+func (d *MongoDatabase) QueryMilestoneByTimestamp(startTimestamp int64, endTimeStamp int64) ([]*models.MilestoneWithObjectId, error) {
+	return d.findMilestone(memoryTimestampFilter(startTimestamp, endTimeStamp), options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}}))
+}
+
+func (d *MongoDatabase) QueryMilestoneByLastModified(since int64) ([]*models.MilestoneWithObjectId, error) {
+	return d.findMilestone(memoryLastModifiedFilter(since), options.Find().SetSort(bson.D{{Key: "last_modified", Value: 1}}))
+}
+
+func (d *MongoDatabase) QueryMilestoneByCursor(timestamp int64, after bool, limit int64) ([]*models.MilestoneWithObjectId, error) {
+	return d.findMilestone(memoryCursorQuery(timestamp, after, limit))
+}
+
+func (d *MongoDatabase) CountMilestoneByPeriod(format string, timezone string) ([]*models.PeriodTypeCount, error) {
+	return d.countMemoryByPeriod(collectionNameMilestone, 0, format, timezone)
+}
+
+func (d *MongoDatabase) MaxMilestoneLastModifiedByTimestamp(startTimestamp int64, endTimeStamp int64) (int64, error) {
+	return d.maxMemoryLastModifiedByTimestamp(collectionNameMilestone, startTimestamp, endTimeStamp)
+}
+
+func (d *MongoDatabase) DeleteMilestone(id primitive.ObjectID, deleteTime int64) (bool, error) {
+	return d.deleteMemory(collectionNameMilestone, id, deleteTime)
+}
+
+func (d *MongoDatabase) findMilestone(filter bson.D, findOptions *options.FindOptions) ([]*models.MilestoneWithObjectId, error) {
+	collection := d.DB.Collection(collectionNameMilestone)
+	cursor, err := collection.Find(context.Background(), filter, findOptions)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var data []*models.MilestoneWithObjectId
+	for cursor.Next(context.Background()) {
+		var milestone *models.MilestoneWithObjectId
+		if err = cursor.Decode(&milestone); err != nil {
+			return nil, err
+		}
+		data = append(data, milestone)
+	}
+
+	return data, nil
+}
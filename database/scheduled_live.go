@@ -0,0 +1,79 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"mihiru-go/models"
+)
+
+const collectionNameScheduledLive = "scheduled_live"
+
+type ScheduledLiveDatabase interface {
+	InsertScheduledLive(scheduledLive *models.ScheduledLiveWithObjectId) error
+	UpdateScheduledLive(scheduledLive *models.ScheduledLiveWithObjectId) error
+	GetScheduledLiveById(id primitive.ObjectID) (*models.ScheduledLiveWithObjectId, error)
+	ListScheduledLive(since int64) ([]*models.ScheduledLiveWithObjectId, error)
+	DeleteScheduledLive(id primitive.ObjectID) error
+}
+
+func (d *MongoDatabase) createScheduledLiveIndexes() error {
+	return d.createMemoryTimestampIndex(collectionNameScheduledLive)
+}
+
+func (d *MongoDatabase) InsertScheduledLive(scheduledLive *models.ScheduledLiveWithObjectId) error {
+	collection := d.DB.Collection(collectionNameScheduledLive)
+	insertResult, err := collection.InsertOne(context.Background(), scheduledLive.ScheduledLiveWithLastModified)
+	if err != nil {
+		return err
+	}
+	scheduledLive.ID = insertResult.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (d *MongoDatabase) UpdateScheduledLive(scheduledLive *models.ScheduledLiveWithObjectId) error {
+	collection := d.DB.Collection(collectionNameScheduledLive)
+	_, err := collection.UpdateByID(context.Background(), scheduledLive.ID, bson.M{"$set": scheduledLive.ScheduledLiveWithLastModified})
+	return err
+}
+
+func (d *MongoDatabase) GetScheduledLiveById(id primitive.ObjectID) (*models.ScheduledLiveWithObjectId, error) {
+	var scheduledLive *models.ScheduledLiveWithObjectId
+	collection := d.DB.Collection(collectionNameScheduledLive)
+	err := collection.FindOne(context.Background(), bson.D{{Key: "_id", Value: id}}).Decode(&scheduledLive)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return scheduledLive, nil
+}
+
+// ListScheduledLive returns the scheduled lives planned at or after since, the earliest first
+func (d *MongoDatabase) ListScheduledLive(since int64) ([]*models.ScheduledLiveWithObjectId, error) {
+	collection := d.DB.Collection(collectionNameScheduledLive)
+	cursor, err := collection.Find(context.Background(),
+		bson.D{{Key: "timestamp", Value: bson.M{"$gte": since}}},
+		options.Find().SetSort(bson.D{{Key: "timestamp", Value: 1}}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var data []*models.ScheduledLiveWithObjectId
+	for cursor.Next(context.Background()) {
+		var scheduledLive *models.ScheduledLiveWithObjectId
+		if err = cursor.Decode(&scheduledLive); err != nil {
+			return nil, err
+		}
+		data = append(data, scheduledLive)
+	}
+
+	return data, nil
+}
+
+func (d *MongoDatabase) DeleteScheduledLive(id primitive.ObjectID) error {
+	collection := d.DB.Collection(collectionNameScheduledLive)
+	_, err := collection.DeleteOne(context.Background(), bson.D{{Key: "_id", Value: id}})
+	return err
+}
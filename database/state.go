@@ -0,0 +1,43 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"time"
+)
+
+const collectionNameState = "state"
+
+// StateDatabase keeps small values that must survive restarts, one document by key
+type StateDatabase interface {
+	GetState(key string, value interface{}) (bool, error)
+	SetState(key string, value interface{}) error
+}
+
+// GetState decodes the value stored under key into value, returns false when nothing is stored
+func (d *MongoDatabase) GetState(key string, value interface{}) (bool, error) {
+	collection := d.DB.Collection(collectionNameState)
+	var state struct {
+		Value bson.RawValue `bson:"value"`
+	}
+	err := collection.FindOne(context.Background(), bson.D{{Key: "_id", Value: key}}).Decode(&state)
+	if err == mongo.ErrNoDocuments {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, state.Value.Unmarshal(value)
+}
+
+func (d *MongoDatabase) SetState(key string, value interface{}) error {
+	collection := d.DB.Collection(collectionNameState)
+	_, err := collection.UpdateOne(context.Background(),
+		bson.D{{Key: "_id", Value: key}},
+		bson.M{"$set": bson.M{"value": value, "update_time": time.Now().UnixNano() / 1e6}},
+		options.Update().SetUpsert(true),
+	)
+	return err
+}
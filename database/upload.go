@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"mihiru-go/models"
+)
+
+const collectionNameUpload = "upload"
+
+type UploadDatabase interface {
+	InsertUpload(upload *models.UploadWithObjectId) error
+	GetUploadById(id primitive.ObjectID) (*models.UploadWithObjectId, error)
+	UpdateUploadProgress(id primitive.ObjectID, offset int64, received int64, hashState []byte, updateTime int64) (bool, error)
+	FinishUpload(upload *models.UploadWithObjectId) error
+	ListUpload() ([]*models.UploadWithObjectId, error)
+	DeleteUpload(id primitive.ObjectID) error
+}
+
+func (d *MongoDatabase) InsertUpload(upload *models.UploadWithObjectId) error {
+	collection := d.DB.Collection(collectionNameUpload)
+	insertResult, err := collection.InsertOne(context.Background(), upload.Upload)
+	if err != nil {
+		return err
+	}
+	upload.ID = insertResult.InsertedID.(primitive.ObjectID)
+	return nil
+}
+
+func (d *MongoDatabase) GetUploadById(id primitive.ObjectID) (*models.UploadWithObjectId, error) {
+	var upload *models.UploadWithObjectId
+	collection := d.DB.Collection(collectionNameUpload)
+	err := collection.FindOne(context.Background(), bson.D{{Key: "_id", Value: id}}).Decode(&upload)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return upload, nil
+}
+
+// UpdateUploadProgress only applies when the upload still has offset bytes, so a chunk is never counted twice
+func (d *MongoDatabase) UpdateUploadProgress(id primitive.ObjectID, offset int64, received int64, hashState []byte, updateTime int64) (bool, error) {
+	collection := d.DB.Collection(collectionNameUpload)
+	updateResult, err := collection.UpdateOne(context.Background(),
+		bson.D{{Key: "_id", Value: id}, {Key: "status", Value: models.UploadStatusUploading}, {Key: "received", Value: offset}},
+		bson.M{"$set": bson.M{"received": received, "hash_state": hashState, "update_time": updateTime}},
+	)
+	if err != nil {
+		return false, err
+	}
+	return updateResult.ModifiedCount > 0, nil
+}
+
+func (d *MongoDatabase) FinishUpload(upload *models.UploadWithObjectId) error {
+	collection := d.DB.Collection(collectionNameUpload)
+	_, err := collection.UpdateByID(context.Background(), upload.ID, bson.M{"$set": upload.Upload})
+	return err
+}
+
+func (d *MongoDatabase) ListUpload() ([]*models.UploadWithObjectId, error) {
+	collection := d.DB.Collection(collectionNameUpload)
+	cursor, err := collection.Find(context.Background(), bson.D{},
+		options.Find().SetSort(bson.D{{Key: "add_time", Value: -1}}).SetProjection(bson.M{"hash_state": 0}),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var data []*models.UploadWithObjectId
+	for cursor.Next(context.Background()) {
+		var upload *models.UploadWithObjectId
+		if err = cursor.Decode(&upload); err != nil {
+			return nil, err
+		}
+		data = append(data, upload)
+	}
+
+	return data, nil
+}
+
+func (d *MongoDatabase) DeleteUpload(id primitive.ObjectID) error {
+	collection := d.DB.Collection(collectionNameUpload)
+	_, err := collection.DeleteOne(context.Background(), bson.D{{Key: "_id", Value: id}})
+	return err
+}
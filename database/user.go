@@ -5,6 +5,7 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
 	"mihiru-go/models"
 )
 
@@ -15,6 +16,10 @@ type UserDatabase interface {
 	UpdateUser(user *models.UserWithObjectId) error
 	GetUserByLoginName(loginName string) (*models.UserWithObjectId, error)
 	GetUserById(id primitive.ObjectID) (*models.UserWithObjectId, error)
+	GetUserByOidcSubject(subject string) (*models.UserWithObjectId, error)
+	GetUserByEmail(email string) (*models.UserWithObjectId, error)
+	ListUser(pageParams *models.PageParams) (*models.UserPage, error)
+	DeleteUser(id primitive.ObjectID) error
 }
 
 func (d *MongoDatabase) InsertUser(user *models.UserWithObjectId) error {
@@ -52,3 +57,61 @@ func (d *MongoDatabase) GetUserById(id primitive.ObjectID) (*models.UserWithObje
 	}
 	return user, nil
 }
+
+func (d *MongoDatabase) GetUserByOidcSubject(subject string) (*models.UserWithObjectId, error) {
+	var user *models.UserWithObjectId
+	collection := d.DB.Collection(collectionNameUser)
+	err := collection.FindOne(context.Background(), bson.D{{Key: "oidcSubject", Value: subject}}).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (d *MongoDatabase) GetUserByEmail(email string) (*models.UserWithObjectId, error) {
+	var user *models.UserWithObjectId
+	collection := d.DB.Collection(collectionNameUser)
+	err := collection.FindOne(context.Background(), bson.D{{Key: "email", Value: email}}).Decode(&user)
+	if err != nil && err != mongo.ErrNoDocuments {
+		return nil, err
+	}
+	return user, nil
+}
+
+func (d *MongoDatabase) ListUser(pageParams *models.PageParams) (*models.UserPage, error) {
+	pageSize, pageIndex := pageValues(pageParams)
+	skip := pageSize * pageIndex
+	collection := d.DB.Collection(collectionNameUser)
+	count, err := collection.CountDocuments(context.Background(), bson.D{})
+	if err != nil {
+		return nil, err
+	}
+	cursor, err := collection.Find(context.Background(), bson.D{},
+		&options.FindOptions{
+			Skip:  &skip,
+			Sort:  bson.D{{Key: "_id", Value: 1}},
+			Limit: &pageSize,
+		})
+	if err != nil {
+		return nil, err
+	}
+	defer CloseCursor(cursor, context.Background())
+	var data []*models.UserWithObjectId
+	for cursor.Next(context.Background()) {
+		var user *models.UserWithObjectId
+		if err = cursor.Decode(&user); err != nil {
+			return nil, err
+		}
+		data = append(data, user)
+	}
+	userPage := new(models.UserPage)
+	userPage.PageResult = newPageResult(pageSize, pageIndex, count)
+	userPage.Data = data
+	return userPage, nil
+}
+
+func (d *MongoDatabase) DeleteUser(id primitive.ObjectID) error {
+	collection := d.DB.Collection(collectionNameUser)
+	_, err := collection.DeleteOne(context.Background(), bson.D{{Key: "_id", Value: id}})
+	return err
+}
@@ -12,17 +12,21 @@ import (
 const collectionNameVoice = "voice"
 
 type VoiceDatabase interface {
-	InsertVoice(voice *models.Voice) error
+	InsertVoice(voice *models.VoiceWithObjectId) error
 	UpdateVoice(voice *models.VoiceWithObjectId) error
 	DeleteVoice(id primitive.ObjectID) error
 	GetVoiceById(id primitive.ObjectID) (*models.VoiceWithObjectId, error)
 	ListVoiceByLiver(liver string) ([]*models.VoiceWithObjectId, error)
 }
 
-func (d *MongoDatabase) InsertVoice(voice *models.Voice) error {
+func (d *MongoDatabase) InsertVoice(voice *models.VoiceWithObjectId) error {
 	collection := d.DB.Collection(collectionNameVoice)
-	_, err := collection.InsertOne(context.Background(), voice)
-	return err
+	insertResult, err := collection.InsertOne(context.Background(), voice.Voice)
+	if err != nil {
+		return err
+	}
+	voice.ID = insertResult.InsertedID.(primitive.ObjectID)
+	return nil
 }
 
 func (d *MongoDatabase) UpdateVoice(voice *models.VoiceWithObjectId) error {
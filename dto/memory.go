@@ -0,0 +1,5 @@
+package dto
+
+type OrderDto struct {
+	Order []int `json:"order"` //the old indexes in their new order
+}
@@ -16,3 +16,17 @@ type ChangePasswordDto struct {
 	OldPassword string `json:"old_password"`
 	NewPassword string `json:"new_password"`
 }
+
+type UpdateUserDto struct {
+	Name  string    `json:"name"`
+	Email *string   `json:"email"`
+	Roles *[]string `json:"roles"`
+}
+
+type VoiceLiversDto struct {
+	Livers []string `json:"livers"`
+}
+
+type ApiKeyDto struct {
+	models.ApiKeyBaseFields
+}
@@ -0,0 +1,135 @@
+// Package ical writes iCalendar (RFC 5545) feeds
+package ical
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const ContentType = "text/calendar; charset=utf-8"
+
+const (
+	StatusConfirmed = "CONFIRMED"
+	StatusTentative = "TENTATIVE"
+)
+
+// content lines longer than this many octets are folded
+const maxLineOctets = 75
+
+const timeLayout = "20060102T150405Z"
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`, "\r", `\n`)
+var paramEscaper = strings.NewReplacer(`"`, "'", "\r", " ", "\n", " ")
+
+type Person struct {
+	Name string
+	Uri  string
+}
+
+type Event struct {
+	Uid         string
+	Stamp       time.Time
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+	Url         string
+	Status      string
+	Organizer   *Person
+	Attendees   []Person
+}
+
+type Calendar struct {
+	ProdId  string
+	Name    string
+	Refresh time.Duration //suggested polling interval, 0 to omit
+	Events  []*Event
+}
+
+func (c *Calendar) Bytes() []byte {
+	var buffer bytes.Buffer
+	writeLine(&buffer, "BEGIN:VCALENDAR")
+	writeLine(&buffer, "VERSION:2.0")
+	writeLine(&buffer, "PRODID:"+c.ProdId)
+	writeLine(&buffer, "CALSCALE:GREGORIAN")
+	writeLine(&buffer, "METHOD:PUBLISH")
+	if c.Name != "" {
+		writeLine(&buffer, "X-WR-CALNAME:"+textEscaper.Replace(c.Name))
+	}
+	if c.Refresh > 0 {
+		refresh := formatDuration(c.Refresh)
+		writeLine(&buffer, "REFRESH-INTERVAL;VALUE=DURATION:"+refresh)
+		writeLine(&buffer, "X-PUBLISHED-TTL:"+refresh)
+	}
+	for _, event := range c.Events {
+		writeEvent(&buffer, event)
+	}
+	writeLine(&buffer, "END:VCALENDAR")
+	return buffer.Bytes()
+}
+
+func writeEvent(buffer *bytes.Buffer, event *Event) {
+	writeLine(buffer, "BEGIN:VEVENT")
+	writeLine(buffer, "UID:"+event.Uid)
+	writeLine(buffer, "DTSTAMP:"+event.Stamp.UTC().Format(timeLayout))
+	writeLine(buffer, "DTSTART:"+event.Start.UTC().Format(timeLayout))
+	if !event.End.IsZero() {
+		writeLine(buffer, "DTEND:"+event.End.UTC().Format(timeLayout))
+	}
+	writeLine(buffer, "SUMMARY:"+textEscaper.Replace(event.Summary))
+	if event.Description != "" {
+		writeLine(buffer, "DESCRIPTION:"+textEscaper.Replace(event.Description))
+	}
+	if event.Url != "" {
+		writeLine(buffer, "URL:"+event.Url)
+	}
+	if event.Status != "" {
+		writeLine(buffer, "STATUS:"+event.Status)
+	}
+	if event.Organizer != nil {
+		writeLine(buffer, "ORGANIZER"+personValue(*event.Organizer))
+	}
+	for _, attendee := range event.Attendees {
+		writeLine(buffer, "ATTENDEE;ROLE=REQ-PARTICIPANT"+personValue(attendee))
+	}
+	writeLine(buffer, "END:VEVENT")
+}
+
+func personValue(person Person) string {
+	value := ""
+	if person.Name != "" {
+		value += `;CN="` + paramEscaper.Replace(person.Name) + `"`
+	}
+	return value + ":" + person.Uri
+}
+
+// writeLine folds the line at maxLineOctets without splitting a UTF-8 sequence, ending every line with CRLF
+func writeLine(buffer *bytes.Buffer, line string) {
+	limit := maxLineOctets
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && !utf8.RuneStart(line[cut]) {
+			cut--
+		}
+		buffer.WriteString(line[:cut])
+		buffer.WriteString("\r\n ")
+		line = line[cut:]
+		// the leading space of a continuation line counts
+		limit = maxLineOctets - 1
+	}
+	buffer.WriteString(line)
+	buffer.WriteString("\r\n")
+}
+
+func formatDuration(duration time.Duration) string {
+	if duration%time.Hour == 0 {
+		return "PT" + strconv.FormatInt(int64(duration/time.Hour), 10) + "H"
+	}
+	if duration%time.Minute == 0 {
+		return "PT" + strconv.FormatInt(int64(duration/time.Minute), 10) + "M"
+	}
+	return "PT" + strconv.FormatInt(int64(duration/time.Second), 10) + "S"
+}
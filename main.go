@@ -12,11 +12,15 @@ import (
 func main() {
 	env := flag.String("e", "dev", "")
 	flag.Usage = func() {
-		fmt.Println("Usage: server -e {mode}")
+		fmt.Println("Usage: server -e {mode} [export|import|dedupe-dynamics] [options]")
 		os.Exit(1)
 	}
 	flag.Parse()
 	config.Init(*env)
+	if flag.NArg() > 0 {
+		server.RunCommand(flag.Args())
+		return
+	}
 	gin.SetMode(config.GetConfigs().GetString("gin.mode"))
 	server.Init()
 }
@@ -3,21 +3,26 @@ package middleware
 import (
 	"github.com/gin-gonic/gin"
 	"mihiru-go/services"
+	"mihiru-go/util"
 	"mihiru-go/vo"
 	"net/http"
+	"strings"
 )
 
 type Permissions interface {
-	Roles(roles []string) gin.HandlerFunc
+	Require(permission string) gin.HandlerFunc
 	Login() gin.HandlerFunc
 }
 
 type permissions struct {
-	userService services.UserService
+	userService   services.UserService
+	apiKeyService services.ApiKeyService
 }
 
-func NewPermissions(userService services.UserService) Permissions {
-	return permissions{userService: userService}
+const apiKeyAuthorizationPrefix = "ApiKey "
+
+func NewPermissions(userService services.UserService, apiKeyService services.ApiKeyService) Permissions {
+	return permissions{userService: userService, apiKeyService: apiKeyService}
 }
 
 func (p permissions) Login() gin.HandlerFunc {
@@ -26,21 +31,17 @@ func (p permissions) Login() gin.HandlerFunc {
 	}
 }
 
-func (p permissions) Roles(roles []string) gin.HandlerFunc {
+func (p permissions) Require(permission string) gin.HandlerFunc {
 	return func(c *gin.Context) {
 		user := p.getLoginUser(c)
 		if user == nil {
 			return
 		}
-		for _, userRole := range user.Roles {
-			for _, role := range roles {
-				if userRole == role {
-					c.Next()
-					return
-				}
-			}
+		if !user.HasPermission(permission) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "无权访问"})
+			return
 		}
-		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "无权访问"})
+		c.Next()
 	}
 }
 
@@ -50,10 +51,16 @@ func (p permissions) getLoginUser(c *gin.Context) *vo.UserVo {
 		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"message": "缺少验证信息"})
 		return nil
 	}
-	user := p.userService.CheckToken(token)
+	var user *vo.UserVo
+	if strings.HasPrefix(token, apiKeyAuthorizationPrefix) {
+		user = p.apiKeyService.CheckApiKey(strings.TrimPrefix(token, apiKeyAuthorizationPrefix))
+	} else {
+		user = p.userService.CheckToken(token)
+	}
 	if user == nil {
 		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"message": "验证信息失效, 请退出登录后重新登录"})
 		return nil
 	}
+	util.SetLoginUser(c, user)
 	return user
 }
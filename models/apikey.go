@@ -0,0 +1,31 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+type ApiKeyBaseFields struct {
+	Name        string   `bson:"name" json:"name"`
+	Permissions []string `bson:"permissions" json:"permissions"`
+	ExpireTime  int64    `bson:"expireTime" json:"expireTime"`
+}
+
+type ApiKeyAutoGenFields struct {
+	Prefix       string             `bson:"prefix" json:"prefix"`
+	CreatedBy    primitive.ObjectID `bson:"createdBy" json:"createdBy"`
+	AddTime      int64              `bson:"addTime" json:"addTime"`
+	LastUsedTime int64              `bson:"lastUsedTime" json:"lastUsedTime"`
+}
+
+type ApiKeySecurityFields struct {
+	Hash string `bson:"hash" json:"-"`
+}
+
+type ApiKey struct {
+	ApiKeyBaseFields     `bson:",inline"`
+	ApiKeyAutoGenFields  `bson:",inline"`
+	ApiKeySecurityFields `bson:",inline"`
+}
+
+type ApiKeyWithObjectId struct {
+	ObjectIdFields `bson:",inline"`
+	ApiKey         `bson:",inline"`
+}
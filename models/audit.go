@@ -0,0 +1,64 @@
+package models
+
+import "go.mongodb.org/mongo-driver/bson/primitive"
+
+const (
+	AuditTargetArticle       = "article"
+	AuditTargetDynamic       = "dynamic"
+	AuditTargetLive          = "live"
+	AuditTargetScheduledLive = "scheduled_live"
+	AuditTargetMilestone     = "milestone"
+	AuditTargetVoice         = "voice"
+	AuditTargetUpload        = "upload"
+	AuditTargetUser          = "user"
+	AuditTargetApiKey        = "apikey"
+)
+
+const (
+	AuditActionAdd            = "add"
+	AuditActionUpdate         = "update"
+	AuditActionDelete         = "delete"
+	AuditActionEnable         = "enable"
+	AuditActionDisable        = "disable"
+	AuditActionChangePassword = "change-password"
+	AuditActionGrant          = "grant"
+	AuditActionRestore        = "restore"
+	AuditActionPurge          = "purge"
+)
+
+type AuditActor struct {
+	ID        primitive.ObjectID `bson:"id" json:"id"`
+	Name      string             `bson:"name" json:"name"`
+	LoginName string             `bson:"login_name" json:"login_name"`
+}
+
+type AuditLog struct {
+	Actor      AuditActor  `bson:"actor" json:"actor"`
+	Action     string      `bson:"action" json:"action"`
+	TargetType string      `bson:"target_type" json:"target_type"`
+	TargetId   string      `bson:"target_id" json:"target_id"`
+	Timestamp  int64       `bson:"timestamp" json:"timestamp"`
+	ClientIp   string      `bson:"client_ip" json:"client_ip"`
+	Before     interface{} `bson:"before" json:"before"`
+	After      interface{} `bson:"after" json:"after"`
+}
+
+type AuditLogWithObjectId struct {
+	ObjectIdFields `bson:",inline"`
+	AuditLog       `bson:",inline"`
+}
+
+type AuditSearchParams struct {
+	PageParams
+	ActorId    string `form:"actor_id"`
+	Action     string `form:"action"`
+	TargetType string `form:"target_type"`
+	TargetId   string `form:"target_id"`
+	StartTime  *int64 `form:"start_time"`
+	EndTime    *int64 `form:"end_time"`
+}
+
+type AuditLogPage struct {
+	PageResult
+	Data []*AuditLogWithObjectId
+}
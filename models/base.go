@@ -6,12 +6,45 @@ type ObjectIdFields struct {
 	ID primitive.ObjectID `bson:"_id" json:"id"`
 }
 
+func (o ObjectIdFields) GetId() primitive.ObjectID {
+	return o.ID
+}
+
 type LastModifiedFields struct {
 	LastModified int64 `bson:"last_modified" json:"last_modified"`
 }
 
+func (l LastModifiedFields) GetLastModified() int64 {
+	return l.LastModified
+}
+
+type SoftDeleteFields struct {
+	Deleted    bool  `bson:"deleted,omitempty" json:"deleted,omitempty"`
+	DeleteTime int64 `bson:"delete_time,omitempty" json:"delete_time,omitempty"`
+}
+
+type PeriodCountFields struct {
+	Count        int64            `json:"count"`
+	Version      int64            `json:"version"`
+	DynamicCount int64            `json:"dynamic_count"`
+	LiveCount    int64            `json:"live_count"`
+	DynamicTypes map[string]int64 `json:"dynamic_types"` //dynamic count by type code
+	Kinds        map[string]int64 `json:"kinds"`         //count by memory kind
+}
+
 type DayCount struct {
-	Day     string `bson:"_id" json:"day"`
-	Count   int64  `bson:"count" json:"count"`
-	Version int64  `bson:"version" json:"version"`
+	Day string `json:"day"`
+	PeriodCountFields
+}
+
+type PeriodCount struct {
+	Period string `json:"period"`
+	PeriodCountFields
+}
+
+type PeriodTypeCount struct {
+	Period  string `bson:"period"`
+	Type    int16  `bson:"type"`
+	Count   int64  `bson:"count"`
+	Version int64  `bson:"version"`
 }
@@ -0,0 +1,23 @@
+package models
+
+// CoStreamer sums up the lives a user joined, counts exclude the deleted lives
+type CoStreamer struct {
+	Uid            int64             `bson:"uid" json:"uid"`
+	Uname          string            `bson:"uname" json:"uname"`
+	Face           string            `bson:"face" json:"face"`
+	Count          int64             `bson:"count" json:"count"`
+	FirstTimestamp int64             `bson:"first_timestamp" json:"first_timestamp"`
+	LastTimestamp  int64             `bson:"last_timestamp" json:"last_timestamp"`
+	Version        int64             `bson:"version" json:"version"`
+	Years          []*CoStreamerYear `bson:"years" json:"years"`
+}
+
+type CoStreamerYear struct {
+	Year  string `bson:"year" json:"year"`
+	Count int64  `bson:"count" json:"count"`
+}
+
+type LivePage struct {
+	PageResult
+	Data []*LiveWithObjectId `json:"data"`
+}
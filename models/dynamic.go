@@ -38,14 +38,26 @@ type Dynamic struct {
 	Ctrl         *[]DynamicCtrl        `bson:"ctrl" json:"ctrl"`
 	Pictures     *[]DynamicPicture     `bson:"pictures" json:"pictures"`
 	Origin       *Dynamic              `bson:"origin" json:"origin"`
+	Card         string                `bson:"card,omitempty" json:"card,omitempty"` //raw card of unknown types
+}
+
+func (d Dynamic) GetTimestamp() int64 {
+	return d.Timestamp
 }
 
 type DynamicWithLastModified struct {
 	Dynamic            `bson:",inline"`
 	LastModifiedFields `bson:",inline"`
+	SoftDeleteFields   `bson:",inline"`
 }
 
 type DynamicWithObjectId struct {
 	ObjectIdFields          `bson:",inline"`
 	DynamicWithLastModified `bson:",inline"`
 }
+
+const (
+	UpsertResultInserted  = "inserted"
+	UpsertResultUpdated   = "updated"
+	UpsertResultUnchanged = "unchanged"
+)
@@ -10,6 +10,8 @@ type LiveCut struct {
 type LiveCutDialogue struct {
 	Speaker string `bson:"speaker" json:"speaker"`
 	Text    string `bson:"text" json:"text"`
+	Start   *int64 `bson:"start,omitempty" json:"start,omitempty"` //offset from the beginning of the cut, in milliseconds
+	End     *int64 `bson:"end,omitempty" json:"end,omitempty"`
 }
 
 type Live struct {
@@ -22,9 +24,14 @@ type Live struct {
 	Cuts             *[]LiveCut            `bson:"cuts" json:"cuts"`
 }
 
+func (l Live) GetTimestamp() int64 {
+	return l.Timestamp
+}
+
 type LiveWithLastModified struct {
 	Live               `bson:",inline"`
 	LastModifiedFields `bson:",inline"`
+	SoftDeleteFields   `bson:",inline"`
 }
 
 type LiveWithObjectId struct {
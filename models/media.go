@@ -0,0 +1,31 @@
+package models
+
+const (
+	MediaStatusPending = "pending"
+	MediaStatusDone    = "done"
+	MediaStatusFailed  = "failed"
+)
+
+type Media struct {
+	Url         string `bson:"url" json:"url"` //original url
+	Status      string `bson:"status" json:"status"`
+	Hash        string `bson:"hash" json:"hash"` //sha256 of the content, files with the same hash share one local copy
+	LocalUrl    string `bson:"local_url" json:"local_url"`
+	Size        int64  `bson:"size" json:"size"`
+	ContentType string `bson:"content_type" json:"content_type"`
+	Attempts    int    `bson:"attempts" json:"attempts"`
+	LastError   string `bson:"last_error" json:"last_error"`
+	AddTime     int64  `bson:"add_time" json:"add_time"`
+	UpdateTime  int64  `bson:"update_time" json:"update_time"`
+}
+
+type MediaWithObjectId struct {
+	ObjectIdFields `bson:",inline"`
+	Media          `bson:",inline"`
+}
+
+type MediaStatusCount struct {
+	Status string `bson:"_id" json:"status"`
+	Count  int64  `bson:"count" json:"count"`
+	Size   int64  `bson:"size" json:"size"`
+}
@@ -0,0 +1,50 @@
+package models
+
+import (
+	"encoding/json"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+const (
+	MemoryKindDynamic   = "dynamic"
+	MemoryKindLive      = "live"
+	MemoryKindMilestone = "milestone"
+	MemoryKindDialogue  = "dialogue"
+)
+
+// MemoryItem is an item on the memory timeline, implemented by the *WithObjectId types of every memory kind
+type MemoryItem interface {
+	GetId() primitive.ObjectID
+	GetTimestamp() int64
+	GetLastModified() int64
+}
+
+type MemorySearchParams struct {
+	PageParams
+	Keyword  string `form:"q"`
+	Kinds    string `form:"kind"` //comma separated kinds, empty for all
+	From     string `form:"from"` //2006.01.02
+	To       string `form:"to"`   //2006.01.02
+	Speaker  string `form:"speaker"`
+	Timezone string `form:"tz"`
+}
+
+type MemoryTimelineParams struct {
+	Before *int64 `form:"before"` //timestamp in seconds, exclusive
+	After  *int64 `form:"after"`  //timestamp in seconds, exclusive
+	Limit  int64  `form:"limit"`
+	Render string `form:"render"` //html or tokens, empty for not rendering the content of dynamics
+}
+
+type MemoryExportParams struct {
+	Kinds    string `form:"kind"` //comma separated kinds, empty for all
+	From     string `form:"from"` //2006.01.02
+	To       string `form:"to"`   //2006.01.02
+	Timezone string `form:"tz"`
+}
+
+// MemoryTransferLine is one line of an exported NDJSON file, Item is the dynamic or live itself, by kind
+type MemoryTransferLine struct {
+	Kind string          `json:"kind"`
+	Item json.RawMessage `json:"item"`
+}
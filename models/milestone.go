@@ -0,0 +1,23 @@
+package models
+
+type Milestone struct {
+	Title       string `bson:"title" json:"title"`
+	Description string `bson:"description" json:"description"`
+	Image       string `bson:"image" json:"image"`
+	Timestamp   int64  `bson:"timestamp" json:"timestamp"`
+}
+
+func (m Milestone) GetTimestamp() int64 {
+	return m.Timestamp
+}
+
+type MilestoneWithLastModified struct {
+	Milestone          `bson:",inline"`
+	LastModifiedFields `bson:",inline"`
+	SoftDeleteFields   `bson:",inline"`
+}
+
+type MilestoneWithObjectId struct {
+	ObjectIdFields            `bson:",inline"`
+	MilestoneWithLastModified `bson:",inline"`
+}
@@ -1,8 +1,8 @@
 package models
 
 type PageParams struct {
-	PageSize  *int64 `json:"pageSize"`
-	PageIndex *int64 `json:"pageIndex"`
+	PageSize  *int64 `json:"pageSize" form:"pageSize"`
+	PageIndex *int64 `json:"pageIndex" form:"pageIndex"`
 }
 
 type PageResult struct {
@@ -0,0 +1,31 @@
+package models
+
+const (
+	PermissionAll               = "*"
+	PermissionArticleWrite      = "article:write"
+	PermissionArticleReadHidden = "article:read-hidden"
+	PermissionMemoryWrite       = "memory:write"
+	PermissionMemoryRestore     = "memory:restore" //restore the deleted dynamics and lives
+	PermissionMemoryPurge       = "memory:purge"   //permanently remove the deleted dynamics and lives
+	PermissionVoiceWrite        = "voice:write"
+	PermissionVoiceWriteAll     = "voice:write-all"
+	PermissionUserManage        = "user:manage"
+	PermissionAuditRead         = "audit:read"
+)
+
+var AllPermissions = []string{
+	PermissionArticleWrite,
+	PermissionArticleReadHidden,
+	PermissionMemoryWrite,
+	PermissionMemoryRestore,
+	PermissionMemoryPurge,
+	PermissionVoiceWrite,
+	PermissionVoiceWriteAll,
+	PermissionUserManage,
+	PermissionAuditRead,
+}
+
+var DefaultRolePermissions = map[string][]string{
+	"admin":         {PermissionAll},
+	"voice-manager": {PermissionVoiceWrite},
+}
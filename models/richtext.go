@@ -0,0 +1,23 @@
+package models
+
+const (
+	RichTextTokenText    = "text"
+	RichTextTokenMention = "mention"
+	RichTextTokenTopic   = "topic"
+	RichTextTokenEmoji   = "emoji"
+	RichTextTokenLink    = "link"
+)
+
+const (
+	RenderFormatHtml   = "html"
+	RenderFormatTokens = "tokens"
+)
+
+// RichTextToken is a span of the content of a dynamic, Text is the text to show
+type RichTextToken struct {
+	Type string `json:"type"`
+	Text string `json:"text"`
+	Uid  int64  `json:"uid,omitempty"`  //the mentioned user
+	Name string `json:"name,omitempty"` //topic or emoji name without the # or [] around it
+	Url  string `json:"url,omitempty"`
+}
@@ -0,0 +1,20 @@
+package models
+
+// ScheduledLive announces an upcoming live, it is listed until a Live starts near its timestamp
+type ScheduledLive struct {
+	Timestamp        int64                 `bson:"timestamp" json:"timestamp"` //planned start time, in seconds
+	Title            string                `bson:"title" json:"title"`
+	Content          string                `bson:"content" json:"content"`
+	UserProfile      *DynamicUserProfile   `bson:"user_profile" json:"user_profile"`
+	JoinUserProfiles *[]DynamicUserProfile `bson:"join_user_profiles" json:"join_user_profiles"`
+}
+
+type ScheduledLiveWithLastModified struct {
+	ScheduledLive      `bson:",inline"`
+	LastModifiedFields `bson:",inline"`
+}
+
+type ScheduledLiveWithObjectId struct {
+	ObjectIdFields                `bson:",inline"`
+	ScheduledLiveWithLastModified `bson:",inline"`
+}
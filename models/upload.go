@@ -0,0 +1,35 @@
+package models
+
+const (
+	UploadKindRecord = "record" //full record of a live, for Live.FullRecord
+	UploadKindCut    = "cut"    //clip of a live cut, for LiveCut.File
+)
+
+const (
+	UploadStatusUploading = "uploading"
+	UploadStatusDone      = "done"
+)
+
+type UploadBaseFields struct {
+	Kind     string `bson:"kind" json:"kind"`
+	FileName string `bson:"file_name" json:"file_name"` //original file name
+	Size     int64  `bson:"size" json:"size"`
+	Duration int64  `bson:"duration" json:"duration"` //in milliseconds, probed by ffprobe when configured
+	Checksum string `bson:"checksum" json:"checksum"` //sha256 in hex, checked against the uploaded file when given
+}
+
+type Upload struct {
+	UploadBaseFields `bson:",inline"`
+	Status           string `bson:"status" json:"status"`
+	Received         int64  `bson:"received" json:"received"`
+	HashState        []byte `bson:"hash_state" json:"-"` //the marshaled sha256 state of the received bytes
+	FilePath         string `bson:"file_path" json:"file_path"`
+	Url              string `bson:"url" json:"url"`
+	AddTime          int64  `bson:"add_time" json:"add_time"`
+	UpdateTime       int64  `bson:"update_time" json:"update_time"`
+}
+
+type UploadWithObjectId struct {
+	ObjectIdFields `bson:",inline"`
+	Upload         `bson:",inline"`
+}
@@ -3,15 +3,31 @@ package models
 type UserBaseFields struct {
 	Name      string   `bson:"name" json:"name"`
 	LoginName string   `bson:"loginName" json:"loginName"`
+	Email     string   `bson:"email" json:"email"`
 	Roles     []string `bson:"roles" json:"roles"`
 }
 
+type UserStatusFields struct {
+	Disabled bool `bson:"disabled" json:"disabled"`
+}
+
+type UserGrantFields struct {
+	VoiceLivers []string `bson:"voiceLivers" json:"voiceLivers"`
+}
+
+type UserOidcFields struct {
+	OidcSubject string `bson:"oidcSubject" json:"oidcSubject"`
+}
+
 type UserSecurityField struct {
 	Password string `bson:"password" json:"password"`
 }
 
 type User struct {
 	UserBaseFields    `bson:",inline"`
+	UserStatusFields  `bson:",inline"`
+	UserGrantFields   `bson:",inline"`
+	UserOidcFields    `bson:",inline"`
 	UserSecurityField `bson:",inline"`
 }
 
@@ -19,3 +35,17 @@ type UserWithObjectId struct {
 	ObjectIdFields `bson:",inline"`
 	User           `bson:",inline"`
 }
+
+type UserPage struct {
+	PageResult
+	Data []*UserWithObjectId
+}
+
+type OidcIdentity struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	Groups            []string
+}
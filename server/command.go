@@ -0,0 +1,105 @@
+package server
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"mihiru-go/models"
+	"mihiru-go/services"
+	"os"
+)
+
+const commandUsage = `Usage:
+  server -e {mode} export [-o file] [-kind dynamic,live] [-from 2006.01.02] [-to 2006.01.02] [-tz zone]
+  server -e {mode} import [-i file] [-dry-run]
+  server -e {mode} dedupe-dynamics [-dry-run]`
+
+// RunCommand runs a maintenance subcommand instead of starting the server
+func RunCommand(args []string) {
+	switch args[0] {
+	case "export":
+		exportMemory(args[1:])
+	case "import":
+		importMemory(args[1:])
+	case "dedupe-dynamics":
+		dedupeDynamics(args[1:])
+	default:
+		fmt.Println(commandUsage)
+		os.Exit(1)
+	}
+}
+
+func newCommandMemoryService() services.MemoryService {
+	db := connectDatabase()
+	memorySources := services.NewMemorySources(db, db, db)
+	return services.NewMemoryService(db, db, db, db, services.NewAuditService(db), memorySources)
+}
+
+func exportMemory(args []string) {
+	flagSet := flag.NewFlagSet("export", flag.ExitOnError)
+	output := flagSet.String("o", "", "output file, stdout when empty")
+	var params models.MemoryExportParams
+	flagSet.StringVar(&params.Kinds, "kind", "", "comma separated kinds, all when empty")
+	flagSet.StringVar(&params.From, "from", "", "first day, 2006.01.02")
+	flagSet.StringVar(&params.To, "to", "", "last day, 2006.01.02")
+	flagSet.StringVar(&params.Timezone, "tz", "", "timezone of the days, memory.timezone when empty")
+	_ = flagSet.Parse(args)
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := newCommandMemoryService().Export(&params, w); err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+func importMemory(args []string) {
+	flagSet := flag.NewFlagSet("import", flag.ExitOnError)
+	input := flagSet.String("i", "", "input file, stdin when empty")
+	dryRun := flagSet.Bool("dry-run", false, "only report what would change")
+	_ = flagSet.Parse(args)
+	var r io.Reader = os.Stdin
+	if *input != "" {
+		file, err := os.Open(*input)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		defer file.Close()
+		r = file
+	}
+	result, err := newCommandMemoryService().Import(nil, r, *dryRun)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	report, _ := json.MarshalIndent(result, "", "  ")
+	fmt.Println(string(report))
+	if result.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+// dedupeDynamics removes the duplicated dynamic ids which keep the unique dynamic_id index from being built
+func dedupeDynamics(args []string) {
+	flagSet := flag.NewFlagSet("dedupe-dynamics", flag.ExitOnError)
+	dryRun := flagSet.Bool("dry-run", false, "only report the duplicated dynamic ids")
+	_ = flagSet.Parse(args)
+	dynamicIds, removed, err := connectDatabase().DedupeDynamics(*dryRun)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+	for _, dynamicId := range dynamicIds {
+		fmt.Println(dynamicId)
+	}
+	if *dryRun {
+		fmt.Printf("%d duplicated dynamic ids, %d dynamics would be removed\n", len(dynamicIds), removed)
+	} else {
+		fmt.Printf("%d duplicated dynamic ids, %d dynamics removed\n", len(dynamicIds), removed)
+	}
+}
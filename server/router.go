@@ -7,12 +7,10 @@ import (
 	"mihiru-go/controllers"
 	"mihiru-go/database"
 	"mihiru-go/middleware"
+	"mihiru-go/models"
 	"mihiru-go/services"
 )
 
-var adminRole = []string{"admin"}
-var voiceManagerRole = []string{"admin", "voice-manager"}
-
 func NewRouter(db *database.MongoDatabase) *gin.Engine {
 	router := gin.New()
 	router.Use(gin.Logger())
@@ -22,54 +20,149 @@ func NewRouter(db *database.MongoDatabase) *gin.Engine {
 	corsConfig.AddAllowHeaders("Authorization")
 	router.Use(cors.New(corsConfig))
 
-	userService := services.NewUserService(db)
+	auditService := services.NewAuditService(db)
+	auditService.StartCleaner()
+	auditController := controllers.NewAuditController(auditService)
+
+	userService := services.NewUserService(db, auditService)
 	userService.InitUser()
-	userController := controllers.NewUserController(userService)
+	oidcService := services.NewOidcService()
+	userController := controllers.NewUserController(userService, oidcService)
 
-	articleService := services.NewArticleService(db)
+	articleService := services.NewArticleService(db, auditService)
 	articlesController := controllers.NewArticlesController(articleService, userService)
 
-	memoryService := services.NewMemoryService(db, db)
+	memorySources := services.NewMemorySources(db, db, db)
+	memoryService := services.NewMemoryService(db, db, db, db, auditService, memorySources)
 	memoryController := controllers.NewMemoryController(memoryService)
+	milestoneService := services.NewMilestoneService(db, auditService)
+	milestoneController := controllers.NewMilestoneController(milestoneService)
+	scheduledLiveService := services.NewScheduledLiveService(db, db, memoryService, auditService)
+	scheduledLiveController := controllers.NewScheduledLiveController(scheduledLiveService)
+	crawlerService := services.NewCrawlerService(memoryService, db)
+	crawlerService.Start()
+	crawlerController := controllers.NewCrawlerController(crawlerService)
+	archiverService := services.NewArchiverService(memorySources, db, db)
+	archiverService.Start()
+	archiverController := controllers.NewArchiverController(archiverService)
+	uploadService := services.NewUploadService(db, db, auditService)
+	uploadController := controllers.NewUploadController(uploadService)
 
-	voiceService := services.NewVoiceService(db)
+	voiceService := services.NewVoiceService(db, auditService)
 	voiceController := controllers.NewVoiceController(voiceService)
 
-	permissions := middleware.NewPermissions(userService)
+	apiKeyService := services.NewApiKeyService(db, db, auditService)
+	apiKeyController := controllers.NewApiKeyController(apiKeyService)
+
+	permissions := middleware.NewPermissions(userService, apiKeyService)
 
 	articlesGroup := router.Group("articles")
 	{
-		articlesGroup.POST("", permissions.Roles(adminRole), articlesController.Add)
+		articlesGroup.POST("", permissions.Require(models.PermissionArticleWrite), articlesController.Add)
 		articlesGroup.POST("/search", articlesController.Search)
 		articlesGroup.GET("/tags", articlesController.Tags)
-		articlesGroup.PUT("/:id", permissions.Roles(adminRole), articlesController.Update)
+		articlesGroup.PUT("/:id", permissions.Require(models.PermissionArticleWrite), articlesController.Update)
 		articlesGroup.GET("/:id", articlesController.Get)
 	}
 
 	userGroup := router.Group("user")
 	{
-		userGroup.POST("", permissions.Roles(adminRole), userController.Add)
+		userGroup.POST("", permissions.Require(models.PermissionUserManage), userController.Add)
 		userGroup.POST("/login", userController.Login)
+		userGroup.GET("/oidc/login", userController.OidcLogin)
+		userGroup.GET("/oidc/callback", userController.OidcCallback)
 		userGroup.POST("/changePassword", permissions.Login(), userController.ChangePassword)
+		userGroup.GET("", permissions.Require(models.PermissionUserManage), userController.List)
+		userGroup.GET("/me", permissions.Login(), userController.Me)
+		userGroup.GET("/permissions", permissions.Login(), userController.Permissions)
+		userGroup.GET("/roles", permissions.Require(models.PermissionUserManage), userController.Roles)
+		userGroup.GET("/:id", permissions.Require(models.PermissionUserManage), userController.Get)
+		userGroup.PUT("/:id", permissions.Require(models.PermissionUserManage), userController.Update)
+		userGroup.POST("/:id/enable", permissions.Require(models.PermissionUserManage), userController.Enable)
+		userGroup.POST("/:id/disable", permissions.Require(models.PermissionUserManage), userController.Disable)
+		userGroup.DELETE("/:id", permissions.Require(models.PermissionUserManage), userController.Delete)
+		userGroup.PUT("/:id/voice-livers", permissions.Require(models.PermissionUserManage), userController.SetVoiceLivers)
+	}
+
+	apiKeyGroup := router.Group("apikey")
+	{
+		apiKeyGroup.GET("", permissions.Require(models.PermissionUserManage), apiKeyController.List)
+		apiKeyGroup.POST("", permissions.Require(models.PermissionUserManage), apiKeyController.Add)
+		apiKeyGroup.DELETE("/:id", permissions.Require(models.PermissionUserManage), apiKeyController.Delete)
 	}
 
 	memoryGroup := router.Group("memory")
 	{
-		memoryGroup.POST("/dynamic", permissions.Roles(adminRole), memoryController.AddDynamic)
-		memoryGroup.PUT("/dynamic/:id", permissions.Roles(adminRole), memoryController.UpdateDynamic)
-		memoryGroup.POST("/live", permissions.Roles(adminRole), memoryController.AddLive)
-		memoryGroup.PUT("/live/:id", permissions.Roles(adminRole), memoryController.UpdateLive)
+		memoryGroup.POST("/dynamic", permissions.Require(models.PermissionMemoryWrite), memoryController.AddDynamic)
+		memoryGroup.PUT("/dynamic/:id", permissions.Require(models.PermissionMemoryWrite), memoryController.UpdateDynamic)
+		memoryGroup.PUT("/dynamic/by-dynamic-id/:dynamicId", permissions.Require(models.PermissionMemoryWrite), memoryController.UpsertDynamic)
+		memoryGroup.POST("/dynamic/batch", permissions.Require(models.PermissionMemoryWrite), memoryController.BatchUpsertDynamic)
+		memoryGroup.POST("/dynamic/raw", permissions.Require(models.PermissionMemoryWrite), memoryController.ImportRawDynamic)
+		memoryGroup.DELETE("/dynamic/:id", permissions.Require(models.PermissionMemoryWrite), memoryController.DeleteDynamic)
+		memoryGroup.POST("/dynamic/:id/restore", permissions.Require(models.PermissionMemoryRestore), memoryController.RestoreDynamic)
+		memoryGroup.DELETE("/dynamic/:id/purge", permissions.Require(models.PermissionMemoryPurge), memoryController.PurgeDynamic)
+		memoryGroup.POST("/live", permissions.Require(models.PermissionMemoryWrite), memoryController.AddLive)
+		memoryGroup.PUT("/live/:id", permissions.Require(models.PermissionMemoryWrite), memoryController.UpdateLive)
+		memoryGroup.DELETE("/live/:id", permissions.Require(models.PermissionMemoryWrite), memoryController.DeleteLive)
+		memoryGroup.POST("/live/:id/restore", permissions.Require(models.PermissionMemoryRestore), memoryController.RestoreLive)
+		memoryGroup.DELETE("/live/:id/purge", permissions.Require(models.PermissionMemoryPurge), memoryController.PurgeLive)
+		memoryGroup.GET("/live/:id/cuts/:cut/subtitle", memoryController.CutSubtitle)
+		memoryGroup.PUT("/live/:id/cuts/:cut/subtitle", permissions.Require(models.PermissionMemoryWrite), memoryController.ImportCutSubtitle)
+		memoryGroup.POST("/live/:id/cuts", permissions.Require(models.PermissionMemoryWrite), memoryController.AddLiveCut)
+		memoryGroup.PUT("/live/:id/cut-order", permissions.Require(models.PermissionMemoryWrite), memoryController.ReorderLiveCuts)
+		memoryGroup.PUT("/live/:id/cuts/:cut", permissions.Require(models.PermissionMemoryWrite), memoryController.UpdateLiveCut)
+		memoryGroup.DELETE("/live/:id/cuts/:cut", permissions.Require(models.PermissionMemoryWrite), memoryController.DeleteLiveCut)
+		memoryGroup.POST("/live/:id/cuts/:cut/dialogues", permissions.Require(models.PermissionMemoryWrite), memoryController.AddLiveCutDialogue)
+		memoryGroup.PUT("/live/:id/cuts/:cut/dialogue-order", permissions.Require(models.PermissionMemoryWrite), memoryController.ReorderLiveCutDialogues)
+		memoryGroup.PUT("/live/:id/cuts/:cut/dialogues/:dialogue", permissions.Require(models.PermissionMemoryWrite), memoryController.UpdateLiveCutDialogue)
+		memoryGroup.DELETE("/live/:id/cuts/:cut/dialogues/:dialogue", permissions.Require(models.PermissionMemoryWrite), memoryController.DeleteLiveCutDialogue)
+		memoryGroup.GET("/export", permissions.Require(models.PermissionMemoryWrite), memoryController.Export)
+		memoryGroup.POST("/import", permissions.Require(models.PermissionMemoryWrite), memoryController.Import)
+		memoryGroup.GET("/crawler/status", permissions.Require(models.PermissionMemoryWrite), crawlerController.Status)
+		memoryGroup.POST("/crawler/run", permissions.Require(models.PermissionMemoryWrite), crawlerController.Run)
+		memoryGroup.GET("/milestones", milestoneController.List)
+		memoryGroup.GET("/milestone/:id", milestoneController.Get)
+		memoryGroup.POST("/milestone", permissions.Require(models.PermissionMemoryWrite), milestoneController.Add)
+		memoryGroup.PUT("/milestone/:id", permissions.Require(models.PermissionMemoryWrite), milestoneController.Update)
+		memoryGroup.DELETE("/milestone/:id", permissions.Require(models.PermissionMemoryWrite), milestoneController.Delete)
+		memoryGroup.GET("/scheduled-lives", scheduledLiveController.List)
+		memoryGroup.POST("/scheduled-live", permissions.Require(models.PermissionMemoryWrite), scheduledLiveController.Add)
+		memoryGroup.PUT("/scheduled-live/:id", permissions.Require(models.PermissionMemoryWrite), scheduledLiveController.Update)
+		memoryGroup.DELETE("/scheduled-live/:id", permissions.Require(models.PermissionMemoryWrite), scheduledLiveController.Delete)
+		memoryGroup.GET("/lives.ics", scheduledLiveController.Calendar)
+		memoryGroup.GET("/archiver/status", permissions.Require(models.PermissionMemoryWrite), archiverController.Status)
+		memoryGroup.POST("/archiver/run", permissions.Require(models.PermissionMemoryWrite), archiverController.Run)
+		memoryGroup.GET("/uploads", permissions.Require(models.PermissionMemoryWrite), uploadController.List)
+		memoryGroup.POST("/uploads", permissions.Require(models.PermissionMemoryWrite), uploadController.Create)
+		memoryGroup.GET("/uploads/:id", permissions.Require(models.PermissionMemoryWrite), uploadController.Get)
+		memoryGroup.PUT("/uploads/:id", permissions.Require(models.PermissionMemoryWrite), uploadController.WriteChunk)
+		memoryGroup.DELETE("/uploads/:id", permissions.Require(models.PermissionMemoryWrite), uploadController.Delete)
 		memoryGroup.GET("/days", memoryController.Days)
+		memoryGroup.GET("/months", memoryController.Months)
+		memoryGroup.GET("/years", memoryController.Years)
+		memoryGroup.GET("/heatmap/:year", memoryController.Heatmap)
+		memoryGroup.GET("/search", memoryController.Search)
+		memoryGroup.GET("/timeline", memoryController.Timeline)
+		memoryGroup.GET("/co-streamers", memoryController.CoStreamers)
+		memoryGroup.GET("/co-streamers/:uid/lives", memoryController.CoStreamerLives)
 		memoryGroup.GET("/day/:day", memoryController.Day)
+		memoryGroup.GET("/v1/day/:day", memoryController.Day)
+		memoryGroup.GET("/v2/day/:day", memoryController.DayItems)
+		memoryGroup.GET("/on-this-day/:date", memoryController.OnThisDay)
 	}
 
 	voiceGroup := router.Group("voice")
 	{
-		voiceGroup.POST("", permissions.Roles(voiceManagerRole), voiceController.AddVoice)
-		voiceGroup.PUT("/:id", permissions.Roles(voiceManagerRole), voiceController.UpdateVoice)
-		voiceGroup.DELETE("/:id", permissions.Roles(voiceManagerRole), voiceController.DeleteVoice)
+		voiceGroup.POST("", permissions.Require(models.PermissionVoiceWrite), voiceController.AddVoice)
+		voiceGroup.PUT("/:id", permissions.Require(models.PermissionVoiceWrite), voiceController.UpdateVoice)
+		voiceGroup.DELETE("/:id", permissions.Require(models.PermissionVoiceWrite), voiceController.DeleteVoice)
 		voiceGroup.GET("/:liver", voiceController.LiverVoices)
 	}
 
+	auditGroup := router.Group("audit")
+	{
+		auditGroup.GET("", permissions.Require(models.PermissionAuditRead), auditController.Search)
+	}
+
 	return router
 }
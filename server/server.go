@@ -7,14 +7,22 @@ import (
 )
 
 func Init() {
-	configs := config.GetConfigs()
-	mongoDatabase, err := database.New(configs.GetString("database.uri"), configs.GetString("database.username"), configs.GetString("database.password"), configs.GetString("database.dbname"))
-	if err != nil {
+	mongoDatabase := connectDatabase()
+	if err := mongoDatabase.EnsureIndexes(); err != nil {
 		log.Fatal(err.Error())
 	}
 	r := NewRouter(mongoDatabase)
-	err = r.Run(configs.GetStringSlice("server.addr")...)
+	err := r.Run(config.GetConfigs().GetStringSlice("server.addr")...)
+	if err != nil {
+		log.Fatal(err.Error())
+	}
+}
+
+func connectDatabase() *database.MongoDatabase {
+	configs := config.GetConfigs()
+	mongoDatabase, err := database.New(configs.GetString("database.uri"), configs.GetString("database.username"), configs.GetString("database.password"), configs.GetString("database.dbname"))
 	if err != nil {
 		log.Fatal(err.Error())
 	}
+	return mongoDatabase
 }
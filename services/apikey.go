@@ -0,0 +1,163 @@
+package services
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"mihiru-go/config"
+	"mihiru-go/database"
+	"mihiru-go/dto"
+	"mihiru-go/models"
+	"mihiru-go/util"
+	"mihiru-go/vo"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type ApiKeyService interface {
+	Add(operator *vo.Operator, apiKeyDto *dto.ApiKeyDto) (*vo.ApiKeyVo, error)
+	List() ([]*vo.ApiKeyVo, error)
+	Delete(operator *vo.Operator, id primitive.ObjectID) error
+	CheckApiKey(key string) *vo.UserVo
+}
+
+type apiKeyService struct {
+	keyEncoderKey []byte
+	db            database.ApiKeyDatabase
+	userDb        database.UserDatabase
+	auditService  AuditService
+}
+
+const apiKeyPrefix = "mk_"
+const apiKeyLastUsedInterval = int64(60 * 1000)
+
+func NewApiKeyService(db database.ApiKeyDatabase, userDb database.UserDatabase, auditService AuditService) ApiKeyService {
+	return apiKeyService{
+		keyEncoderKey: []byte(config.GetConfigs().GetString("security.password-key")),
+		db:            db,
+		userDb:        userDb,
+		auditService:  auditService,
+	}
+}
+
+func (a apiKeyService) Add(operator *vo.Operator, apiKeyDto *dto.ApiKeyDto) (*vo.ApiKeyVo, error) {
+	if strings.TrimSpace(apiKeyDto.Name) == "" {
+		return nil, vo.NewErrorWithHttpStatus("缺少必要参数", http.StatusBadRequest)
+	}
+	if len(apiKeyDto.Permissions) == 0 {
+		return nil, vo.NewErrorWithHttpStatus("请至少指定一项权限", http.StatusBadRequest)
+	}
+	for _, permission := range apiKeyDto.Permissions {
+		if !isKnownPermission(permission) {
+			return nil, vo.NewErrorWithHttpStatus("无效的权限: "+permission, http.StatusBadRequest)
+		}
+	}
+	now := time.Now().UnixNano() / 1e6
+	if apiKeyDto.ExpireTime != 0 && apiKeyDto.ExpireTime <= now {
+		return nil, vo.NewErrorWithHttpStatus("过期时间必须晚于当前时间", http.StatusBadRequest)
+	}
+	random, err := secureRandString(48)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("生成密钥失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	key := apiKeyPrefix + random
+	apiKey := new(models.ApiKeyWithObjectId)
+	apiKey.ApiKeyBaseFields = apiKeyDto.ApiKeyBaseFields
+	apiKey.Prefix = key[:len(apiKeyPrefix)+6]
+	apiKey.CreatedBy = operator.ID
+	if operator.ApiKeyOwner != nil {
+		// a key created by a key belongs to the same user, so that disabling the user revokes both
+		apiKey.CreatedBy = *operator.ApiKeyOwner
+	}
+	apiKey.AddTime = now
+	apiKey.Hash = encodePassword(key, a.keyEncoderKey)
+	err = a.db.InsertApiKey(apiKey)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("添加数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	apiKeyVo := convertToApiKeyVo(apiKey)
+	a.auditService.Record(operator, models.AuditActionAdd, models.AuditTargetApiKey, apiKey.ID.Hex(), nil, apiKeyVo)
+	apiKeyVo.Key = key
+	return apiKeyVo, nil
+}
+
+func (a apiKeyService) List() ([]*vo.ApiKeyVo, error) {
+	apiKeys, err := a.db.ListApiKey()
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	result := []*vo.ApiKeyVo{}
+	for _, apiKey := range apiKeys {
+		result = append(result, convertToApiKeyVo(apiKey))
+	}
+	return result, nil
+}
+
+func (a apiKeyService) Delete(operator *vo.Operator, id primitive.ObjectID) error {
+	apiKey, err := a.db.DeleteApiKey(id)
+	if err != nil {
+		util.LogError(err)
+		return vo.NewErrorWithHttpStatus("删除数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if apiKey == nil {
+		return vo.NewErrorWithHttpStatus("数据不存在", http.StatusNotFound)
+	}
+	a.auditService.Record(operator, models.AuditActionDelete, models.AuditTargetApiKey, id.Hex(), convertToApiKeyVo(apiKey), nil)
+	return nil
+}
+
+func (a apiKeyService) CheckApiKey(key string) *vo.UserVo {
+	if !strings.HasPrefix(key, apiKeyPrefix) {
+		return nil
+	}
+	apiKey, err := a.db.GetApiKeyByHash(encodePassword(key, a.keyEncoderKey))
+	if err != nil {
+		util.LogError(err)
+		return nil
+	}
+	if apiKey == nil {
+		return nil
+	}
+	now := time.Now().UnixNano() / 1e6
+	if apiKey.ExpireTime != 0 && apiKey.ExpireTime <= now {
+		return nil
+	}
+	// the keys of a deleted or disabled user stop working with the user
+	creator, err := a.userDb.GetUserById(apiKey.CreatedBy)
+	if err != nil {
+		util.LogError(err)
+		return nil
+	}
+	if creator == nil || creator.Disabled {
+		return nil
+	}
+	if now-apiKey.LastUsedTime > apiKeyLastUsedInterval {
+		util.LogError(a.db.UpdateApiKeyLastUsedTime(apiKey.ID, now))
+	}
+	userVo := new(vo.UserVo)
+	userVo.ID = apiKey.ID
+	userVo.Name = apiKey.Name
+	userVo.LoginName = apiKey.Prefix
+	userVo.Permissions = apiKey.Permissions
+	userVo.ApiKeyOwner = &apiKey.CreatedBy
+	return userVo
+}
+
+func isKnownPermission(permission string) bool {
+	for _, p := range models.AllPermissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+func convertToApiKeyVo(apiKey *models.ApiKeyWithObjectId) *vo.ApiKeyVo {
+	apiKeyVo := new(vo.ApiKeyVo)
+	apiKeyVo.ObjectIdFields = apiKey.ObjectIdFields
+	apiKeyVo.ApiKeyBaseFields = apiKey.ApiKeyBaseFields
+	apiKeyVo.ApiKeyAutoGenFields = apiKey.ApiKeyAutoGenFields
+	return apiKeyVo
+}
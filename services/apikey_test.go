@@ -0,0 +1,84 @@
+package services
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"mihiru-go/database"
+	"mihiru-go/models"
+	"testing"
+	"time"
+)
+
+// mockApiKeyDatabase only implements the lookups used by CheckApiKey
+type mockApiKeyDatabase struct {
+	database.ApiKeyDatabase
+	apiKey *models.ApiKeyWithObjectId
+}
+
+func (m *mockApiKeyDatabase) GetApiKeyByHash(hash string) (*models.ApiKeyWithObjectId, error) {
+	if m.apiKey == nil || m.apiKey.Hash != hash {
+		return nil, nil
+	}
+	return m.apiKey, nil
+}
+
+func (m *mockApiKeyDatabase) UpdateApiKeyLastUsedTime(id primitive.ObjectID, lastUsedTime int64) error {
+	m.apiKey.LastUsedTime = lastUsedTime
+	return nil
+}
+
+type mockUserDatabase struct {
+	database.UserDatabase
+	users map[primitive.ObjectID]*models.UserWithObjectId
+}
+
+func (m *mockUserDatabase) GetUserById(id primitive.ObjectID) (*models.UserWithObjectId, error) {
+	return m.users[id], nil
+}
+
+func TestCheckApiKey(t *testing.T) {
+	const key = apiKeyPrefix + "secret"
+	encoderKey := []byte("test")
+	admin := &models.UserWithObjectId{ObjectIdFields: models.ObjectIdFields{ID: primitive.NewObjectID()}}
+	disabled := &models.UserWithObjectId{ObjectIdFields: models.ObjectIdFields{ID: primitive.NewObjectID()}}
+	disabled.Disabled = true
+	userDb := &mockUserDatabase{users: map[primitive.ObjectID]*models.UserWithObjectId{admin.ID: admin, disabled.ID: disabled}}
+	now := time.Now().UnixNano() / 1e6
+	tests := []struct {
+		name       string
+		key        string
+		createdBy  primitive.ObjectID
+		expireTime int64
+		valid      bool
+	}{
+		{name: "valid", key: key, createdBy: admin.ID, valid: true},
+		{name: "not expired yet", key: key, createdBy: admin.ID, expireTime: now + 60000, valid: true},
+		{name: "expired", key: key, createdBy: admin.ID, expireTime: now - 60000},
+		{name: "wrong key", key: apiKeyPrefix + "other", createdBy: admin.ID},
+		{name: "creator disabled", key: key, createdBy: disabled.ID},
+		{name: "creator deleted", key: key, createdBy: primitive.NewObjectID()},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			apiKey := &models.ApiKeyWithObjectId{ObjectIdFields: models.ObjectIdFields{ID: primitive.NewObjectID()}}
+			apiKey.Name = "automation"
+			apiKey.Permissions = []string{models.PermissionMemoryWrite}
+			apiKey.ExpireTime = test.expireTime
+			apiKey.CreatedBy = test.createdBy
+			apiKey.Hash = encodePassword(key, encoderKey)
+			service := apiKeyService{keyEncoderKey: encoderKey, db: &mockApiKeyDatabase{apiKey: apiKey}, userDb: userDb}
+			userVo := service.CheckApiKey(test.key)
+			if !test.valid {
+				if userVo != nil {
+					t.Errorf("expected the key to be rejected, got %+v", userVo)
+				}
+				return
+			}
+			if userVo == nil {
+				t.Fatal("expected the key to be accepted")
+			}
+			if userVo.ID != apiKey.ID || userVo.ApiKeyOwner == nil || *userVo.ApiKeyOwner != admin.ID || !userVo.HasPermission(models.PermissionMemoryWrite) {
+				t.Errorf("unexpected caller %+v", userVo)
+			}
+		})
+	}
+}
@@ -0,0 +1,511 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mihiru-go/config"
+	"mihiru-go/database"
+	"mihiru-go/models"
+	"mihiru-go/util"
+	"mihiru-go/vo"
+	"mime"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+type ArchiverService interface {
+	Start()
+	Run() (*vo.ArchiverRunResultVo, error)
+	Status() (*vo.ArchiverStatusVo, error)
+}
+
+type archiverService struct {
+	sources       []MemorySource
+	mediaDatabase database.MediaDatabase
+	stateDatabase database.StateDatabase
+	httpClient    *http.Client
+	enabled       bool
+	interval      time.Duration
+	baseFolder    string
+	basePath      string
+	upstream      string
+	allowedHosts  []string
+	referer       string
+	userAgent     string
+	maxSize       int64
+	maxAttempts   int
+	batchSize     int64
+}
+
+const archiverAddBatchSize = 500
+
+// archiverDefaultAllowedHosts are the bilibili image CDNs, used when archiver.allowed-hosts is not configured
+var archiverDefaultAllowedHosts = []string{"hdslb.com", "biliimg.com"}
+
+// archiverBlockedNetworks are the private and shared address ranges not covered by the net.IP predicates
+var archiverBlockedNetworks = parseCidrs("0.0.0.0/8", "10.0.0.0/8", "100.64.0.0/10", "172.16.0.0/12", "192.168.0.0/16", "198.18.0.0/15", "fc00::/7")
+
+var archiverStatus = new(vo.ArchiverStatusVo)
+var archiverLock sync.Mutex
+
+// archiverScanWatermark is the max last_modified of the scanned memory items, the first run scans everything.
+// It is stored under archiverScanWatermarkKey, so a restart does not scan everything again
+var archiverScanWatermark int64
+var archiverScanWatermarkLoaded bool
+
+const archiverScanWatermarkKey = "archiver.scan-watermark"
+
+func NewArchiverService(sources []MemorySource, mediaDatabase database.MediaDatabase, stateDatabase database.StateDatabase) ArchiverService {
+	configs := config.GetConfigs()
+	interval := configs.GetDuration("archiver.interval")
+	if interval <= 0 {
+		interval = 30 * time.Minute
+	}
+	maxSize := configs.GetInt64("archiver.max-size")
+	if maxSize <= 0 {
+		maxSize = 50 * 1024 * 1024
+	}
+	maxAttempts := configs.GetInt("archiver.max-attempts")
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	batchSize := configs.GetInt64("archiver.batch-size")
+	if batchSize <= 0 {
+		batchSize = 200
+	}
+	userAgent := configs.GetString("archiver.user-agent")
+	if userAgent == "" {
+		userAgent = "Mozilla/5.0 (compatible; mihiru-go)"
+	}
+	allowedHosts := configs.GetStringSlice("archiver.allowed-hosts")
+	if len(allowedHosts) == 0 {
+		allowedHosts = archiverDefaultAllowedHosts
+	}
+	upstream := strings.TrimSuffix(configs.GetString("archiver.upstream"), "/")
+	httpClient := &http.Client{Timeout: 2 * time.Minute}
+	if upstream == "" {
+		httpClient = newArchiverHttpClient(allowedHosts)
+	}
+	return archiverService{
+		sources:       sources,
+		mediaDatabase: mediaDatabase,
+		stateDatabase: stateDatabase,
+		httpClient:    httpClient,
+		enabled:       configs.GetBool("archiver.enabled"),
+		interval:      interval,
+		baseFolder:    configs.GetString("archiver.base-folder"),
+		basePath:      configs.GetString("archiver.base-path"),
+		upstream:      upstream,
+		allowedHosts:  allowedHosts,
+		referer:       configs.GetString("archiver.referer"),
+		userAgent:     userAgent,
+		maxSize:       maxSize,
+		maxAttempts:   maxAttempts,
+		batchSize:     batchSize,
+	}
+}
+
+func (a archiverService) Start() {
+	archiverLock.Lock()
+	archiverStatus.Enabled = a.enabled
+	archiverLock.Unlock()
+	if !a.enabled {
+		return
+	}
+	go func() {
+		for {
+			_, _ = a.Run()
+			archiverLock.Lock()
+			archiverStatus.NextRunTime = time.Now().Add(a.interval).UnixNano() / 1e6
+			archiverLock.Unlock()
+			time.Sleep(a.interval)
+		}
+	}()
+}
+
+func (a archiverService) Run() (*vo.ArchiverRunResultVo, error) {
+	if a.baseFolder == "" || a.basePath == "" {
+		return nil, vo.NewErrorWithHttpStatus("未配置归档文件夹与访问路径", http.StatusBadRequest)
+	}
+	archiverLock.Lock()
+	if archiverStatus.Running {
+		archiverLock.Unlock()
+		return nil, vo.NewErrorWithHttpStatus("归档任务正在执行中", http.StatusConflict)
+	}
+	result := new(vo.ArchiverRunResultVo)
+	archiverStatus.Running = true
+	archiverStatus.LastRunTime = time.Now().UnixNano() / 1e6
+	archiverStatus.CurrentRun = result
+	archiverLock.Unlock()
+
+	err := a.run(result)
+
+	archiverLock.Lock()
+	defer archiverLock.Unlock()
+	archiverStatus.Running = false
+	archiverStatus.CurrentRun = nil
+	archiverStatus.LastResult = result
+	archiverStatus.LastFinishTime = time.Now().UnixNano() / 1e6
+	if err != nil {
+		util.LogError(err)
+		archiverStatus.LastError = err.Error()
+		return result, vo.NewErrorWithHttpStatus("归档失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	archiverStatus.LastError = ""
+	if result.Downloaded > 0 || result.Failed > 0 {
+		log.Printf("[archiver] discovered %d, downloaded %d, deduplicated %d, failed %d", result.Discovered, result.Downloaded, result.Deduplicated, result.Failed)
+	}
+	return result, nil
+}
+
+func (a archiverService) Status() (*vo.ArchiverStatusVo, error) {
+	counts, err := a.mediaDatabase.CountMediaByStatus()
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("统计归档数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	archiverLock.Lock()
+	defer archiverLock.Unlock()
+	status := *archiverStatus
+	if status.CurrentRun != nil {
+		currentRun := *status.CurrentRun
+		status.CurrentRun = &currentRun
+	}
+	status.Counts = counts
+	return &status, nil
+}
+
+func (a archiverService) run(result *vo.ArchiverRunResultVo) error {
+	if err := a.scan(result); err != nil {
+		return err
+	}
+	medias, err := a.mediaDatabase.ListPendingMedia(a.maxAttempts, a.batchSize)
+	if err != nil {
+		return err
+	}
+	archived := false
+	for _, media := range medias {
+		archiverLock.Lock()
+		result.CurrentUrl = media.Url
+		archiverLock.Unlock()
+		deduplicated, err := a.archive(media)
+		archiverLock.Lock()
+		if err != nil {
+			result.Failed++
+		} else if deduplicated {
+			result.Deduplicated++
+		} else {
+			result.Downloaded++
+		}
+		archiverLock.Unlock()
+		if err != nil {
+			media.Status = models.MediaStatusFailed
+			media.LastError = err.Error()
+		} else {
+			archived = true
+		}
+		media.Attempts++
+		media.UpdateTime = time.Now().UnixNano() / 1e6
+		if err = a.mediaDatabase.UpdateMedia(media); err != nil {
+			return err
+		}
+	}
+	archiverLock.Lock()
+	result.CurrentUrl = ""
+	archiverLock.Unlock()
+	if archived {
+		// archived urls change the day responses
+		cleanDayCache()
+	}
+	return nil
+}
+
+// scan records the media urls of memory items modified since the last scan
+func (a archiverService) scan(result *vo.ArchiverRunResultVo) error {
+	archiverLock.Lock()
+	since, loaded := archiverScanWatermark, archiverScanWatermarkLoaded
+	archiverLock.Unlock()
+	if !loaded {
+		if _, err := a.stateDatabase.GetState(archiverScanWatermarkKey, &since); err != nil {
+			return err
+		}
+	}
+	watermark := since
+	urlSet := make(map[string]bool)
+	collect := func(mediaUrl *string) {
+		if parsed, err := url.Parse(*mediaUrl); err == nil && (parsed.Scheme == "http" || parsed.Scheme == "https") &&
+			isAllowedHost(parsed.Hostname(), a.allowedHosts) {
+			urlSet[*mediaUrl] = true
+		}
+	}
+	for _, source := range a.sources {
+		items, err := source.QueryByLastModified(since)
+		if err != nil {
+			return err
+		}
+		for _, item := range items {
+			source.VisitMediaUrls(item, collect)
+			if item.GetLastModified() > watermark {
+				watermark = item.GetLastModified()
+			}
+		}
+	}
+	var err error
+	var urls []string
+	for mediaUrl := range urlSet {
+		urls = append(urls, mediaUrl)
+		if len(urls) == archiverAddBatchSize {
+			if err = a.addPendingMedia(urls, result); err != nil {
+				return err
+			}
+			urls = nil
+		}
+	}
+	if err = a.addPendingMedia(urls, result); err != nil {
+		return err
+	}
+	if watermark != since || !loaded {
+		if err = a.stateDatabase.SetState(archiverScanWatermarkKey, watermark); err != nil {
+			return err
+		}
+	}
+	archiverLock.Lock()
+	archiverScanWatermark = watermark
+	archiverScanWatermarkLoaded = true
+	archiverLock.Unlock()
+	return nil
+}
+
+func (a archiverService) addPendingMedia(urls []string, result *vo.ArchiverRunResultVo) error {
+	added, err := a.mediaDatabase.AddPendingMedia(urls, time.Now().UnixNano()/1e6)
+	if err != nil {
+		return err
+	}
+	archiverLock.Lock()
+	result.Discovered += added
+	archiverLock.Unlock()
+	return nil
+}
+
+// archive downloads one media into baseFolder/xx/sha256.ext, returns true when the content was already archived
+func (a archiverService) archive(media *models.MediaWithObjectId) (bool, error) {
+	fetchUrl, err := a.fetchUrl(media.Url)
+	if err != nil {
+		return false, err
+	}
+	request, err := http.NewRequest(http.MethodGet, fetchUrl, nil)
+	if err != nil {
+		return false, err
+	}
+	request.Header.Set("User-Agent", a.userAgent)
+	if a.referer != "" {
+		request.Header.Set("Referer", a.referer)
+	}
+	response, err := a.httpClient.Do(request)
+	if err != nil {
+		return false, err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("download returned %d", response.StatusCode)
+	}
+	if err = createFolderIfNotExists(a.baseFolder); err != nil {
+		return false, err
+	}
+	tempFile, err := ioutil.TempFile(a.baseFolder, ".archiving-")
+	if err != nil {
+		return false, err
+	}
+	defer os.Remove(tempFile.Name())
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tempFile, hash), io.LimitReader(response.Body, a.maxSize+1))
+	closeErr := tempFile.Close()
+	if err != nil {
+		return false, err
+	}
+	if closeErr != nil {
+		return false, closeErr
+	}
+	if size > a.maxSize {
+		return false, errors.New("file exceeds archiver.max-size")
+	}
+	media.Hash = hex.EncodeToString(hash.Sum(nil))
+	media.Size = size
+	media.ContentType = response.Header.Get("Content-Type")
+	media.LastError = ""
+
+	existed, err := a.mediaDatabase.GetDoneMediaByHash(media.Hash)
+	if err != nil {
+		return false, err
+	}
+	if existed != nil {
+		media.LocalUrl = existed.LocalUrl
+		media.Status = models.MediaStatusDone
+		return true, nil
+	}
+	relativePath := media.Hash[:2] + "/" + media.Hash + mediaExtension(media.Url, media.ContentType)
+	if err = createFolderIfNotExists(path.Join(a.baseFolder, media.Hash[:2])); err != nil {
+		return false, err
+	}
+	if err = os.Rename(tempFile.Name(), path.Join(a.baseFolder, relativePath)); err != nil {
+		return false, err
+	}
+	media.LocalUrl = a.basePath + relativePath
+	media.Status = models.MediaStatusDone
+	return false, nil
+}
+
+// fetchUrl sends the request through archiver.upstream as {upstream}/{host}/{path} when it is configured,
+// only the hosts in archiver.allowed-hosts are fetched
+func (a archiverService) fetchUrl(mediaUrl string) (string, error) {
+	parsed, err := url.Parse(mediaUrl)
+	if err != nil {
+		return "", err
+	}
+	if parsed.Scheme != "http" && parsed.Scheme != "https" {
+		return "", errors.New("unsupported url scheme " + parsed.Scheme)
+	}
+	if !isAllowedHost(parsed.Hostname(), a.allowedHosts) {
+		return "", errors.New("host not in archiver.allowed-hosts: " + parsed.Hostname())
+	}
+	if a.upstream == "" {
+		return mediaUrl, nil
+	}
+	return a.upstream + "/" + parsed.Host + parsed.RequestURI(), nil
+}
+
+func mediaExtension(mediaUrl string, contentType string) string {
+	if parsed, err := url.Parse(mediaUrl); err == nil {
+		// for resized bilibili urls like xxx.jpg@100w.webp the last extension is the served format
+		ext := path.Ext(parsed.Path)
+		if len(ext) > 1 && len(ext) <= 5 {
+			return strings.ToLower(ext)
+		}
+	}
+	if mediaType, _, err := mime.ParseMediaType(contentType); err == nil {
+		if extensions, err := mime.ExtensionsByType(mediaType); err == nil && len(extensions) > 0 {
+			return extensions[0]
+		}
+	}
+	return ""
+}
+
+func visitDynamicMediaUrls(dynamic *models.Dynamic, visit func(mediaUrl *string)) {
+	if dynamic == nil {
+		return
+	}
+	for i := range dynamic.ImageUrls {
+		visit(&dynamic.ImageUrls[i])
+	}
+	if dynamic.Pictures != nil {
+		for i := range *dynamic.Pictures {
+			visit(&(*dynamic.Pictures)[i].ImgSrc)
+		}
+	}
+	visit(&dynamic.Pic)
+	visitUserProfileMediaUrls(dynamic.UserProfile, visit)
+	if dynamic.UserProfiles != nil {
+		for i := range *dynamic.UserProfiles {
+			visitUserProfileMediaUrls(&(*dynamic.UserProfiles)[i], visit)
+		}
+	}
+	visitDynamicMediaUrls(dynamic.Origin, visit)
+}
+
+func visitLiveMediaUrls(live *models.Live, visit func(mediaUrl *string)) {
+	visitUserProfileMediaUrls(live.UserProfile, visit)
+	if live.JoinUserProfiles != nil {
+		for i := range *live.JoinUserProfiles {
+			visitUserProfileMediaUrls(&(*live.JoinUserProfiles)[i], visit)
+		}
+	}
+}
+
+func visitUserProfileMediaUrls(userProfile *models.DynamicUserProfile, visit func(mediaUrl *string)) {
+	if userProfile != nil {
+		visit(&userProfile.Face)
+	}
+}
+
+// newArchiverHttpClient only follows redirects to the allowed hosts, and refuses to connect to private, loopback
+// and link-local addresses. The address is checked after the DNS lookup, so a public name can not point inside either
+func newArchiverHttpClient(allowedHosts []string) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: 30 * time.Second,
+		Control: func(network string, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIp(ip) {
+				return errors.New("refused to connect to non-public address " + host)
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{
+		Timeout:   2 * time.Minute,
+		Transport: transport,
+		CheckRedirect: func(request *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if !isAllowedHost(request.URL.Hostname(), allowedHosts) {
+				return errors.New("redirected to a host not in archiver.allowed-hosts: " + request.URL.Hostname())
+			}
+			return nil
+		},
+	}
+}
+
+// isAllowedHost matches the host itself and its subdomains
+func isAllowedHost(host string, allowedHosts []string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, allowedHost := range allowedHosts {
+		allowedHost = strings.ToLower(allowedHost)
+		if host == allowedHost || strings.HasSuffix(host, "."+allowedHost) {
+			return true
+		}
+	}
+	return false
+}
+
+func isPublicIp(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() ||
+		ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, network := range archiverBlockedNetworks {
+		if network.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseCidrs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
@@ -0,0 +1,67 @@
+package services
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsAllowedHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"hdslb.com", true},
+		{"i0.hdslb.com", true},
+		{"I1.HDSLB.COM.", true},
+		{"evilhdslb.com", false},
+		{"hdslb.com.evil.com", false},
+		{"169.254.169.254", false},
+		{"", false},
+	}
+	for _, test := range tests {
+		if got := isAllowedHost(test.host, archiverDefaultAllowedHosts); got != test.want {
+			t.Errorf("isAllowedHost(%q) = %v, want %v", test.host, got, test.want)
+		}
+	}
+}
+
+func TestIsPublicIp(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"1.1.1.1", true},
+		{"2606:4700:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.20.0.1", false},
+		{"192.168.1.1", false},
+		{"100.64.0.1", false},
+		{"169.254.169.254", false},
+		{"0.0.0.0", false},
+		{"fd00::1", false},
+		{"fe80::1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, test := range tests {
+		if got := isPublicIp(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("isPublicIp(%s) = %v, want %v", test.ip, got, test.want)
+		}
+	}
+}
+
+func TestArchiverHttpClientRefusesLoopback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("internal"))
+	}))
+	defer server.Close()
+	// even an allowed host must not reach a loopback address
+	response, err := newArchiverHttpClient([]string{"127.0.0.1"}).Get(server.URL)
+	if err == nil {
+		response.Body.Close()
+		t.Fatal("expected the connection to a loopback address to be refused")
+	}
+}
@@ -8,30 +8,32 @@ import (
 	"mihiru-go/util"
 	"mihiru-go/vo"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 	"unicode/utf8"
 )
 
 type ArticleService interface {
-	Add(articleDto *dto.ArticleDto) (*vo.ArticleVo, error)
-	Update(id int64, articleDto *dto.ArticleDto) (*vo.ArticleVo, error)
+	Add(operator *vo.Operator, articleDto *dto.ArticleDto) (*vo.ArticleVo, error)
+	Update(operator *vo.Operator, id int64, articleDto *dto.ArticleDto) (*vo.ArticleVo, error)
 	Get(id int64) (*vo.ArticleVo, error)
 	Search(articleSearchParams *models.ArticleSearchParams) (*vo.ArticlePageVo, error)
 	Tags() ([]string, error)
 }
 
 type articleService struct {
-	db database.ArticleDatabase
+	db           database.ArticleDatabase
+	auditService AuditService
 }
 
 var tagsCache []string
 
-func NewArticleService(db database.ArticleDatabase) ArticleService {
-	return articleService{db: db}
+func NewArticleService(db database.ArticleDatabase, auditService AuditService) ArticleService {
+	return articleService{db: db, auditService: auditService}
 }
 
-func (a articleService) Add(articleDto *dto.ArticleDto) (*vo.ArticleVo, error) {
+func (a articleService) Add(operator *vo.Operator, articleDto *dto.ArticleDto) (*vo.ArticleVo, error) {
 	article := new(models.Article)
 	article.Author = articleDto.Author
 	article.Title = articleDto.Title
@@ -69,10 +71,11 @@ func (a articleService) Add(articleDto *dto.ArticleDto) (*vo.ArticleVo, error) {
 	if tagsLen > 0 {
 		tagsCache = tagsCache[:0]
 	}
+	a.auditService.Record(operator, models.AuditActionAdd, models.AuditTargetArticle, strconv.FormatInt(article.ID, 10), nil, article)
 	return convertToArticleVo(article), nil
 }
 
-func (a articleService) Update(id int64, articleDto *dto.ArticleDto) (*vo.ArticleVo, error) {
+func (a articleService) Update(operator *vo.Operator, id int64, articleDto *dto.ArticleDto) (*vo.ArticleVo, error) {
 	article, err := a.db.GetArticle(id)
 	if err != nil {
 		util.LogError(err)
@@ -81,6 +84,7 @@ func (a articleService) Update(id int64, articleDto *dto.ArticleDto) (*vo.Articl
 	if article == nil {
 		return nil, vo.NewErrorWithHttpStatus("无效的文章ID", http.StatusNotFound)
 	}
+	before := article.Article
 	if articleDto.Author != "" {
 		article.Author = articleDto.Author
 	}
@@ -125,6 +129,7 @@ func (a articleService) Update(id int64, articleDto *dto.ArticleDto) (*vo.Articl
 	if tagsLen > 0 {
 		tagsCache = tagsCache[:0]
 	}
+	a.auditService.Record(operator, models.AuditActionUpdate, models.AuditTargetArticle, strconv.FormatInt(id, 10), before, article.Article)
 	return convertToArticleVo(&article.Article), nil
 }
 
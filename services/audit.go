@@ -0,0 +1,87 @@
+package services
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"log"
+	"mihiru-go/config"
+	"mihiru-go/database"
+	"mihiru-go/models"
+	"mihiru-go/util"
+	"mihiru-go/vo"
+	"net/http"
+	"time"
+)
+
+type AuditService interface {
+	Record(operator *vo.Operator, action string, targetType string, targetId string, before interface{}, after interface{})
+	Search(auditSearchParams *models.AuditSearchParams) (*vo.AuditLogPageVo, error)
+	StartCleaner()
+}
+
+type auditService struct {
+	db database.AuditDatabase
+}
+
+func NewAuditService(db database.AuditDatabase) AuditService {
+	return auditService{db: db}
+}
+
+func (a auditService) Record(operator *vo.Operator, action string, targetType string, targetId string, before interface{}, after interface{}) {
+	auditLog := new(models.AuditLog)
+	if operator != nil {
+		if operator.UserVo != nil {
+			auditLog.Actor.ID = operator.ID
+			auditLog.Actor.Name = operator.Name
+			auditLog.Actor.LoginName = operator.LoginName
+		}
+		auditLog.ClientIp = operator.ClientIp
+	}
+	auditLog.Action = action
+	auditLog.TargetType = targetType
+	auditLog.TargetId = targetId
+	auditLog.Timestamp = time.Now().UnixNano() / 1e6
+	auditLog.Before = before
+	auditLog.After = after
+	util.LogError(a.db.InsertAuditLog(auditLog))
+}
+
+func (a auditService) Search(auditSearchParams *models.AuditSearchParams) (*vo.AuditLogPageVo, error) {
+	if auditSearchParams.ActorId != "" {
+		if _, err := primitive.ObjectIDFromHex(auditSearchParams.ActorId); err != nil {
+			return nil, vo.NewErrorWithHttpStatus("无效的操作人ID", http.StatusBadRequest)
+		}
+	}
+	auditLogs, err := a.db.SearchAuditLog(auditSearchParams)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	pageVo := new(vo.AuditLogPageVo)
+	pageVo.PageResult = auditLogs.PageResult
+	pageVo.Data = []*models.AuditLogWithObjectId{}
+	for _, auditLog := range auditLogs.Data {
+		auditLog.Before = util.BsonToJsonValue(auditLog.Before)
+		auditLog.After = util.BsonToJsonValue(auditLog.After)
+		pageVo.Data = append(pageVo.Data, auditLog)
+	}
+	return pageVo, nil
+}
+
+func (a auditService) StartCleaner() {
+	retentionDays := config.GetConfigs().GetInt64("audit.retention-days")
+	if retentionDays <= 0 {
+		return
+	}
+	go func() {
+		for {
+			deadline := time.Now().AddDate(0, 0, -int(retentionDays)).UnixNano() / 1e6
+			count, err := a.db.DeleteAuditLogBefore(deadline)
+			if err != nil {
+				util.LogError(err)
+			} else if count > 0 {
+				log.Printf("[audit] removed %d expired audit logs", count)
+			}
+			time.Sleep(time.Hour)
+		}
+	}()
+}
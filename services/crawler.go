@@ -0,0 +1,207 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"mihiru-go/bilibili"
+	"mihiru-go/config"
+	"mihiru-go/database"
+	"mihiru-go/models"
+	"mihiru-go/util"
+	"mihiru-go/vo"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type CrawlerService interface {
+	Start()
+	Run() (*vo.CrawlerRunResultVo, error)
+	Status() *vo.CrawlerStatusVo
+}
+
+type crawlerService struct {
+	memoryService MemoryService
+	liveDatabase  database.LiveDatabase
+	client        *bilibili.Client
+	enabled       bool
+	uids          []int64
+	pages         int
+	interval      time.Duration
+	maxBackoff    time.Duration
+}
+
+var crawlerStatus = new(vo.CrawlerStatusVo)
+var crawlerLock sync.Mutex
+var crawlerOperator = &vo.Operator{UserVo: &vo.UserVo{}}
+
+func NewCrawlerService(memoryService MemoryService, liveDatabase database.LiveDatabase) CrawlerService {
+	configs := config.GetConfigs()
+	interval := configs.GetDuration("crawler.interval")
+	if interval <= 0 {
+		interval = 10 * time.Minute
+	}
+	maxBackoff := configs.GetDuration("crawler.max-backoff")
+	if maxBackoff < interval {
+		maxBackoff = 12 * interval
+	}
+	pages := configs.GetInt("crawler.pages")
+	if pages <= 0 {
+		pages = 1
+	}
+	var uids []int64
+	for _, uid := range configs.GetIntSlice("crawler.uids") {
+		uids = append(uids, int64(uid))
+	}
+	crawlerOperator.Name = "crawler"
+	crawlerOperator.LoginName = "crawler"
+	return crawlerService{
+		memoryService: memoryService,
+		liveDatabase:  liveDatabase,
+		client: bilibili.NewClient(
+			configs.GetString("crawler.dynamic-base-url"),
+			configs.GetString("crawler.live-base-url"),
+			configs.GetString("crawler.user-agent"),
+		),
+		enabled:    configs.GetBool("crawler.enabled"),
+		uids:       uids,
+		pages:      pages,
+		interval:   interval,
+		maxBackoff: maxBackoff,
+	}
+}
+
+func (c crawlerService) Start() {
+	crawlerLock.Lock()
+	crawlerStatus.Enabled = c.enabled
+	crawlerStatus.Uids = c.uids
+	crawlerLock.Unlock()
+	if !c.enabled || len(c.uids) == 0 {
+		return
+	}
+	go func() {
+		for {
+			_, _ = c.Run()
+			wait := c.interval
+			crawlerLock.Lock()
+			for i := 0; i < crawlerStatus.ConsecutiveFailures && wait < c.maxBackoff; i++ {
+				wait *= 2
+			}
+			if wait > c.maxBackoff {
+				wait = c.maxBackoff
+			}
+			crawlerStatus.NextRunTime = time.Now().Add(wait).UnixNano() / 1e6
+			crawlerLock.Unlock()
+			time.Sleep(wait)
+		}
+	}()
+}
+
+func (c crawlerService) Run() (*vo.CrawlerRunResultVo, error) {
+	crawlerLock.Lock()
+	if crawlerStatus.Running {
+		crawlerLock.Unlock()
+		return nil, vo.NewErrorWithHttpStatus("抓取任务正在执行中", http.StatusConflict)
+	}
+	crawlerStatus.Running = true
+	crawlerStatus.LastRunTime = time.Now().UnixNano() / 1e6
+	crawlerLock.Unlock()
+
+	result := new(vo.CrawlerRunResultVo)
+	var errorMessages []string
+	for _, uid := range c.uids {
+		if err := c.crawlUser(uid, result); err != nil {
+			util.LogError(err)
+			errorMessages = append(errorMessages, fmt.Sprintf("uid %d: %s", uid, err.Error()))
+		}
+	}
+
+	crawlerLock.Lock()
+	defer crawlerLock.Unlock()
+	crawlerStatus.Running = false
+	crawlerStatus.LastResult = result
+	if len(errorMessages) > 0 {
+		crawlerStatus.ConsecutiveFailures++
+		crawlerStatus.LastError = strings.Join(errorMessages, "; ")
+		return result, vo.NewErrorWithHttpStatus("抓取失败: "+crawlerStatus.LastError, http.StatusBadGateway)
+	}
+	crawlerStatus.ConsecutiveFailures = 0
+	crawlerStatus.LastError = ""
+	crawlerStatus.LastSuccessTime = time.Now().UnixNano() / 1e6
+	if result.Inserted > 0 || result.Updated > 0 || result.LivesAdded > 0 {
+		log.Printf("[crawler] inserted %d, updated %d dynamics, added %d lives", result.Inserted, result.Updated, result.LivesAdded)
+	}
+	return result, nil
+}
+
+func (c crawlerService) Status() *vo.CrawlerStatusVo {
+	crawlerLock.Lock()
+	defer crawlerLock.Unlock()
+	status := *crawlerStatus
+	return &status
+}
+
+func (c crawlerService) crawlUser(uid int64, result *vo.CrawlerRunResultVo) error {
+	var userProfile *models.DynamicUserProfile
+	offset := int64(0)
+	for page := 0; page < c.pages; page++ {
+		spaceHistory, err := c.client.SpaceHistory(uid, offset)
+		if err != nil {
+			return err
+		}
+		dynamics, err := bilibili.ParseDynamics(spaceHistory.Dynamics)
+		if err != nil {
+			return err
+		}
+		if len(dynamics) > 0 {
+			batchResult, err := c.memoryService.BatchUpsertDynamic(crawlerOperator, dynamics)
+			if err != nil {
+				return err
+			}
+			result.Inserted += batchResult.Inserted
+			result.Updated += batchResult.Updated
+			result.Unchanged += batchResult.Unchanged
+			if userProfile == nil {
+				userProfile = dynamics[0].UserProfile
+			}
+		}
+		if !spaceHistory.HasMore || spaceHistory.NextOffset == 0 {
+			break
+		}
+		offset = spaceHistory.NextOffset
+	}
+	return c.crawlLive(uid, userProfile, result)
+}
+
+func (c crawlerService) crawlLive(uid int64, userProfile *models.DynamicUserProfile, result *vo.CrawlerRunResultVo) error {
+	roomInfo, err := c.client.RoomInfoByUid(uid)
+	if err != nil {
+		return err
+	}
+	if roomInfo.LiveStatus != bilibili.LiveStatusLive || roomInfo.LiveTime == 0 {
+		return nil
+	}
+	existed, err := c.liveDatabase.GetLiveByUserAndTimestamp(uid, roomInfo.LiveTime)
+	if err != nil {
+		return err
+	}
+	if existed != nil {
+		return nil
+	}
+	live := new(models.Live)
+	live.Timestamp = roomInfo.LiveTime
+	live.Title = roomInfo.Title
+	if userProfile != nil && userProfile.Uid == uid {
+		profile := *userProfile
+		live.UserProfile = &profile
+	} else {
+		live.UserProfile = &models.DynamicUserProfile{Uid: uid}
+	}
+	if _, err = c.memoryService.AddLive(crawlerOperator, live); err != nil {
+		return errors.New("add live failed: " + err.Error())
+	}
+	result.LivesAdded++
+	return nil
+}
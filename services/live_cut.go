@@ -0,0 +1,197 @@
+package services
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"mihiru-go/models"
+	"mihiru-go/util"
+	"mihiru-go/vo"
+	"net/http"
+	"time"
+)
+
+// the methods below edit single cuts and dialogues of a live, version is the last_modified of the live
+// the client has seen, the edit is rejected when the live changed since then.
+// A nil position appends
+
+func (m memoryService) AddLiveCut(operator *vo.Operator, id primitive.ObjectID, version int64, position *int, cut *models.LiveCut) (*models.LiveWithObjectId, error) {
+	return m.editLive(operator, id, version, func(live *models.LiveWithObjectId) (func(lastModified int64) (bool, error), error) {
+		index, err := insertPosition(position, len(liveCuts(live)))
+		if err != nil {
+			return nil, err
+		}
+		return func(lastModified int64) (bool, error) {
+			return m.liveDatabase.InsertLiveCut(id, version, index, cut, lastModified)
+		}, nil
+	})
+}
+
+func (m memoryService) UpdateLiveCut(operator *vo.Operator, id primitive.ObjectID, version int64, cutIndex int, cut *models.LiveCut) (*models.LiveWithObjectId, error) {
+	return m.editLive(operator, id, version, func(live *models.LiveWithObjectId) (func(lastModified int64) (bool, error), error) {
+		if cutIndex < 0 || cutIndex >= len(liveCuts(live)) {
+			return nil, vo.NewErrorWithHttpStatus("切片不存在", http.StatusNotFound)
+		}
+		return func(lastModified int64) (bool, error) {
+			return m.liveDatabase.UpdateLiveCut(id, version, cutIndex, cut, lastModified)
+		}, nil
+	})
+}
+
+func (m memoryService) DeleteLiveCut(operator *vo.Operator, id primitive.ObjectID, version int64, cutIndex int) (*models.LiveWithObjectId, error) {
+	return m.editLive(operator, id, version, func(live *models.LiveWithObjectId) (func(lastModified int64) (bool, error), error) {
+		if cutIndex < 0 || cutIndex >= len(liveCuts(live)) {
+			return nil, vo.NewErrorWithHttpStatus("切片不存在", http.StatusNotFound)
+		}
+		return func(lastModified int64) (bool, error) {
+			return m.liveDatabase.DeleteLiveCut(id, version, cutIndex, lastModified)
+		}, nil
+	})
+}
+
+func (m memoryService) ReorderLiveCuts(operator *vo.Operator, id primitive.ObjectID, version int64, order []int) (*models.LiveWithObjectId, error) {
+	return m.editLive(operator, id, version, func(live *models.LiveWithObjectId) (func(lastModified int64) (bool, error), error) {
+		if !isPermutation(order, len(liveCuts(live))) {
+			return nil, vo.NewErrorWithHttpStatus("排序参数必须包含每个切片的序号各一次", http.StatusBadRequest)
+		}
+		return func(lastModified int64) (bool, error) {
+			return m.liveDatabase.ReorderLiveCuts(id, version, order, lastModified)
+		}, nil
+	})
+}
+
+func (m memoryService) AddLiveCutDialogue(operator *vo.Operator, id primitive.ObjectID, version int64, cutIndex int, position *int, dialogue *models.LiveCutDialogue) (*models.LiveWithObjectId, error) {
+	return m.editLive(operator, id, version, func(live *models.LiveWithObjectId) (func(lastModified int64) (bool, error), error) {
+		dialogues, err := cutDialogues(live, cutIndex)
+		if err != nil {
+			return nil, err
+		}
+		index, err := insertPosition(position, len(dialogues))
+		if err != nil {
+			return nil, err
+		}
+		return func(lastModified int64) (bool, error) {
+			return m.liveDatabase.InsertLiveCutDialogue(id, version, cutIndex, index, dialogue, lastModified)
+		}, nil
+	})
+}
+
+func (m memoryService) UpdateLiveCutDialogue(operator *vo.Operator, id primitive.ObjectID, version int64, cutIndex int, dialogueIndex int, dialogue *models.LiveCutDialogue) (*models.LiveWithObjectId, error) {
+	return m.editLive(operator, id, version, func(live *models.LiveWithObjectId) (func(lastModified int64) (bool, error), error) {
+		dialogues, err := cutDialogues(live, cutIndex)
+		if err != nil {
+			return nil, err
+		}
+		if dialogueIndex < 0 || dialogueIndex >= len(dialogues) {
+			return nil, vo.NewErrorWithHttpStatus("台词不存在", http.StatusNotFound)
+		}
+		return func(lastModified int64) (bool, error) {
+			return m.liveDatabase.UpdateLiveCutDialogue(id, version, cutIndex, dialogueIndex, dialogue, lastModified)
+		}, nil
+	})
+}
+
+func (m memoryService) DeleteLiveCutDialogue(operator *vo.Operator, id primitive.ObjectID, version int64, cutIndex int, dialogueIndex int) (*models.LiveWithObjectId, error) {
+	return m.editLive(operator, id, version, func(live *models.LiveWithObjectId) (func(lastModified int64) (bool, error), error) {
+		dialogues, err := cutDialogues(live, cutIndex)
+		if err != nil {
+			return nil, err
+		}
+		if dialogueIndex < 0 || dialogueIndex >= len(dialogues) {
+			return nil, vo.NewErrorWithHttpStatus("台词不存在", http.StatusNotFound)
+		}
+		return func(lastModified int64) (bool, error) {
+			return m.liveDatabase.DeleteLiveCutDialogue(id, version, cutIndex, dialogueIndex, lastModified)
+		}, nil
+	})
+}
+
+func (m memoryService) ReorderLiveCutDialogues(operator *vo.Operator, id primitive.ObjectID, version int64, cutIndex int, order []int) (*models.LiveWithObjectId, error) {
+	return m.editLive(operator, id, version, func(live *models.LiveWithObjectId) (func(lastModified int64) (bool, error), error) {
+		dialogues, err := cutDialogues(live, cutIndex)
+		if err != nil {
+			return nil, err
+		}
+		if !isPermutation(order, len(dialogues)) {
+			return nil, vo.NewErrorWithHttpStatus("排序参数必须包含每条台词的序号各一次", http.StatusBadRequest)
+		}
+		return func(lastModified int64) (bool, error) {
+			return m.liveDatabase.ReorderLiveCutDialogues(id, version, cutIndex, order, lastModified)
+		}, nil
+	})
+}
+
+// editLive checks the live and its version, prepare validates the edit against the live and returns the update,
+// which reports false when the live changed after it was read
+func (m memoryService) editLive(operator *vo.Operator, id primitive.ObjectID, version int64,
+	prepare func(live *models.LiveWithObjectId) (func(lastModified int64) (bool, error), error)) (*models.LiveWithObjectId, error) {
+	before, err := m.getLive(id)
+	if err != nil {
+		return nil, err
+	}
+	if before.Deleted {
+		return nil, vo.NewErrorWithHttpStatus("数据不存在", http.StatusNotFound)
+	}
+	if before.LastModified != version {
+		return nil, vo.NewErrorWithHttpStatus("数据已被修改, 请刷新后重试", http.StatusConflict)
+	}
+	update, err := prepare(before)
+	if err != nil {
+		return nil, err
+	}
+	updated, err := update(time.Now().UnixNano() / 1e6)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("更新数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if !updated {
+		return nil, vo.NewErrorWithHttpStatus("数据已被修改, 请刷新后重试", http.StatusConflict)
+	}
+	cleanCache(before.Timestamp)
+	after, err := m.getLive(id)
+	if err != nil {
+		return nil, err
+	}
+	m.auditService.Record(operator, models.AuditActionUpdate, models.AuditTargetLive, id.Hex(), before, after)
+	return after, nil
+}
+
+func liveCuts(live *models.LiveWithObjectId) []models.LiveCut {
+	if live.Cuts == nil {
+		return nil
+	}
+	return *live.Cuts
+}
+
+func cutDialogues(live *models.LiveWithObjectId, cutIndex int) ([]models.LiveCutDialogue, error) {
+	cuts := liveCuts(live)
+	if cutIndex < 0 || cutIndex >= len(cuts) {
+		return nil, vo.NewErrorWithHttpStatus("切片不存在", http.StatusNotFound)
+	}
+	if cuts[cutIndex].Dialogues == nil {
+		return nil, nil
+	}
+	return *cuts[cutIndex].Dialogues, nil
+}
+
+func insertPosition(position *int, length int) (int, error) {
+	if position == nil {
+		return length, nil
+	}
+	if *position < 0 || *position > length {
+		return 0, vo.NewErrorWithHttpStatus("无效的位置参数", http.StatusBadRequest)
+	}
+	return *position, nil
+}
+
+func isPermutation(order []int, length int) bool {
+	if len(order) != length {
+		return false
+	}
+	seen := make([]bool, length)
+	for _, index := range order {
+		if index < 0 || index >= length || seen[index] {
+			return false
+		}
+		seen[index] = true
+	}
+	return true
+}
@@ -1,66 +1,253 @@
 package services
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"hash/fnv"
+	"io"
+	"log"
+	"math"
+	"mihiru-go/config"
 	"mihiru-go/database"
 	"mihiru-go/models"
+	"mihiru-go/subtitle"
 	"mihiru-go/util"
 	"mihiru-go/vo"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
 type MemoryService interface {
-	AddDynamic(dynamic *models.Dynamic) (*models.DynamicWithObjectId, error)
-	UpdateDynamic(id primitive.ObjectID, dynamic *models.Dynamic) (*models.DynamicWithObjectId, error)
-	AddLive(live *models.Live) (*models.LiveWithObjectId, error)
-	UpdateLive(id primitive.ObjectID, live *models.Live) (*models.LiveWithObjectId, error)
-	Days() ([]*models.DayCount, int64, error)
-	Day(day string) ([]interface{}, int64, error)
+	AddDynamic(operator *vo.Operator, dynamic *models.Dynamic) (*models.DynamicWithObjectId, error)
+	UpdateDynamic(operator *vo.Operator, id primitive.ObjectID, dynamic *models.Dynamic) (*models.DynamicWithObjectId, error)
+	UpsertDynamic(operator *vo.Operator, dynamic *models.Dynamic) (*vo.DynamicUpsertVo, error)
+	BatchUpsertDynamic(operator *vo.Operator, dynamics []*models.Dynamic) (*vo.DynamicBatchResultVo, error)
+	AddLive(operator *vo.Operator, live *models.Live) (*models.LiveWithObjectId, error)
+	UpdateLive(operator *vo.Operator, id primitive.ObjectID, live *models.Live) (*models.LiveWithObjectId, error)
+	DeleteDynamic(operator *vo.Operator, id primitive.ObjectID) error
+	RestoreDynamic(operator *vo.Operator, id primitive.ObjectID) error
+	PurgeDynamic(operator *vo.Operator, id primitive.ObjectID) error
+	DeleteLive(operator *vo.Operator, id primitive.ObjectID) error
+	RestoreLive(operator *vo.Operator, id primitive.ObjectID) error
+	PurgeLive(operator *vo.Operator, id primitive.ObjectID) error
+	Location(timezone string) (*time.Location, error)
+	Days(loc *time.Location, from string, to string) ([]*models.DayCount, int64, error)
+	Months(loc *time.Location, from string, to string) ([]*models.PeriodCount, int64, error)
+	Years(loc *time.Location) ([]*models.PeriodCount, int64, error)
+	Heatmap(year string, loc *time.Location) (*vo.MemoryHeatmapVo, int64, error)
+	DayItems(day string, loc *time.Location, kinds []string, render string) ([]*vo.MemoryItemVo, int64, error)
+	Search(params *models.MemorySearchParams) (*vo.MemorySearchPageVo, error)
+	Timeline(params *models.MemoryTimelineParams) (*vo.MemoryTimelineVo, error)
+	CoStreamers(loc *time.Location) ([]*models.CoStreamer, int64, error)
+	CoStreamerLives(uid int64, pageParams *models.PageParams) (*models.LivePage, error)
+	Export(params *models.MemoryExportParams, w io.Writer) error
+	Import(operator *vo.Operator, r io.Reader, dryRun bool) (*vo.MemoryImportResultVo, error)
+	CutSubtitle(id primitive.ObjectID, cutIndex int, format string) ([]byte, string, error)
+	ImportCutSubtitle(operator *vo.Operator, id primitive.ObjectID, version int64, cutIndex int, format string, data []byte) (*models.LiveWithObjectId, error)
+	AddLiveCut(operator *vo.Operator, id primitive.ObjectID, version int64, position *int, cut *models.LiveCut) (*models.LiveWithObjectId, error)
+	UpdateLiveCut(operator *vo.Operator, id primitive.ObjectID, version int64, cutIndex int, cut *models.LiveCut) (*models.LiveWithObjectId, error)
+	DeleteLiveCut(operator *vo.Operator, id primitive.ObjectID, version int64, cutIndex int) (*models.LiveWithObjectId, error)
+	ReorderLiveCuts(operator *vo.Operator, id primitive.ObjectID, version int64, order []int) (*models.LiveWithObjectId, error)
+	AddLiveCutDialogue(operator *vo.Operator, id primitive.ObjectID, version int64, cutIndex int, position *int, dialogue *models.LiveCutDialogue) (*models.LiveWithObjectId, error)
+	UpdateLiveCutDialogue(operator *vo.Operator, id primitive.ObjectID, version int64, cutIndex int, dialogueIndex int, dialogue *models.LiveCutDialogue) (*models.LiveWithObjectId, error)
+	DeleteLiveCutDialogue(operator *vo.Operator, id primitive.ObjectID, version int64, cutIndex int, dialogueIndex int) (*models.LiveWithObjectId, error)
+	ReorderLiveCutDialogues(operator *vo.Operator, id primitive.ObjectID, version int64, cutIndex int, order []int) (*models.LiveWithObjectId, error)
+	Day(day string, loc *time.Location) ([]interface{}, int64, error)
+	OnThisDay(date string, loc *time.Location) (*vo.MemoryOnThisDayVo, string, error)
 }
 
+const (
+	periodFormatDay   = "%Y.%m.%d"
+	periodFormatMonth = "%Y.%m"
+	periodFormatYear  = "%Y"
+	periodLayoutDay   = "2006.01.02"
+	periodLayoutMonth = "2006.01"
+	periodLayoutYear  = "2006"
+	monthDayLayout    = "01.02"
+)
+
 type memoryService struct {
 	dynamicDatabase database.DynamicDatabase
 	liveDatabase    database.LiveDatabase
+	mediaDatabase   database.MediaDatabase
+	articleDatabase database.ArticleDatabase
+	auditService    AuditService
+	defaultLocation *time.Location
+	sources         []MemorySource
 }
 
-var dayCountsCache []*models.DayCount
-var dayCountsVersionCache int64
-var dayCacheMap = make(map[string][]interface{})
-var dayVersionCacheMap = make(map[string]int64)
+// period counts are keyed by format and timezone name, day caches by timezone name first, then by day,
+// co-streamers by timezone name
+var periodCountsCache = make(map[string][]*models.PeriodCount)
+var coStreamersCache = make(map[string][]*models.CoStreamer)
+var coStreamersVersionCache = make(map[string]int64)
+var dayCacheMap = make(map[string]map[string][]*vo.MemoryItemVo)
+var dayVersionCacheMap = make(map[string]map[string]int64)
+var dayCacheLocations = make(map[string]*time.Location)
+var memoryCacheGeneration int64
+var memoryCacheLock sync.RWMutex
+
+func NewMemoryService(dynamicDatabase database.DynamicDatabase, liveDatabase database.LiveDatabase, mediaDatabase database.MediaDatabase, articleDatabase database.ArticleDatabase, auditService AuditService, sources []MemorySource) MemoryService {
+	timezone := config.GetConfigs().GetString("memory.timezone")
+	if timezone == "" {
+		timezone = "Asia/Shanghai"
+	}
+	defaultLocation, err := loadMemoryLocation(timezone)
+	if err != nil {
+		log.Fatal("Invalid memory.timezone ", err.Error())
+	}
+	return memoryService{dynamicDatabase, liveDatabase, mediaDatabase, articleDatabase, auditService, defaultLocation, sources}
+}
 
-func NewMemoryService(dynamicDatabase database.DynamicDatabase, liveDatabase database.LiveDatabase) MemoryService {
-	return memoryService{dynamicDatabase, liveDatabase}
+func (m memoryService) Location(timezone string) (*time.Location, error) {
+	if timezone == "" {
+		return m.defaultLocation, nil
+	}
+	loc, err := loadMemoryLocation(timezone)
+	if err != nil {
+		return nil, vo.NewErrorWithHttpStatus("无效的时区参数", http.StatusBadRequest)
+	}
+	return loc, nil
 }
 
-func (m memoryService) AddDynamic(dynamic *models.Dynamic) (*models.DynamicWithObjectId, error) {
+func (m memoryService) AddDynamic(operator *vo.Operator, dynamic *models.Dynamic) (*models.DynamicWithObjectId, error) {
 	dynamicWithObjectId := new(models.DynamicWithObjectId)
 	dynamicWithObjectId.Dynamic = *dynamic
 	dynamicWithObjectId.LastModified = time.Now().UnixNano() / 1e6
 	err := m.dynamicDatabase.InsertDynamic(dynamicWithObjectId)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, vo.NewErrorWithHttpStatus("该动态已存在", http.StatusConflict)
+	}
 	if err != nil {
 		util.LogError(err)
 		return nil, vo.NewErrorWithHttpStatus("添加数据失败, 请稍后重试", http.StatusInternalServerError)
 	}
 	cleanCache(dynamic.Timestamp)
+	m.auditService.Record(operator, models.AuditActionAdd, models.AuditTargetDynamic, dynamicWithObjectId.ID.Hex(), nil, dynamicWithObjectId)
 	return dynamicWithObjectId, nil
 }
 
-func (m memoryService) UpdateDynamic(id primitive.ObjectID, dynamic *models.Dynamic) (*models.DynamicWithObjectId, error) {
+func (m memoryService) UpdateDynamic(operator *vo.Operator, id primitive.ObjectID, dynamic *models.Dynamic) (*models.DynamicWithObjectId, error) {
+	before, err := m.dynamicDatabase.GetDynamicById(id)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if before == nil {
+		return nil, vo.NewErrorWithHttpStatus("数据不存在", http.StatusNotFound)
+	}
 	dynamicWithObjectId := new(models.DynamicWithObjectId)
 	dynamicWithObjectId.ID = id
 	dynamicWithObjectId.Dynamic = *dynamic
 	dynamicWithObjectId.LastModified = time.Now().UnixNano() / 1e6
-	err := m.dynamicDatabase.UpdateDynamic(dynamicWithObjectId)
+	dynamicWithObjectId.SoftDeleteFields = before.SoftDeleteFields
+	err = m.dynamicDatabase.UpdateDynamic(dynamicWithObjectId)
+	if mongo.IsDuplicateKeyError(err) {
+		return nil, vo.NewErrorWithHttpStatus("该动态已存在", http.StatusConflict)
+	}
 	if err != nil {
 		util.LogError(err)
 		return nil, vo.NewErrorWithHttpStatus("更新数据失败, 请稍后重试", http.StatusInternalServerError)
 	}
+	cleanCache(before.Timestamp)
 	cleanCache(dynamic.Timestamp)
+	m.auditService.Record(operator, models.AuditActionUpdate, models.AuditTargetDynamic, id.Hex(), before, dynamicWithObjectId)
 	return dynamicWithObjectId, nil
 }
 
-func (m memoryService) AddLive(live *models.Live) (*models.LiveWithObjectId, error) {
+func (m memoryService) UpsertDynamic(operator *vo.Operator, dynamic *models.Dynamic) (*vo.DynamicUpsertVo, error) {
+	if dynamic.DynamicId <= 0 {
+		return nil, vo.NewErrorWithHttpStatus("缺少dynamic_id参数", http.StatusBadRequest)
+	}
+	result, dynamicWithObjectId, err := m.upsertDynamic(operator, dynamic)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("保存数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	return &vo.DynamicUpsertVo{Result: result, Dynamic: dynamicWithObjectId}, nil
+}
+
+func (m memoryService) BatchUpsertDynamic(operator *vo.Operator, dynamics []*models.Dynamic) (*vo.DynamicBatchResultVo, error) {
+	for i, dynamic := range dynamics {
+		if dynamic == nil || dynamic.DynamicId <= 0 {
+			return nil, vo.NewErrorWithHttpStatus(fmt.Sprintf("第%d条动态缺少dynamic_id参数", i+1), http.StatusBadRequest)
+		}
+	}
+	batchResult := new(vo.DynamicBatchResultVo)
+	for _, dynamic := range dynamics {
+		result, _, err := m.upsertDynamic(operator, dynamic)
+		if err != nil {
+			util.LogError(err)
+			return nil, vo.NewErrorWithHttpStatus(fmt.Sprintf("保存动态%d失败, 请稍后重试", dynamic.DynamicId), http.StatusInternalServerError)
+		}
+		switch result {
+		case models.UpsertResultInserted:
+			batchResult.Inserted++
+		case models.UpsertResultUpdated:
+			batchResult.Updated++
+		default:
+			batchResult.Unchanged++
+		}
+	}
+	return batchResult, nil
+}
+
+func (m memoryService) upsertDynamic(operator *vo.Operator, dynamic *models.Dynamic) (string, *models.DynamicWithObjectId, error) {
+	return m.tryUpsertDynamic(operator, dynamic, true)
+}
+
+// tryUpsertDynamic looks the dynamic up again once when a concurrent upsert inserted it first
+func (m memoryService) tryUpsertDynamic(operator *vo.Operator, dynamic *models.Dynamic, retry bool) (string, *models.DynamicWithObjectId, error) {
+	existed, err := m.dynamicDatabase.GetDynamicByDynamicId(dynamic.DynamicId)
+	if err != nil {
+		return "", nil, err
+	}
+	if existed == nil {
+		dynamicWithObjectId := new(models.DynamicWithObjectId)
+		dynamicWithObjectId.Dynamic = *dynamic
+		dynamicWithObjectId.LastModified = time.Now().UnixNano() / 1e6
+		err = m.dynamicDatabase.InsertDynamic(dynamicWithObjectId)
+		if mongo.IsDuplicateKeyError(err) && retry {
+			return m.tryUpsertDynamic(operator, dynamic, false)
+		}
+		if err != nil {
+			return "", nil, err
+		}
+		cleanCache(dynamic.Timestamp)
+		m.auditService.Record(operator, models.AuditActionAdd, models.AuditTargetDynamic, dynamicWithObjectId.ID.Hex(), nil, dynamicWithObjectId)
+		return models.UpsertResultInserted, dynamicWithObjectId, nil
+	}
+	changed, err := itemChanged(&existed.Dynamic, dynamic)
+	if err != nil {
+		return "", nil, err
+	}
+	if !changed {
+		return models.UpsertResultUnchanged, existed, nil
+	}
+	dynamicWithObjectId := new(models.DynamicWithObjectId)
+	dynamicWithObjectId.ID = existed.ID
+	dynamicWithObjectId.Dynamic = *dynamic
+	dynamicWithObjectId.LastModified = time.Now().UnixNano() / 1e6
+	dynamicWithObjectId.SoftDeleteFields = existed.SoftDeleteFields
+	err = m.dynamicDatabase.UpdateDynamic(dynamicWithObjectId)
+	if err != nil {
+		return "", nil, err
+	}
+	cleanCache(existed.Timestamp)
+	cleanCache(dynamic.Timestamp)
+	m.auditService.Record(operator, models.AuditActionUpdate, models.AuditTargetDynamic, existed.ID.Hex(), existed, dynamicWithObjectId)
+	return models.UpsertResultUpdated, dynamicWithObjectId, nil
+}
+
+func (m memoryService) AddLive(operator *vo.Operator, live *models.Live) (*models.LiveWithObjectId, error) {
 	liveWithObjectId := new(models.LiveWithObjectId)
 	liveWithObjectId.Live = *live
 	liveWithObjectId.LastModified = time.Now().UnixNano() / 1e6
@@ -70,152 +257,720 @@ func (m memoryService) AddLive(live *models.Live) (*models.LiveWithObjectId, err
 		return nil, vo.NewErrorWithHttpStatus("添加数据失败, 请稍后重试", http.StatusInternalServerError)
 	}
 	cleanCache(live.Timestamp)
+	m.auditService.Record(operator, models.AuditActionAdd, models.AuditTargetLive, liveWithObjectId.ID.Hex(), nil, liveWithObjectId)
 	return liveWithObjectId, nil
 }
 
-func (m memoryService) UpdateLive(id primitive.ObjectID, live *models.Live) (*models.LiveWithObjectId, error) {
+func (m memoryService) UpdateLive(operator *vo.Operator, id primitive.ObjectID, live *models.Live) (*models.LiveWithObjectId, error) {
+	before, err := m.liveDatabase.GetLiveById(id)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if before == nil {
+		return nil, vo.NewErrorWithHttpStatus("数据不存在", http.StatusNotFound)
+	}
 	liveWithObjectId := new(models.LiveWithObjectId)
 	liveWithObjectId.ID = id
 	liveWithObjectId.Live = *live
 	liveWithObjectId.LastModified = time.Now().UnixNano() / 1e6
-	err := m.liveDatabase.UpdateLive(liveWithObjectId)
+	liveWithObjectId.SoftDeleteFields = before.SoftDeleteFields
+	err = m.liveDatabase.UpdateLive(liveWithObjectId)
 	if err != nil {
 		util.LogError(err)
 		return nil, vo.NewErrorWithHttpStatus("更新数据失败, 请稍后重试", http.StatusInternalServerError)
 	}
+	cleanCache(before.Timestamp)
 	cleanCache(live.Timestamp)
+	m.auditService.Record(operator, models.AuditActionUpdate, models.AuditTargetLive, id.Hex(), before, liveWithObjectId)
 	return liveWithObjectId, nil
 }
 
-func (m memoryService) Days() ([]*models.DayCount, int64, error) {
-	if dayCountsCache == nil {
-		dynamicCount, err := m.dynamicDatabase.CountDynamicByDay()
+func (m memoryService) DeleteDynamic(operator *vo.Operator, id primitive.ObjectID) error {
+	before, err := m.getDynamic(id)
+	if err != nil {
+		return err
+	}
+	if before.Deleted {
+		return vo.NewErrorWithHttpStatus("数据已被删除", http.StatusConflict)
+	}
+	changed, err := m.dynamicDatabase.DeleteDynamic(id, time.Now().UnixNano()/1e6)
+	if err != nil {
+		util.LogError(err)
+		return vo.NewErrorWithHttpStatus("删除数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if !changed {
+		return vo.NewErrorWithHttpStatus("数据已被删除", http.StatusConflict)
+	}
+	cleanCache(before.Timestamp)
+	m.auditService.Record(operator, models.AuditActionDelete, models.AuditTargetDynamic, id.Hex(), before, nil)
+	return nil
+}
+
+func (m memoryService) RestoreDynamic(operator *vo.Operator, id primitive.ObjectID) error {
+	before, err := m.getDynamic(id)
+	if err != nil {
+		return err
+	}
+	if !before.Deleted {
+		return vo.NewErrorWithHttpStatus("数据未被删除", http.StatusConflict)
+	}
+	changed, err := m.dynamicDatabase.RestoreDynamic(id, time.Now().UnixNano()/1e6)
+	if err != nil {
+		util.LogError(err)
+		return vo.NewErrorWithHttpStatus("恢复数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if !changed {
+		return vo.NewErrorWithHttpStatus("数据未被删除", http.StatusConflict)
+	}
+	cleanCache(before.Timestamp)
+	m.auditService.Record(operator, models.AuditActionRestore, models.AuditTargetDynamic, id.Hex(), nil, before)
+	return nil
+}
+
+func (m memoryService) PurgeDynamic(operator *vo.Operator, id primitive.ObjectID) error {
+	before, err := m.getDynamic(id)
+	if err != nil {
+		return err
+	}
+	if !before.Deleted {
+		return vo.NewErrorWithHttpStatus("只能彻底删除已删除的数据", http.StatusConflict)
+	}
+	purged, err := m.dynamicDatabase.PurgeDynamic(id)
+	if err != nil {
+		util.LogError(err)
+		return vo.NewErrorWithHttpStatus("删除数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if !purged {
+		return vo.NewErrorWithHttpStatus("只能彻底删除已删除的数据", http.StatusConflict)
+	}
+	cleanCache(before.Timestamp)
+	m.auditService.Record(operator, models.AuditActionPurge, models.AuditTargetDynamic, id.Hex(), before, nil)
+	return nil
+}
+
+func (m memoryService) getDynamic(id primitive.ObjectID) (*models.DynamicWithObjectId, error) {
+	dynamic, err := m.dynamicDatabase.GetDynamicById(id)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if dynamic == nil {
+		return nil, vo.NewErrorWithHttpStatus("数据不存在", http.StatusNotFound)
+	}
+	return dynamic, nil
+}
+
+func (m memoryService) DeleteLive(operator *vo.Operator, id primitive.ObjectID) error {
+	before, err := m.getLive(id)
+	if err != nil {
+		return err
+	}
+	if before.Deleted {
+		return vo.NewErrorWithHttpStatus("数据已被删除", http.StatusConflict)
+	}
+	changed, err := m.liveDatabase.DeleteLive(id, time.Now().UnixNano()/1e6)
+	if err != nil {
+		util.LogError(err)
+		return vo.NewErrorWithHttpStatus("删除数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if !changed {
+		return vo.NewErrorWithHttpStatus("数据已被删除", http.StatusConflict)
+	}
+	cleanCache(before.Timestamp)
+	m.auditService.Record(operator, models.AuditActionDelete, models.AuditTargetLive, id.Hex(), before, nil)
+	return nil
+}
+
+func (m memoryService) RestoreLive(operator *vo.Operator, id primitive.ObjectID) error {
+	before, err := m.getLive(id)
+	if err != nil {
+		return err
+	}
+	if !before.Deleted {
+		return vo.NewErrorWithHttpStatus("数据未被删除", http.StatusConflict)
+	}
+	changed, err := m.liveDatabase.RestoreLive(id, time.Now().UnixNano()/1e6)
+	if err != nil {
+		util.LogError(err)
+		return vo.NewErrorWithHttpStatus("恢复数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if !changed {
+		return vo.NewErrorWithHttpStatus("数据未被删除", http.StatusConflict)
+	}
+	cleanCache(before.Timestamp)
+	m.auditService.Record(operator, models.AuditActionRestore, models.AuditTargetLive, id.Hex(), nil, before)
+	return nil
+}
+
+func (m memoryService) PurgeLive(operator *vo.Operator, id primitive.ObjectID) error {
+	before, err := m.getLive(id)
+	if err != nil {
+		return err
+	}
+	if !before.Deleted {
+		return vo.NewErrorWithHttpStatus("只能彻底删除已删除的数据", http.StatusConflict)
+	}
+	purged, err := m.liveDatabase.PurgeLive(id)
+	if err != nil {
+		util.LogError(err)
+		return vo.NewErrorWithHttpStatus("删除数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if !purged {
+		return vo.NewErrorWithHttpStatus("只能彻底删除已删除的数据", http.StatusConflict)
+	}
+	cleanCache(before.Timestamp)
+	m.auditService.Record(operator, models.AuditActionPurge, models.AuditTargetLive, id.Hex(), before, nil)
+	return nil
+}
+
+func (m memoryService) CutSubtitle(id primitive.ObjectID, cutIndex int, format string) ([]byte, string, error) {
+	live, err := m.getLive(id)
+	if err != nil {
+		return nil, "", err
+	}
+	if live.Deleted || live.Cuts == nil || cutIndex < 0 || cutIndex >= len(*live.Cuts) {
+		return nil, "", vo.NewErrorWithHttpStatus("切片不存在", http.StatusNotFound)
+	}
+	cut := (*live.Cuts)[cutIndex]
+	var dialogues []models.LiveCutDialogue
+	if cut.Dialogues != nil {
+		dialogues = *cut.Dialogues
+	}
+	data, err := subtitle.Render(format, cut.Title, dialogues)
+	if err == subtitle.ErrUnknownFormat {
+		return nil, "", vo.NewErrorWithHttpStatus("不支持的字幕格式", http.StatusBadRequest)
+	}
+	if err != nil {
+		util.LogError(err)
+		return nil, "", vo.NewErrorWithHttpStatus("生成字幕失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	fileName := fmt.Sprintf("%s-%d.%s", time.Unix(live.Timestamp, 0).In(m.defaultLocation).Format("20060102-1504"), cutIndex+1, format)
+	return data, fileName, nil
+}
+
+// ImportCutSubtitle replaces the dialogues of the cut, version is the last_modified of the live like the other edits,
+// so an import does not overwrite the dialogue edits made since the client loaded the live
+func (m memoryService) ImportCutSubtitle(operator *vo.Operator, id primitive.ObjectID, version int64, cutIndex int, format string, data []byte) (*models.LiveWithObjectId, error) {
+	return m.editLive(operator, id, version, func(live *models.LiveWithObjectId) (func(lastModified int64) (bool, error), error) {
+		existing, err := cutDialogues(live, cutIndex)
 		if err != nil {
-			util.LogError(err)
-			return nil, 0, vo.NewErrorWithHttpStatus("统计动态数据失败, 请稍后重试", http.StatusInternalServerError)
+			return nil, err
+		}
+		// only the speakers already on the cut are split off the srt lines
+		var speakers []string
+		for _, dialogue := range existing {
+			if dialogue.Speaker != "" {
+				speakers = append(speakers, dialogue.Speaker)
+			}
+		}
+		dialogues, err := subtitle.Parse(format, data, speakers)
+		if err == subtitle.ErrUnknownFormat {
+			return nil, vo.NewErrorWithHttpStatus("不支持的字幕格式", http.StatusBadRequest)
+		}
+		if err != nil {
+			return nil, vo.NewErrorWithHttpStatus("解析字幕失败: "+err.Error(), http.StatusBadRequest)
+		}
+		return func(lastModified int64) (bool, error) {
+			return m.liveDatabase.ReplaceLiveCutDialogues(id, version, cutIndex, dialogues, lastModified)
+		}, nil
+	})
+}
+
+func (m memoryService) getLive(id primitive.ObjectID) (*models.LiveWithObjectId, error) {
+	live, err := m.liveDatabase.GetLiveById(id)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if live == nil {
+		return nil, vo.NewErrorWithHttpStatus("数据不存在", http.StatusNotFound)
+	}
+	return live, nil
+}
+
+func (m memoryService) Days(loc *time.Location, from string, to string) ([]*models.DayCount, int64, error) {
+	if !validPeriods(periodLayoutDay, from, to) {
+		return nil, 0, vo.NewErrorWithHttpStatus("无效的日期参数", http.StatusBadRequest)
+	}
+	periodCounts, err := m.periodCounts(periodFormatDay, loc)
+	if err != nil {
+		return nil, 0, err
+	}
+	var data []*models.DayCount
+	periodCounts, version := filterPeriodCounts(periodCounts, from, to)
+	for _, periodCount := range periodCounts {
+		data = append(data, &models.DayCount{Day: periodCount.Period, PeriodCountFields: periodCount.PeriodCountFields})
+	}
+	return data, version, nil
+}
+
+func (m memoryService) Months(loc *time.Location, from string, to string) ([]*models.PeriodCount, int64, error) {
+	if !validPeriods(periodLayoutMonth, from, to) {
+		return nil, 0, vo.NewErrorWithHttpStatus("无效的月份参数", http.StatusBadRequest)
+	}
+	periodCounts, err := m.periodCounts(periodFormatMonth, loc)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, version := filterPeriodCounts(periodCounts, from, to)
+	return data, version, nil
+}
+
+func (m memoryService) Years(loc *time.Location) ([]*models.PeriodCount, int64, error) {
+	periodCounts, err := m.periodCounts(periodFormatYear, loc)
+	if err != nil {
+		return nil, 0, err
+	}
+	data, version := filterPeriodCounts(periodCounts, "", "")
+	return data, version, nil
+}
+
+func (m memoryService) Heatmap(year string, loc *time.Location) (*vo.MemoryHeatmapVo, int64, error) {
+	if _, err := time.Parse(periodLayoutYear, year); err != nil {
+		return nil, 0, vo.NewErrorWithHttpStatus("无效的年份参数", http.StatusBadRequest)
+	}
+	days, version, err := m.Days(loc, year+".01.01", year+".12.31")
+	if err != nil {
+		return nil, 0, err
+	}
+	heatmap := &vo.MemoryHeatmapVo{Year: year, Days: days}
+	if heatmap.Days == nil {
+		heatmap.Days = []*models.DayCount{}
+	}
+	for _, day := range days {
+		heatmap.Total += day.Count
+		if day.Count > heatmap.MaxCount {
+			heatmap.MaxCount = day.Count
 		}
-		liveCount, err := m.liveDatabase.CountLiveByDay()
+	}
+	return heatmap, version, nil
+}
+
+// periodCounts returns the counts of every period including the ones whose items are all deleted,
+// so that their version is still taken into account
+func (m memoryService) periodCounts(format string, loc *time.Location) ([]*models.PeriodCount, error) {
+	timezone := loc.String()
+	cacheKey := format + "|" + timezone
+	memoryCacheLock.RLock()
+	data, generation := periodCountsCache[cacheKey], memoryCacheGeneration
+	memoryCacheLock.RUnlock()
+	if data != nil {
+		return data, nil
+	}
+	periodMap := make(map[string]*models.PeriodCount)
+	for _, source := range m.sources {
+		kind := source.Kind()
+		typeCounts, err := source.CountByPeriod(format, timezone)
 		if err != nil {
 			util.LogError(err)
-			return nil, 0, vo.NewErrorWithHttpStatus("统计直播数据失败, 请稍后重试", http.StatusInternalServerError)
-		}
-		var data []*models.DayCount
-		dynamicIndex := 0
-		liveIndex := 0
-		maxVersion := int64(0)
-		dynamicLen := len(dynamicCount)
-		liveLen := len(liveCount)
-		for dynamicIndex < dynamicLen || liveIndex < liveLen {
-			if dynamicIndex >= dynamicLen {
-				data = append(data, liveCount[liveIndex])
-				if liveCount[liveIndex].Version > maxVersion {
-					maxVersion = liveCount[liveIndex].Version
-				}
-				liveIndex++
-			} else if liveIndex >= liveLen {
-				data = append(data, dynamicCount[dynamicIndex])
-				if dynamicCount[dynamicIndex].Version > maxVersion {
-					maxVersion = dynamicCount[dynamicIndex].Version
-				}
-				dynamicIndex++
-			} else if liveCount[liveIndex].Day == dynamicCount[dynamicIndex].Day {
-				mergeDayCount := new(models.DayCount)
-				mergeDayCount.Day = liveCount[liveIndex].Day
-				mergeDayCount.Count = liveCount[liveIndex].Count + dynamicCount[dynamicIndex].Count
-				if liveCount[liveIndex].Version > dynamicCount[dynamicIndex].Version {
-					mergeDayCount.Version = liveCount[liveIndex].Version
-				} else {
-					mergeDayCount.Version = dynamicCount[dynamicIndex].Version
-				}
-				data = append(data, mergeDayCount)
-				if mergeDayCount.Version > maxVersion {
-					maxVersion = mergeDayCount.Version
-				}
-				liveIndex++
-				dynamicIndex++
-			} else if liveCount[liveIndex].Day > dynamicCount[dynamicIndex].Day {
-				data = append(data, dynamicCount[dynamicIndex])
-				if dynamicCount[dynamicIndex].Version > maxVersion {
-					maxVersion = dynamicCount[dynamicIndex].Version
-				}
-				dynamicIndex++
-			} else {
-				data = append(data, liveCount[liveIndex])
-				if liveCount[liveIndex].Version > maxVersion {
-					maxVersion = liveCount[liveIndex].Version
+			return nil, vo.NewErrorWithHttpStatus("统计数据失败, 请稍后重试", http.StatusInternalServerError)
+		}
+		for _, typeCount := range typeCounts {
+			periodCount := periodMap[typeCount.Period]
+			if periodCount == nil {
+				periodCount = &models.PeriodCount{Period: typeCount.Period}
+				periodCount.DynamicTypes = make(map[string]int64)
+				periodCount.Kinds = make(map[string]int64)
+				periodMap[typeCount.Period] = periodCount
+				data = append(data, periodCount)
+			}
+			periodCount.Count += typeCount.Count
+			if typeCount.Count > 0 {
+				periodCount.Kinds[kind] += typeCount.Count
+			}
+			switch kind {
+			case models.MemoryKindDynamic:
+				periodCount.DynamicCount += typeCount.Count
+				if typeCount.Count > 0 {
+					periodCount.DynamicTypes[strconv.Itoa(int(typeCount.Type))] += typeCount.Count
 				}
-				liveIndex++
+			case models.MemoryKindLive:
+				periodCount.LiveCount += typeCount.Count
+			}
+			if typeCount.Version > periodCount.Version {
+				periodCount.Version = typeCount.Version
+			}
+		}
+	}
+	sort.Slice(data, func(i, j int) bool {
+		return data[i].Period < data[j].Period
+	})
+	if data == nil {
+		data = []*models.PeriodCount{}
+	}
+	memoryCacheLock.Lock()
+	if generation == memoryCacheGeneration {
+		periodCountsCache[cacheKey] = data
+	}
+	memoryCacheLock.Unlock()
+	return data, nil
+}
+
+// CoStreamers returns the users who joined the not deleted lives, the ones with the most lives first.
+// The version is the max last_modified of all lives including the deleted ones, like the version of Days,
+// so removing the last co-streamer of a live changes it as well
+func (m memoryService) CoStreamers(loc *time.Location) ([]*models.CoStreamer, int64, error) {
+	timezone := loc.String()
+	memoryCacheLock.RLock()
+	coStreamers, version, generation := coStreamersCache[timezone], coStreamersVersionCache[timezone], memoryCacheGeneration
+	memoryCacheLock.RUnlock()
+	if coStreamers == nil {
+		var err error
+		coStreamers, err = m.liveDatabase.CountLiveByJoinUser(timezone)
+		if err != nil {
+			util.LogError(err)
+			return nil, 0, vo.NewErrorWithHttpStatus("统计数据失败, 请稍后重试", http.StatusInternalServerError)
+		}
+		if coStreamers == nil {
+			coStreamers = []*models.CoStreamer{}
+		}
+		version, err = m.liveDatabase.MaxLiveLastModifiedByTimestamp(math.MinInt64, math.MaxInt64)
+		if err != nil {
+			util.LogError(err)
+			return nil, 0, vo.NewErrorWithHttpStatus("统计数据失败, 请稍后重试", http.StatusInternalServerError)
+		}
+		memoryCacheLock.Lock()
+		if generation == memoryCacheGeneration {
+			coStreamersCache[timezone] = coStreamers
+			coStreamersVersionCache[timezone] = version
+		}
+		memoryCacheLock.Unlock()
+	}
+	result := []*models.CoStreamer{}
+	for _, coStreamer := range coStreamers {
+		if coStreamer.Count == 0 {
+			continue
+		}
+		filtered := *coStreamer
+		filtered.Years = nil
+		for _, year := range coStreamer.Years {
+			if year.Count > 0 {
+				filtered.Years = append(filtered.Years, year)
 			}
 		}
-		dayCountsCache = data
-		dayCountsVersionCache = maxVersion
+		result = append(result, &filtered)
+	}
+	return result, version, nil
+}
+
+func (m memoryService) CoStreamerLives(uid int64, pageParams *models.PageParams) (*models.LivePage, error) {
+	livePage, err := m.liveDatabase.QueryLiveByJoinUser(uid, pageParams)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
 	}
-	return dayCountsCache, dayCountsVersionCache, nil
+	return livePage, nil
 }
 
-func (m memoryService) Day(day string) ([]interface{}, int64, error) {
-	result := dayCacheMap[day]
+// Day returns the dynamics and lives of a day, in the shape of the first version of the api
+func (m memoryService) Day(day string, loc *time.Location) ([]interface{}, int64, error) {
+	items, version, err := m.dayItems(day, loc)
+	if err != nil {
+		return nil, 0, err
+	}
+	result := []interface{}{}
+	for _, item := range items {
+		if item.Kind == models.MemoryKindDynamic || item.Kind == models.MemoryKindLive {
+			result = append(result, item.Payload)
+		}
+	}
+	return result, version, nil
+}
+
+// OnThisDay returns the items and articles on the month and day (formatted as 01.02) of every year, grouped by year.
+// The version combines the memory version with a digest of the articles, since articles have no modify time
+func (m memoryService) OnThisDay(date string, loc *time.Location) (*vo.MemoryOnThisDayVo, string, error) {
+	if len(date) != len(monthDayLayout) {
+		return nil, "", vo.NewErrorWithHttpStatus("无效的日期参数", http.StatusBadRequest)
+	}
+	if _, err := time.Parse(monthDayLayout, date); err != nil {
+		return nil, "", vo.NewErrorWithHttpStatus("无效的日期参数", http.StatusBadRequest)
+	}
+	periodCounts, err := m.periodCounts(periodFormatDay, loc)
+	if err != nil {
+		return nil, "", err
+	}
+	result := &vo.MemoryOnThisDayVo{Date: date, Years: []*vo.MemoryOnThisDayYearVo{}}
+	yearMap := make(map[string]*vo.MemoryOnThisDayYearVo)
+	yearVo := func(year string) *vo.MemoryOnThisDayYearVo {
+		if yearMap[year] == nil {
+			yearMap[year] = &vo.MemoryOnThisDayYearVo{
+				Year:     year,
+				Day:      year + "." + date,
+				Items:    []*vo.MemoryItemVo{},
+				Articles: []*vo.ArticleListVo{},
+			}
+			result.Years = append(result.Years, yearMap[year])
+		}
+		return yearMap[year]
+	}
+	var maxVersion int64
+	for _, periodCount := range periodCounts {
+		if !strings.HasSuffix(periodCount.Period, "."+date) {
+			continue
+		}
+		// days whose items are all deleted still change the version
+		if periodCount.Version > maxVersion {
+			maxVersion = periodCount.Version
+		}
+		if periodCount.Count == 0 {
+			continue
+		}
+		items, version, err := m.dayItems(periodCount.Period, loc)
+		if err != nil {
+			return nil, "", err
+		}
+		if version > maxVersion {
+			maxVersion = version
+		}
+		if len(items) > 0 {
+			year := yearVo(periodCount.Period[:len(periodLayoutYear)])
+			year.Items = items
+		}
+	}
+	articles, err := m.articleDatabase.QueryArticleByPublishDay(date, loc.String())
+	if err != nil {
+		util.LogError(err)
+		return nil, "", vo.NewErrorWithHttpStatus("查询文章数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	digest := fnv.New32a()
+	for _, article := range articles {
+		_, _ = fmt.Fprintf(digest, "%d:%d;", article.ID, article.Version)
+		year := yearVo(time.Unix(article.PublishTime/1e3, 0).In(loc).Format(periodLayoutYear))
+		year.Articles = append(year.Articles, convertToArticleListVo(article))
+	}
+	sort.Slice(result.Years, func(i, j int) bool {
+		return result.Years[i].Year < result.Years[j].Year
+	})
+	return result, fmt.Sprintf("%d.%08x", maxVersion, digest.Sum32()), nil
+}
+
+// dayItems merges the items of every memory source on the day, ordered by timestamp
+func (m memoryService) dayItems(day string, loc *time.Location) ([]*vo.MemoryItemVo, int64, error) {
+	timezone := loc.String()
+	memoryCacheLock.RLock()
+	result, version, generation := dayCacheMap[timezone][day], dayVersionCacheMap[timezone][day], memoryCacheGeneration
+	memoryCacheLock.RUnlock()
 	if result != nil {
-		return result, dayVersionCacheMap[day], nil
+		return result, version, nil
 	}
-	result = []interface{}{}
-	loc, _ := time.LoadLocation("Asia/Shanghai")
-	date, err := time.ParseInLocation("2006.01.02", day, loc)
+	date, err := time.ParseInLocation(periodLayoutDay, day, loc)
 	if err != nil {
 		return nil, 0, vo.NewErrorWithHttpStatus("无效的日期参数", http.StatusBadRequest)
 	}
 	startTimestamp := date.Unix()
 	date = date.AddDate(0, 0, 1)
 	endTimestamp := date.Unix()
-	dynamics, err := m.dynamicDatabase.QueryDynamicByTimestamp(startTimestamp, endTimestamp)
-	if err != nil {
-		util.LogError(err)
-		return nil, 0, vo.NewErrorWithHttpStatus("查询动态数据失败, 请稍后重试", http.StatusInternalServerError)
+	var maxVersion int64
+	sourceItems := make([][]models.MemoryItem, len(m.sources))
+	for i, source := range m.sources {
+		sourceItems[i], err = source.QueryByTimestamp(startTimestamp, endTimestamp)
+		if err != nil {
+			util.LogError(err)
+			return nil, 0, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+		}
+		// deleted items are not listed but still have to change the version
+		sourceVersion, err := source.MaxLastModifiedByTimestamp(startTimestamp, endTimestamp)
+		if err != nil {
+			util.LogError(err)
+			return nil, 0, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+		}
+		if sourceVersion > maxVersion {
+			maxVersion = sourceVersion
+		}
 	}
-	lives, err := m.liveDatabase.QueryLiveByTimestamp(startTimestamp, endTimestamp)
+	mediaVersion, err := m.useArchivedMedia(sourceItems)
 	if err != nil {
 		util.LogError(err)
-		return nil, 0, vo.NewErrorWithHttpStatus("查询直播数据失败, 请稍后重试", http.StatusInternalServerError)
+		return nil, 0, vo.NewErrorWithHttpStatus("查询归档数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if mediaVersion > maxVersion {
+		maxVersion = mediaVersion
+	}
+	result = []*vo.MemoryItemVo{}
+	earlier := func(a models.MemoryItem, b models.MemoryItem) bool {
+		return a.GetTimestamp() < b.GetTimestamp()
 	}
+	mergeMemoryItems(sourceItems, earlier, func(source int, item models.MemoryItem) bool {
+		result = append(result, newMemoryItemVo(m.sources[source].Kind(), item))
+		if item.GetLastModified() > maxVersion {
+			maxVersion = item.GetLastModified()
+		}
+		return true
+	})
+	memoryCacheLock.Lock()
+	if generation == memoryCacheGeneration {
+		if dayCacheMap[timezone] == nil {
+			dayCacheMap[timezone] = make(map[string][]*vo.MemoryItemVo)
+			dayVersionCacheMap[timezone] = make(map[string]int64)
+			dayCacheLocations[timezone] = loc
+		}
+		dayCacheMap[timezone][day] = result
+		dayVersionCacheMap[timezone][day] = maxVersion
+	}
+	memoryCacheLock.Unlock()
+	return result, maxVersion, nil
+}
+
+func newMemoryItemVo(kind string, item models.MemoryItem) *vo.MemoryItemVo {
+	return &vo.MemoryItemVo{
+		Kind:         kind,
+		Id:           item.GetId(),
+		Timestamp:    item.GetTimestamp(),
+		LastModified: item.GetLastModified(),
+		Payload:      item,
+	}
+}
+
+// filterPeriodCounts keeps the non-empty periods between from and to (both inclusive, empty for unbounded)
+// and returns the max version of every period in the range
+func filterPeriodCounts(periodCounts []*models.PeriodCount, from string, to string) ([]*models.PeriodCount, int64) {
+	var result []*models.PeriodCount
 	var maxVersion int64
-	dynamicIndex := 0
-	liveIndex := 0
-	dynamicLen := len(dynamics)
-	liveLen := len(lives)
-	for dynamicIndex < dynamicLen || liveIndex < liveLen {
-		if dynamicIndex >= dynamicLen {
-			result = append(result, lives[liveIndex])
-			if lives[liveIndex].LastModified > maxVersion {
-				maxVersion = lives[liveIndex].LastModified
-			}
-			liveIndex++
-		} else if liveIndex >= liveLen {
-			result = append(result, dynamics[dynamicIndex])
-			if dynamics[dynamicIndex].LastModified > maxVersion {
-				maxVersion = dynamics[dynamicIndex].LastModified
-			}
-			dynamicIndex++
-		} else if dynamics[dynamicIndex].Timestamp > lives[liveIndex].Timestamp {
-			result = append(result, lives[liveIndex])
-			if lives[liveIndex].LastModified > maxVersion {
-				maxVersion = lives[liveIndex].LastModified
-			}
-			liveIndex++
-		} else {
-			result = append(result, dynamics[dynamicIndex])
-			if dynamics[dynamicIndex].LastModified > maxVersion {
-				maxVersion = dynamics[dynamicIndex].LastModified
+	for _, periodCount := range periodCounts {
+		if (from != "" && periodCount.Period < from) || (to != "" && periodCount.Period > to) {
+			continue
+		}
+		if periodCount.Version > maxVersion {
+			maxVersion = periodCount.Version
+		}
+		if periodCount.Count > 0 {
+			result = append(result, periodCount)
+		}
+	}
+	return result, maxVersion
+}
+
+func validPeriods(layout string, periods ...string) bool {
+	for _, period := range periods {
+		if period == "" {
+			continue
+		}
+		if _, err := time.Parse(layout, period); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// itemChanged compares the stored fields of two dynamics or two lives
+func itemChanged(existed interface{}, item interface{}) (bool, error) {
+	existedBytes, err := bson.Marshal(existed)
+	if err != nil {
+		return false, err
+	}
+	itemBytes, err := bson.Marshal(item)
+	if err != nil {
+		return false, err
+	}
+	return !bytes.Equal(existedBytes, itemBytes), nil
+}
+
+// DayItems returns the items of every kind on the day, kinds filters the result when not empty.
+// The content of dynamics is rendered when render is not empty
+func (m memoryService) DayItems(day string, loc *time.Location, kinds []string, render string) ([]*vo.MemoryItemVo, int64, error) {
+	if err := validRenderFormat(render); err != nil {
+		return nil, 0, err
+	}
+	kindSet := make(map[string]bool)
+	for _, kind := range kinds {
+		if m.source(kind) == nil {
+			return nil, 0, vo.NewErrorWithHttpStatus("无效的类型参数", http.StatusBadRequest)
+		}
+		kindSet[kind] = true
+	}
+	items, version, err := m.dayItems(day, loc)
+	if err != nil {
+		return nil, 0, err
+	}
+	result := make([]*vo.MemoryItemVo, 0, len(items))
+	for _, item := range items {
+		if len(kindSet) == 0 || kindSet[item.Kind] {
+			result = append(result, item)
+		}
+	}
+	return renderMemoryItems(result, render), version, nil
+}
+
+func (m memoryService) source(kind string) MemorySource {
+	for _, source := range m.sources {
+		if source.Kind() == kind {
+			return source
+		}
+	}
+	return nil
+}
+
+// useArchivedMedia replaces media urls with their archived copies and returns the latest archive time,
+// sourceItems are the items of each source in m.sources
+func (m memoryService) useArchivedMedia(sourceItems [][]models.MemoryItem) (int64, error) {
+	var urls []string
+	collect := func(mediaUrl *string) {
+		if *mediaUrl != "" {
+			urls = append(urls, *mediaUrl)
+		}
+	}
+	for i, items := range sourceItems {
+		for _, item := range items {
+			m.sources[i].VisitMediaUrls(item, collect)
+		}
+	}
+	if len(urls) == 0 {
+		return 0, nil
+	}
+	medias, err := m.mediaDatabase.GetMediaByUrls(urls)
+	if err != nil {
+		return 0, err
+	}
+	var version int64
+	localUrls := make(map[string]string)
+	for _, media := range medias {
+		if media.Status == models.MediaStatusDone && media.LocalUrl != "" {
+			localUrls[media.Url] = media.LocalUrl
+			if media.UpdateTime > version {
+				version = media.UpdateTime
 			}
-			dynamicIndex++
 		}
 	}
-	dayCacheMap[day] = result
-	dayVersionCacheMap[day] = maxVersion
-	return result, maxVersion, nil
+	replace := func(mediaUrl *string) {
+		if localUrl, ok := localUrls[*mediaUrl]; ok {
+			*mediaUrl = localUrl
+		}
+	}
+	for i, items := range sourceItems {
+		for _, item := range items {
+			m.sources[i].VisitMediaUrls(item, replace)
+		}
+	}
+	return version, nil
+}
+
+func cleanDayCache() {
+	memoryCacheLock.Lock()
+	defer memoryCacheLock.Unlock()
+	memoryCacheGeneration++
+	dayCacheMap = make(map[string]map[string][]*vo.MemoryItemVo)
+	dayVersionCacheMap = make(map[string]map[string]int64)
 }
 
 func cleanCache(timestamp int64) {
-	dayCountsCache = nil
-	loc, _ := time.LoadLocation("Asia/Shanghai")
-	delete(dayCacheMap, time.Unix(timestamp, 0).In(loc).Format("2006.01.02"))
+	memoryCacheLock.Lock()
+	defer memoryCacheLock.Unlock()
+	memoryCacheGeneration++
+	periodCountsCache = make(map[string][]*models.PeriodCount)
+	coStreamersCache = make(map[string][]*models.CoStreamer)
+	coStreamersVersionCache = make(map[string]int64)
+	livesCalendarCache = nil
+	for timezone, days := range dayCacheMap {
+		delete(days, time.Unix(timestamp, 0).In(dayCacheLocations[timezone]).Format("2006.01.02"))
+	}
+}
+
+// loadMemoryLocation only accepts named IANA zones, since the name is also passed to MongoDB aggregations
+func loadMemoryLocation(timezone string) (*time.Location, error) {
+	if timezone == "Local" {
+		return nil, errors.New("unknown time zone Local")
+	}
+	return time.LoadLocation(timezone)
 }
@@ -0,0 +1,205 @@
+package services
+
+import (
+	"mihiru-go/database"
+	"mihiru-go/models"
+)
+
+// MemorySource is one kind of item on the memory timeline, the memory service merges all registered sources
+type MemorySource interface {
+	Kind() string
+	// CountByPeriod counts the items by period and type, see database.MongoDatabase.countMemoryByPeriod
+	CountByPeriod(format string, timezone string) ([]*models.PeriodTypeCount, error)
+	// QueryByTimestamp returns the not deleted items in [startTimestamp, endTimestamp) ordered by timestamp
+	QueryByTimestamp(startTimestamp int64, endTimestamp int64) ([]models.MemoryItem, error)
+	// MaxLastModifiedByTimestamp includes the deleted items, so that deleting changes the version
+	MaxLastModifiedByTimestamp(startTimestamp int64, endTimestamp int64) (int64, error)
+	QueryByLastModified(since int64) ([]models.MemoryItem, error)
+	// QueryByCursor returns at most limit not deleted items strictly before the timestamp, the latest first,
+	// or strictly after it when after is true, the earliest first
+	QueryByCursor(timestamp int64, after bool, limit int64) ([]models.MemoryItem, error)
+	VisitMediaUrls(item models.MemoryItem, visit func(mediaUrl *string))
+}
+
+// NewMemorySources returns the registered memory sources, in the order items with the same timestamp are listed
+func NewMemorySources(dynamicDatabase database.DynamicDatabase, liveDatabase database.LiveDatabase, milestoneDatabase database.MilestoneDatabase) []MemorySource {
+	return []MemorySource{
+		dynamicSource{dynamicDatabase},
+		liveSource{liveDatabase},
+		milestoneSource{milestoneDatabase},
+	}
+}
+
+type dynamicSource struct {
+	db database.DynamicDatabase
+}
+
+func (s dynamicSource) Kind() string {
+	return models.MemoryKindDynamic
+}
+
+func (s dynamicSource) CountByPeriod(format string, timezone string) ([]*models.PeriodTypeCount, error) {
+	return s.db.CountDynamicByPeriod(format, timezone)
+}
+
+func (s dynamicSource) QueryByTimestamp(startTimestamp int64, endTimestamp int64) ([]models.MemoryItem, error) {
+	dynamics, err := s.db.QueryDynamicByTimestamp(startTimestamp, endTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]models.MemoryItem, len(dynamics))
+	for i, dynamic := range dynamics {
+		items[i] = dynamic
+	}
+	return items, nil
+}
+
+func (s dynamicSource) MaxLastModifiedByTimestamp(startTimestamp int64, endTimestamp int64) (int64, error) {
+	return s.db.MaxDynamicLastModifiedByTimestamp(startTimestamp, endTimestamp)
+}
+
+func (s dynamicSource) QueryByLastModified(since int64) ([]models.MemoryItem, error) {
+	dynamics, err := s.db.QueryDynamicByLastModified(since)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]models.MemoryItem, len(dynamics))
+	for i, dynamic := range dynamics {
+		items[i] = dynamic
+	}
+	return items, nil
+}
+
+func (s dynamicSource) QueryByCursor(timestamp int64, after bool, limit int64) ([]models.MemoryItem, error) {
+	dynamics, err := s.db.QueryDynamicByCursor(timestamp, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]models.MemoryItem, len(dynamics))
+	for i, dynamic := range dynamics {
+		items[i] = dynamic
+	}
+	return items, nil
+}
+
+func (s dynamicSource) VisitMediaUrls(item models.MemoryItem, visit func(mediaUrl *string)) {
+	if dynamic, ok := item.(*models.DynamicWithObjectId); ok {
+		visitDynamicMediaUrls(&dynamic.Dynamic, visit)
+	}
+}
+
+type liveSource struct {
+	db database.LiveDatabase
+}
+
+func (s liveSource) Kind() string {
+	return models.MemoryKindLive
+}
+
+func (s liveSource) CountByPeriod(format string, timezone string) ([]*models.PeriodTypeCount, error) {
+	return s.db.CountLiveByPeriod(format, timezone)
+}
+
+func (s liveSource) QueryByTimestamp(startTimestamp int64, endTimestamp int64) ([]models.MemoryItem, error) {
+	lives, err := s.db.QueryLiveByTimestamp(startTimestamp, endTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]models.MemoryItem, len(lives))
+	for i, live := range lives {
+		items[i] = live
+	}
+	return items, nil
+}
+
+func (s liveSource) MaxLastModifiedByTimestamp(startTimestamp int64, endTimestamp int64) (int64, error) {
+	return s.db.MaxLiveLastModifiedByTimestamp(startTimestamp, endTimestamp)
+}
+
+func (s liveSource) QueryByLastModified(since int64) ([]models.MemoryItem, error) {
+	lives, err := s.db.QueryLiveByLastModified(since)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]models.MemoryItem, len(lives))
+	for i, live := range lives {
+		items[i] = live
+	}
+	return items, nil
+}
+
+func (s liveSource) QueryByCursor(timestamp int64, after bool, limit int64) ([]models.MemoryItem, error) {
+	lives, err := s.db.QueryLiveByCursor(timestamp, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]models.MemoryItem, len(lives))
+	for i, live := range lives {
+		items[i] = live
+	}
+	return items, nil
+}
+
+func (s liveSource) VisitMediaUrls(item models.MemoryItem, visit func(mediaUrl *string)) {
+	if live, ok := item.(*models.LiveWithObjectId); ok {
+		visitLiveMediaUrls(&live.Live, visit)
+	}
+}
+
+type milestoneSource struct {
+	db database.MilestoneDatabase
+}
+
+func (s milestoneSource) Kind() string {
+	return models.MemoryKindMilestone
+}
+
+func (s milestoneSource) CountByPeriod(format string, timezone string) ([]*models.PeriodTypeCount, error) {
+	return s.db.CountMilestoneByPeriod(format, timezone)
+}
+
+func (s milestoneSource) QueryByTimestamp(startTimestamp int64, endTimestamp int64) ([]models.MemoryItem, error) {
+	milestones, err := s.db.QueryMilestoneByTimestamp(startTimestamp, endTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]models.MemoryItem, len(milestones))
+	for i, milestone := range milestones {
+		items[i] = milestone
+	}
+	return items, nil
+}
+
+func (s milestoneSource) MaxLastModifiedByTimestamp(startTimestamp int64, endTimestamp int64) (int64, error) {
+	return s.db.MaxMilestoneLastModifiedByTimestamp(startTimestamp, endTimestamp)
+}
+
+func (s milestoneSource) QueryByLastModified(since int64) ([]models.MemoryItem, error) {
+	milestones, err := s.db.QueryMilestoneByLastModified(since)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]models.MemoryItem, len(milestones))
+	for i, milestone := range milestones {
+		items[i] = milestone
+	}
+	return items, nil
+}
+
+func (s milestoneSource) QueryByCursor(timestamp int64, after bool, limit int64) ([]models.MemoryItem, error) {
+	milestones, err := s.db.QueryMilestoneByCursor(timestamp, after, limit)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]models.MemoryItem, len(milestones))
+	for i, milestone := range milestones {
+		items[i] = milestone
+	}
+	return items, nil
+}
+
+func (s milestoneSource) VisitMediaUrls(item models.MemoryItem, visit func(mediaUrl *string)) {
+	if milestone, ok := item.(*models.MilestoneWithObjectId); ok {
+		visit(&milestone.Image)
+	}
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"mihiru-go/database"
+	"mihiru-go/models"
+	"mihiru-go/util"
+	"mihiru-go/vo"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type MilestoneService interface {
+	Add(operator *vo.Operator, milestone *models.Milestone) (*models.MilestoneWithObjectId, error)
+	Update(operator *vo.Operator, id primitive.ObjectID, milestone *models.Milestone) (*models.MilestoneWithObjectId, error)
+	Delete(operator *vo.Operator, id primitive.ObjectID) error
+	Get(id primitive.ObjectID) (*models.MilestoneWithObjectId, error)
+	List() ([]*models.MilestoneWithObjectId, error)
+}
+
+type milestoneService struct {
+	db           database.MilestoneDatabase
+	auditService AuditService
+}
+
+func NewMilestoneService(db database.MilestoneDatabase, auditService AuditService) MilestoneService {
+	return milestoneService{db: db, auditService: auditService}
+}
+
+func (m milestoneService) Add(operator *vo.Operator, milestone *models.Milestone) (*models.MilestoneWithObjectId, error) {
+	if err := checkMilestone(milestone); err != nil {
+		return nil, err
+	}
+	milestoneWithObjectId := new(models.MilestoneWithObjectId)
+	milestoneWithObjectId.Milestone = *milestone
+	milestoneWithObjectId.LastModified = time.Now().UnixNano() / 1e6
+	err := m.db.InsertMilestone(milestoneWithObjectId)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("添加数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	cleanCache(milestone.Timestamp)
+	m.auditService.Record(operator, models.AuditActionAdd, models.AuditTargetMilestone, milestoneWithObjectId.ID.Hex(), nil, milestoneWithObjectId)
+	return milestoneWithObjectId, nil
+}
+
+func (m milestoneService) Update(operator *vo.Operator, id primitive.ObjectID, milestone *models.Milestone) (*models.MilestoneWithObjectId, error) {
+	if err := checkMilestone(milestone); err != nil {
+		return nil, err
+	}
+	before, err := m.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	milestoneWithObjectId := new(models.MilestoneWithObjectId)
+	milestoneWithObjectId.ID = id
+	milestoneWithObjectId.Milestone = *milestone
+	milestoneWithObjectId.LastModified = time.Now().UnixNano() / 1e6
+	err = m.db.UpdateMilestone(milestoneWithObjectId)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("更新数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	cleanCache(before.Timestamp)
+	cleanCache(milestone.Timestamp)
+	m.auditService.Record(operator, models.AuditActionUpdate, models.AuditTargetMilestone, id.Hex(), before, milestoneWithObjectId)
+	return milestoneWithObjectId, nil
+}
+
+func (m milestoneService) Delete(operator *vo.Operator, id primitive.ObjectID) error {
+	before, err := m.Get(id)
+	if err != nil {
+		return err
+	}
+	deleted, err := m.db.DeleteMilestone(id, time.Now().UnixNano()/1e6)
+	if err != nil {
+		util.LogError(err)
+		return vo.NewErrorWithHttpStatus("删除数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if !deleted {
+		return vo.NewErrorWithHttpStatus("数据不存在", http.StatusNotFound)
+	}
+	cleanCache(before.Timestamp)
+	m.auditService.Record(operator, models.AuditActionDelete, models.AuditTargetMilestone, id.Hex(), before, nil)
+	return nil
+}
+
+// Get treats deleted milestones as not existing
+func (m milestoneService) Get(id primitive.ObjectID) (*models.MilestoneWithObjectId, error) {
+	milestone, err := m.db.GetMilestoneById(id)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if milestone == nil || milestone.Deleted {
+		return nil, vo.NewErrorWithHttpStatus("数据不存在", http.StatusNotFound)
+	}
+	return milestone, nil
+}
+
+func (m milestoneService) List() ([]*models.MilestoneWithObjectId, error) {
+	milestones, err := m.db.ListMilestone()
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if milestones == nil {
+		milestones = []*models.MilestoneWithObjectId{}
+	}
+	return milestones, nil
+}
+
+func checkMilestone(milestone *models.Milestone) error {
+	if strings.TrimSpace(milestone.Title) == "" {
+		return vo.NewErrorWithHttpStatus("缺少title参数", http.StatusBadRequest)
+	}
+	if milestone.Timestamp <= 0 {
+		return vo.NewErrorWithHttpStatus("缺少timestamp参数", http.StatusBadRequest)
+	}
+	return nil
+}
@@ -0,0 +1,423 @@
+package services
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"mihiru-go/config"
+	"mihiru-go/models"
+	"mihiru-go/util"
+	"mihiru-go/vo"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type OidcService interface {
+	Enabled() bool
+	AuthorizationUrl() (string, error)
+	Callback(code string, state string) (*models.OidcIdentity, error)
+}
+
+type oidcService struct {
+	issuer       string
+	clientId     string
+	clientSecret string
+	redirectUrl  string
+	scopes       []string
+	groupsClaim  string
+	httpClient   *http.Client
+}
+
+type oidcProviderMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+}
+
+type oidcJsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type oidcPendingLogin struct {
+	codeVerifier string
+	nonce        string
+	expireTime   time.Time
+}
+
+var oidcMetadataCache *oidcProviderMetadata
+var oidcKeysCache = make(map[string]crypto.PublicKey)
+var oidcPendingLogins = make(map[string]*oidcPendingLogin)
+var oidcLock sync.Mutex
+
+const oidcPendingLoginTimeout = 10 * time.Minute
+
+func NewOidcService() OidcService {
+	configs := config.GetConfigs()
+	scopes := configs.GetStringSlice("oidc.scopes")
+	if len(scopes) == 0 {
+		scopes = []string{"openid", "profile", "email"}
+	}
+	groupsClaim := configs.GetString("oidc.groups-claim")
+	if groupsClaim == "" {
+		groupsClaim = "groups"
+	}
+	return oidcService{
+		issuer:       strings.TrimSuffix(configs.GetString("oidc.issuer"), "/"),
+		clientId:     configs.GetString("oidc.client-id"),
+		clientSecret: configs.GetString("oidc.client-secret"),
+		redirectUrl:  configs.GetString("oidc.redirect-url"),
+		scopes:       scopes,
+		groupsClaim:  groupsClaim,
+		httpClient:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+func (o oidcService) Enabled() bool {
+	return o.issuer != "" && o.clientId != ""
+}
+
+func (o oidcService) AuthorizationUrl() (string, error) {
+	if !o.Enabled() {
+		return "", vo.NewErrorWithHttpStatus("未启用第三方登录", http.StatusNotFound)
+	}
+	metadata, err := o.metadata()
+	if err != nil {
+		util.LogError(err)
+		return "", vo.NewErrorWithHttpStatus("获取身份提供方信息失败, 请稍后重试", http.StatusBadGateway)
+	}
+	state, pendingLogin, err := newOidcPendingLogin()
+	if err != nil {
+		util.LogError(err)
+		return "", vo.NewErrorWithHttpStatus("生成登录请求失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	oidcLock.Lock()
+	for key, value := range oidcPendingLogins {
+		if time.Now().After(value.expireTime) {
+			delete(oidcPendingLogins, key)
+		}
+	}
+	oidcPendingLogins[state] = pendingLogin
+	oidcLock.Unlock()
+	challenge := sha256.Sum256([]byte(pendingLogin.codeVerifier))
+	query := url.Values{}
+	query.Set("response_type", "code")
+	query.Set("client_id", o.clientId)
+	query.Set("redirect_uri", o.redirectUrl)
+	query.Set("scope", strings.Join(o.scopes, " "))
+	query.Set("state", state)
+	query.Set("nonce", pendingLogin.nonce)
+	query.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	query.Set("code_challenge_method", "S256")
+	separator := "?"
+	if strings.Contains(metadata.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return metadata.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// newOidcPendingLogin returns a new state with its verifier and nonce, they guard against CSRF, code interception
+// and replay, so all of them come from crypto/rand
+func newOidcPendingLogin() (string, *oidcPendingLogin, error) {
+	state, err := secureRandString(32)
+	if err != nil {
+		return "", nil, err
+	}
+	codeVerifier, err := secureRandString(64)
+	if err != nil {
+		return "", nil, err
+	}
+	nonce, err := secureRandString(32)
+	if err != nil {
+		return "", nil, err
+	}
+	return state, &oidcPendingLogin{codeVerifier: codeVerifier, nonce: nonce, expireTime: time.Now().Add(oidcPendingLoginTimeout)}, nil
+}
+
+func (o oidcService) Callback(code string, state string) (*models.OidcIdentity, error) {
+	if !o.Enabled() {
+		return nil, vo.NewErrorWithHttpStatus("未启用第三方登录", http.StatusNotFound)
+	}
+	oidcLock.Lock()
+	pendingLogin := oidcPendingLogins[state]
+	delete(oidcPendingLogins, state)
+	oidcLock.Unlock()
+	if pendingLogin == nil || time.Now().After(pendingLogin.expireTime) {
+		return nil, vo.NewErrorWithHttpStatus("登录请求已失效, 请重新登录", http.StatusBadRequest)
+	}
+	if code == "" {
+		return nil, vo.NewErrorWithHttpStatus("缺少授权码", http.StatusBadRequest)
+	}
+	metadata, err := o.metadata()
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("获取身份提供方信息失败, 请稍后重试", http.StatusBadGateway)
+	}
+	idToken, err := o.exchangeCode(metadata, code, pendingLogin.codeVerifier)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("获取身份令牌失败, 请重新登录", http.StatusBadGateway)
+	}
+	claims, err := o.verifyIdToken(metadata, idToken)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("身份令牌校验失败, 请重新登录", http.StatusForbidden)
+	}
+	if nonce, _ := claims["nonce"].(string); subtle.ConstantTimeCompare([]byte(nonce), []byte(pendingLogin.nonce)) != 1 {
+		return nil, vo.NewErrorWithHttpStatus("身份令牌校验失败, 请重新登录", http.StatusForbidden)
+	}
+	identity := new(models.OidcIdentity)
+	identity.Subject, _ = claims["sub"].(string)
+	if identity.Subject == "" {
+		return nil, vo.NewErrorWithHttpStatus("身份令牌缺少用户标识", http.StatusForbidden)
+	}
+	identity.Email, _ = claims["email"].(string)
+	identity.EmailVerified, _ = claims["email_verified"].(bool)
+	identity.Name, _ = claims["name"].(string)
+	identity.PreferredUsername, _ = claims["preferred_username"].(string)
+	switch groups := claims[o.groupsClaim].(type) {
+	case string:
+		identity.Groups = []string{groups}
+	case []interface{}:
+		for _, group := range groups {
+			if groupString, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, groupString)
+			}
+		}
+	}
+	return identity, nil
+}
+
+func (o oidcService) metadata() (*oidcProviderMetadata, error) {
+	oidcLock.Lock()
+	metadata := oidcMetadataCache
+	oidcLock.Unlock()
+	if metadata != nil {
+		return metadata, nil
+	}
+	metadata = new(oidcProviderMetadata)
+	if err := o.getJson(o.issuer+"/.well-known/openid-configuration", metadata); err != nil {
+		return nil, err
+	}
+	if strings.TrimSuffix(metadata.Issuer, "/") != o.issuer {
+		return nil, fmt.Errorf("issuer mismatch: expected %s, got %s", o.issuer, metadata.Issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JwksUri == "" {
+		return nil, errors.New("incomplete openid provider metadata")
+	}
+	oidcLock.Lock()
+	oidcMetadataCache = metadata
+	oidcLock.Unlock()
+	return metadata, nil
+}
+
+func (o oidcService) exchangeCode(metadata *oidcProviderMetadata, code string, codeVerifier string) (string, error) {
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", o.redirectUrl)
+	form.Set("client_id", o.clientId)
+	form.Set("code_verifier", codeVerifier)
+	request, err := http.NewRequest(http.MethodPost, metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	request.Header.Set("Accept", "application/json")
+	if o.clientSecret != "" {
+		request.SetBasicAuth(url.QueryEscape(o.clientId), url.QueryEscape(o.clientSecret))
+	}
+	response, err := o.httpClient.Do(request)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	var tokenResponse struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err = json.NewDecoder(response.Body).Decode(&tokenResponse); err != nil {
+		return "", err
+	}
+	if response.StatusCode != http.StatusOK || tokenResponse.Error != "" {
+		return "", fmt.Errorf("token endpoint returned %d: %s %s", response.StatusCode, tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+	if tokenResponse.IdToken == "" {
+		return "", errors.New("token response contains no id_token")
+	}
+	return tokenResponse.IdToken, nil
+}
+
+func (o oidcService) verifyIdToken(metadata *oidcProviderMetadata, idToken string) (map[string]interface{}, error) {
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed id_token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJwtSegment(parts[0], &header); err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	key, err := o.publicKey(metadata, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err = verifyJwtSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), signature); err != nil {
+		return nil, err
+	}
+	var claims map[string]interface{}
+	if err = decodeJwtSegment(parts[1], &claims); err != nil {
+		return nil, err
+	}
+	if issuer, _ := claims["iss"].(string); strings.TrimSuffix(issuer, "/") != o.issuer {
+		return nil, fmt.Errorf("unexpected issuer %s", issuer)
+	}
+	audienceMatched := false
+	switch audience := claims["aud"].(type) {
+	case string:
+		audienceMatched = audience == o.clientId
+	case []interface{}:
+		for _, a := range audience {
+			if a == o.clientId {
+				audienceMatched = true
+			}
+		}
+	}
+	if !audienceMatched {
+		return nil, errors.New("id_token audience mismatch")
+	}
+	expireTime, _ := claims["exp"].(float64)
+	if time.Now().Unix() > int64(expireTime)+60 {
+		return nil, errors.New("id_token expired")
+	}
+	return claims, nil
+}
+
+func (o oidcService) publicKey(metadata *oidcProviderMetadata, kid string) (crypto.PublicKey, error) {
+	oidcLock.Lock()
+	key := oidcKeysCache[kid]
+	oidcLock.Unlock()
+	if key != nil {
+		return key, nil
+	}
+	var jwks struct {
+		Keys []oidcJsonWebKey `json:"keys"`
+	}
+	if err := o.getJson(metadata.JwksUri, &jwks); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, jwk := range jwks.Keys {
+		publicKey, err := jwk.publicKey()
+		if err != nil {
+			util.LogError(err)
+			continue
+		}
+		keys[jwk.Kid] = publicKey
+	}
+	oidcLock.Lock()
+	oidcKeysCache = keys
+	oidcLock.Unlock()
+	if keys[kid] == nil {
+		return nil, fmt.Errorf("no signing key found for kid %q", kid)
+	}
+	return keys[kid], nil
+}
+
+func (o oidcService) getJson(requestUrl string, target interface{}) error {
+	response, err := o.httpClient.Get(requestUrl)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s returned %d", requestUrl, response.StatusCode)
+	}
+	return json.NewDecoder(response.Body).Decode(target)
+}
+
+func (k oidcJsonWebKey) publicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}, nil
+	case "EC":
+		if k.Crv != "P-256" {
+			return nil, fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func verifyJwtSignature(alg string, key crypto.PublicKey, signed []byte, signature []byte) error {
+	switch alg {
+	case "RS256", "RS384", "RS512":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return errors.New("signing key is not an RSA key")
+		}
+		hash := map[string]crypto.Hash{"RS256": crypto.SHA256, "RS384": crypto.SHA384, "RS512": crypto.SHA512}[alg]
+		hasher := hash.New()
+		hasher.Write(signed)
+		return rsa.VerifyPKCS1v15(rsaKey, hash, hasher.Sum(nil), signature)
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return errors.New("invalid ES256 signature")
+		}
+		digest := sha256.Sum256(signed)
+		if !ecdsa.Verify(ecKey, digest[:], new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])) {
+			return errors.New("invalid ES256 signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported signing algorithm %s", alg)
+}
+
+func decodeJwtSegment(segment string, target interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, target)
+}
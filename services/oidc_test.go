@@ -0,0 +1,264 @@
+package services
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"mihiru-go/vo"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	mockClientId     = "mihiru"
+	mockClientSecret = "secret"
+	mockRedirectUrl  = "http://localhost/user/oidc/callback"
+	mockKid          = "mock-key"
+)
+
+// mockIssuer is a minimal OpenID provider: discovery, JWKS and a token endpoint that checks PKCE
+type mockIssuer struct {
+	server     *httptest.Server
+	signingKey *rsa.PrivateKey
+	lock       sync.Mutex
+	grants     map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    map[string]interface{}
+	signer    *rsa.PrivateKey
+}
+
+func newMockIssuer(t *testing.T) *mockIssuer {
+	signingKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer := &mockIssuer{signingKey: signingKey, grants: make(map[string]mockGrant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		writeMockJson(w, http.StatusOK, map[string]string{
+			"issuer":                 issuer.server.URL,
+			"authorization_endpoint": issuer.server.URL + "/authorize",
+			"token_endpoint":         issuer.server.URL + "/token",
+			"jwks_uri":               issuer.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		publicKey := issuer.signingKey.PublicKey
+		writeMockJson(w, http.StatusOK, map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": mockKid,
+			"n":   base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/token", issuer.token)
+	issuer.server = httptest.NewServer(mux)
+	t.Cleanup(issuer.server.Close)
+	return issuer
+}
+
+func (m *mockIssuer) token(w http.ResponseWriter, r *http.Request) {
+	clientId, clientSecret, ok := r.BasicAuth()
+	if !ok || clientId != mockClientId || clientSecret != mockClientSecret {
+		writeMockJson(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("grant_type") != "authorization_code" || r.PostForm.Get("redirect_uri") != mockRedirectUrl {
+		writeMockJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+		return
+	}
+	m.lock.Lock()
+	grant, ok := m.grants[r.PostForm.Get("code")]
+	delete(m.grants, r.PostForm.Get("code"))
+	m.lock.Unlock()
+	verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(verifier[:]) != grant.challenge {
+		writeMockJson(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	writeMockJson(w, http.StatusOK, map[string]string{"id_token": signMockJwt(grant.signer, grant.claims)})
+}
+
+// authorize plays the user consenting on the authorization page, the returned code is bound to the PKCE challenge
+func (m *mockIssuer) authorize(t *testing.T, authorizationUrl string, claims func(nonce string) map[string]interface{}, signer *rsa.PrivateKey) (string, string) {
+	parsed, err := url.Parse(authorizationUrl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := parsed.Query()
+	if query.Get("code_challenge_method") != "S256" || query.Get("client_id") != mockClientId {
+		t.Fatalf("unexpected authorization request %s", authorizationUrl)
+	}
+	if signer == nil {
+		signer = m.signingKey
+	}
+	code := "code-" + query.Get("state")
+	m.lock.Lock()
+	m.grants[code] = mockGrant{challenge: query.Get("code_challenge"), claims: claims(query.Get("nonce")), signer: signer}
+	m.lock.Unlock()
+	return code, query.Get("state")
+}
+
+func signMockJwt(key *rsa.PrivateKey, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": mockKid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeMockJson(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(data)
+}
+
+func newTestOidcService(issuer *mockIssuer) oidcService {
+	oidcLock.Lock()
+	oidcMetadataCache = nil
+	oidcKeysCache = make(map[string]crypto.PublicKey)
+	oidcPendingLogins = make(map[string]*oidcPendingLogin)
+	oidcLock.Unlock()
+	return oidcService{
+		issuer:       issuer.server.URL,
+		clientId:     mockClientId,
+		clientSecret: mockClientSecret,
+		redirectUrl:  mockRedirectUrl,
+		scopes:       []string{"openid", "email"},
+		groupsClaim:  "groups",
+		httpClient:   issuer.server.Client(),
+	}
+}
+
+func TestOidcCallback(t *testing.T) {
+	issuer := newMockIssuer(t)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	validClaims := func(nonce string) map[string]interface{} {
+		return map[string]interface{}{
+			"iss":            issuer.server.URL,
+			"aud":            mockClientId,
+			"sub":            "user-1",
+			"exp":            time.Now().Add(time.Hour).Unix(),
+			"nonce":          nonce,
+			"email":          "user@example.com",
+			"email_verified": true,
+			"name":           "User",
+			"groups":         []string{"mihiru-admins", "fans"},
+		}
+	}
+	with := func(key string, value interface{}) func(nonce string) map[string]interface{} {
+		return func(nonce string) map[string]interface{} {
+			claims := validClaims(nonce)
+			claims[key] = value
+			return claims
+		}
+	}
+	tests := []struct {
+		name       string
+		claims     func(nonce string) map[string]interface{}
+		signer     *rsa.PrivateKey
+		wantStatus int
+	}{
+		{name: "valid", claims: validClaims},
+		{name: "audience as array", claims: with("aud", []string{"other", mockClientId})},
+		{name: "nonce mismatch", claims: with("nonce", "replayed"), wantStatus: http.StatusForbidden},
+		{name: "wrong audience", claims: with("aud", "other"), wantStatus: http.StatusForbidden},
+		{name: "wrong issuer", claims: with("iss", "https://evil.example.com"), wantStatus: http.StatusForbidden},
+		{name: "expired", claims: with("exp", time.Now().Add(-time.Hour).Unix()), wantStatus: http.StatusForbidden},
+		{name: "missing subject", claims: with("sub", ""), wantStatus: http.StatusForbidden},
+		{name: "signed by another key", claims: validClaims, signer: otherKey, wantStatus: http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := newTestOidcService(issuer)
+			authorizationUrl, err := service.AuthorizationUrl()
+			if err != nil {
+				t.Fatal(err)
+			}
+			code, state := issuer.authorize(t, authorizationUrl, test.claims, test.signer)
+			identity, err := service.Callback(code, state)
+			if test.wantStatus != 0 {
+				assertHttpStatus(t, err, test.wantStatus)
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"mihiru-admins", "fans"}
+			if identity.Subject != "user-1" || identity.Email != "user@example.com" || !identity.EmailVerified || !reflect.DeepEqual(identity.Groups, want) {
+				t.Errorf("unexpected identity %+v", identity)
+			}
+		})
+	}
+}
+
+func TestOidcCallbackState(t *testing.T) {
+	issuer := newMockIssuer(t)
+	service := newTestOidcService(issuer)
+	authorizationUrl, err := service.AuthorizationUrl()
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := func(nonce string) map[string]interface{} {
+		return map[string]interface{}{"iss": issuer.server.URL, "aud": mockClientId, "sub": "user-1", "exp": time.Now().Add(time.Hour).Unix(), "nonce": nonce}
+	}
+	code, state := issuer.authorize(t, authorizationUrl, claims, nil)
+
+	_, err = service.Callback(code, "unknown-state")
+	assertHttpStatus(t, err, http.StatusBadRequest)
+	if _, err = service.Callback(code, state); err != nil {
+		t.Fatal(err)
+	}
+	// a state can only be used once
+	_, err = service.Callback(code, state)
+	assertHttpStatus(t, err, http.StatusBadRequest)
+}
+
+func TestOidcPendingLoginIsRandom(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		state, pendingLogin, err := newOidcPendingLogin()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(state) != 32 || len(pendingLogin.codeVerifier) != 64 || len(pendingLogin.nonce) != 32 {
+			t.Fatalf("unexpected lengths %d %d %d", len(state), len(pendingLogin.codeVerifier), len(pendingLogin.nonce))
+		}
+		for _, value := range []string{state, pendingLogin.codeVerifier, pendingLogin.nonce} {
+			if seen[value] {
+				t.Fatalf("repeated value %s", value)
+			}
+			seen[value] = true
+		}
+	}
+}
+
+func assertHttpStatus(t *testing.T, err error, status int) {
+	t.Helper()
+	errorWithStatus, ok := err.(vo.ErrorWithHttpStatus)
+	if !ok {
+		t.Fatalf("expected an error with status %d, got %v", status, err)
+	}
+	if errorWithStatus.HttpStatus() != status {
+		t.Errorf("expected status %d, got %d (%s)", status, errorWithStatus.HttpStatus(), errorWithStatus.Error())
+	}
+}
@@ -0,0 +1,54 @@
+package services
+
+import (
+	"mihiru-go/bilibili"
+	"mihiru-go/models"
+	"mihiru-go/vo"
+	"net/http"
+)
+
+func validRenderFormat(format string) error {
+	switch format {
+	case "", models.RenderFormatHtml, models.RenderFormatTokens:
+		return nil
+	}
+	return vo.NewErrorWithHttpStatus("无效的render参数", http.StatusBadRequest)
+}
+
+// renderMemoryItems renders the content of the dynamics in the format. The items may be cached,
+// so the rendered ones are copies and the raw fields of the payload are kept as they are
+func renderMemoryItems(items []*vo.MemoryItemVo, format string) []*vo.MemoryItemVo {
+	if format == "" {
+		return items
+	}
+	result := make([]*vo.MemoryItemVo, len(items))
+	for i, item := range items {
+		result[i] = item
+		dynamic, ok := item.Payload.(*models.DynamicWithObjectId)
+		if !ok {
+			continue
+		}
+		rendered := *item
+		rendered.Rendered = renderDynamicContent(&dynamic.Dynamic, format)
+		result[i] = &rendered
+	}
+	return result
+}
+
+func renderDynamicContent(dynamic *models.Dynamic, format string) *vo.RenderedContentVo {
+	var ctrls []models.DynamicCtrl
+	if dynamic.Ctrl != nil {
+		ctrls = *dynamic.Ctrl
+	}
+	tokens := bilibili.Tokenize(dynamic.Content, ctrls)
+	rendered := new(vo.RenderedContentVo)
+	if format == models.RenderFormatHtml {
+		rendered.Html = bilibili.RenderHtml(tokens)
+	} else {
+		rendered.Tokens = tokens
+	}
+	if dynamic.Origin != nil {
+		rendered.Origin = renderDynamicContent(dynamic.Origin, format)
+	}
+	return rendered
+}
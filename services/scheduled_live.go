@@ -0,0 +1,294 @@
+package services
+
+import (
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"hash/fnv"
+	"mihiru-go/bilibili"
+	"mihiru-go/config"
+	"mihiru-go/database"
+	"mihiru-go/ical"
+	"mihiru-go/models"
+	"mihiru-go/util"
+	"mihiru-go/vo"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+type ScheduledLiveService interface {
+	Add(operator *vo.Operator, scheduledLive *models.ScheduledLive) (*models.ScheduledLiveWithObjectId, error)
+	Update(operator *vo.Operator, id primitive.ObjectID, scheduledLive *models.ScheduledLive) (*models.ScheduledLiveWithObjectId, error)
+	Delete(operator *vo.Operator, id primitive.ObjectID) error
+	List(all bool) ([]*vo.ScheduledLiveVo, error)
+	Calendar() ([]byte, string, error)
+}
+
+type scheduledLiveService struct {
+	db              database.ScheduledLiveDatabase
+	liveDatabase    database.LiveDatabase
+	auditService    AuditService
+	defaultLocation *time.Location
+	calendarName    string
+	dayPageUrl      string
+	duration        time.Duration
+	matchWindow     int64
+}
+
+// livesCalendarCache is the rendered feed and its ETag, cleared by cleanCache and every scheduled live change
+var livesCalendarCache []byte
+var livesCalendarETag string
+
+func NewScheduledLiveService(db database.ScheduledLiveDatabase, liveDatabase database.LiveDatabase, memoryService MemoryService, auditService AuditService) ScheduledLiveService {
+	configs := config.GetConfigs()
+	defaultLocation, _ := memoryService.Location("")
+	calendarName := configs.GetString("calendar.name")
+	if calendarName == "" {
+		calendarName = "直播日历"
+	}
+	duration := configs.GetDuration("calendar.duration")
+	if duration <= 0 {
+		duration = 2 * time.Hour
+	}
+	matchWindow := configs.GetDuration("calendar.match-window")
+	if matchWindow <= 0 {
+		matchWindow = 6 * time.Hour
+	}
+	return scheduledLiveService{
+		db:              db,
+		liveDatabase:    liveDatabase,
+		auditService:    auditService,
+		defaultLocation: defaultLocation,
+		calendarName:    calendarName,
+		dayPageUrl:      configs.GetString("calendar.day-page-url"),
+		duration:        duration,
+		matchWindow:     int64(matchWindow / time.Second),
+	}
+}
+
+func (s scheduledLiveService) Add(operator *vo.Operator, scheduledLive *models.ScheduledLive) (*models.ScheduledLiveWithObjectId, error) {
+	if err := checkScheduledLive(scheduledLive); err != nil {
+		return nil, err
+	}
+	scheduledLiveWithObjectId := new(models.ScheduledLiveWithObjectId)
+	scheduledLiveWithObjectId.ScheduledLive = *scheduledLive
+	scheduledLiveWithObjectId.LastModified = time.Now().UnixNano() / 1e6
+	err := s.db.InsertScheduledLive(scheduledLiveWithObjectId)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("添加数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	cleanCalendarCache()
+	s.auditService.Record(operator, models.AuditActionAdd, models.AuditTargetScheduledLive, scheduledLiveWithObjectId.ID.Hex(), nil, scheduledLiveWithObjectId)
+	return scheduledLiveWithObjectId, nil
+}
+
+func (s scheduledLiveService) Update(operator *vo.Operator, id primitive.ObjectID, scheduledLive *models.ScheduledLive) (*models.ScheduledLiveWithObjectId, error) {
+	if err := checkScheduledLive(scheduledLive); err != nil {
+		return nil, err
+	}
+	before, err := s.get(id)
+	if err != nil {
+		return nil, err
+	}
+	scheduledLiveWithObjectId := new(models.ScheduledLiveWithObjectId)
+	scheduledLiveWithObjectId.ID = id
+	scheduledLiveWithObjectId.ScheduledLive = *scheduledLive
+	scheduledLiveWithObjectId.LastModified = time.Now().UnixNano() / 1e6
+	err = s.db.UpdateScheduledLive(scheduledLiveWithObjectId)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("更新数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	cleanCalendarCache()
+	s.auditService.Record(operator, models.AuditActionUpdate, models.AuditTargetScheduledLive, id.Hex(), before, scheduledLiveWithObjectId)
+	return scheduledLiveWithObjectId, nil
+}
+
+func (s scheduledLiveService) Delete(operator *vo.Operator, id primitive.ObjectID) error {
+	before, err := s.get(id)
+	if err != nil {
+		return err
+	}
+	err = s.db.DeleteScheduledLive(id)
+	if err != nil {
+		util.LogError(err)
+		return vo.NewErrorWithHttpStatus("删除数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	cleanCalendarCache()
+	s.auditService.Record(operator, models.AuditActionDelete, models.AuditTargetScheduledLive, id.Hex(), before, nil)
+	return nil
+}
+
+func (s scheduledLiveService) get(id primitive.ObjectID) (*models.ScheduledLiveWithObjectId, error) {
+	scheduledLive, err := s.db.GetScheduledLiveById(id)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if scheduledLive == nil {
+		return nil, vo.NewErrorWithHttpStatus("数据不存在", http.StatusNotFound)
+	}
+	return scheduledLive, nil
+}
+
+// List returns the scheduled lives not recorded yet and not older than the match window,
+// all includes the past and the recorded ones with the matched live
+func (s scheduledLiveService) List(all bool) ([]*vo.ScheduledLiveVo, error) {
+	var since int64
+	if !all {
+		since = time.Now().Unix() - s.matchWindow
+	}
+	scheduledLives, err := s.db.ListScheduledLive(since)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	data := make([]*vo.ScheduledLiveVo, 0, len(scheduledLives))
+	for _, scheduledLive := range scheduledLives {
+		lives, err := s.liveDatabase.QueryLiveByTimestamp(scheduledLive.Timestamp-s.matchWindow, scheduledLive.Timestamp+s.matchWindow+1)
+		if err != nil {
+			util.LogError(err)
+			return nil, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+		}
+		scheduledLiveVo := &vo.ScheduledLiveVo{ScheduledLiveWithObjectId: *scheduledLive}
+		if live := s.matchLive(scheduledLive, lives); live != nil {
+			if !all {
+				continue
+			}
+			scheduledLiveVo.LiveId = &live.ID
+		}
+		data = append(data, scheduledLiveVo)
+	}
+	return data, nil
+}
+
+// Calendar renders every live and the scheduled lives not recorded yet as an iCalendar feed, returns it with its ETag
+func (s scheduledLiveService) Calendar() ([]byte, string, error) {
+	memoryCacheLock.RLock()
+	data, etag, generation := livesCalendarCache, livesCalendarETag, memoryCacheGeneration
+	memoryCacheLock.RUnlock()
+	if data != nil {
+		return data, etag, nil
+	}
+	var lives []*models.LiveWithObjectId
+	err := s.liveDatabase.EachLive(0, 0, func(live *models.LiveWithObjectId) error {
+		lives = append(lives, live)
+		return nil
+	})
+	if err != nil {
+		util.LogError(err)
+		return nil, "", vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	scheduledLives, err := s.db.ListScheduledLive(0)
+	if err != nil {
+		util.LogError(err)
+		return nil, "", vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	calendar := &ical.Calendar{ProdId: "-//mihiru-go//lives//ZH", Name: s.calendarName, Refresh: time.Hour}
+	for _, live := range lives {
+		calendar.Events = append(calendar.Events, s.liveEvent(live))
+	}
+	for _, scheduledLive := range scheduledLives {
+		if s.matchLive(scheduledLive, lives) == nil {
+			calendar.Events = append(calendar.Events, s.scheduledLiveEvent(scheduledLive))
+		}
+	}
+	data = calendar.Bytes()
+	hash := fnv.New64a()
+	_, _ = hash.Write(data)
+	etag = fmt.Sprintf("%016x", hash.Sum64())
+	memoryCacheLock.Lock()
+	if generation == memoryCacheGeneration {
+		livesCalendarCache, livesCalendarETag = data, etag
+	}
+	memoryCacheLock.Unlock()
+	return data, etag, nil
+}
+
+// matchLive returns the live nearest to the scheduled time within the match window, by the same liver when both are known.
+// lives must be ordered by timestamp
+func (s scheduledLiveService) matchLive(scheduledLive *models.ScheduledLiveWithObjectId, lives []*models.LiveWithObjectId) *models.LiveWithObjectId {
+	var matched *models.LiveWithObjectId
+	var matchedDistance int64
+	for i := sort.Search(len(lives), func(i int) bool {
+		return lives[i].Timestamp >= scheduledLive.Timestamp-s.matchWindow
+	}); i < len(lives) && lives[i].Timestamp <= scheduledLive.Timestamp+s.matchWindow; i++ {
+		live := lives[i]
+		if scheduledLive.UserProfile != nil && live.UserProfile != nil && scheduledLive.UserProfile.Uid != live.UserProfile.Uid {
+			continue
+		}
+		distance := live.Timestamp - scheduledLive.Timestamp
+		if distance < 0 {
+			distance = -distance
+		}
+		if matched == nil || distance < matchedDistance {
+			matched, matchedDistance = live, distance
+		}
+	}
+	return matched
+}
+
+func (s scheduledLiveService) liveEvent(live *models.LiveWithObjectId) *ical.Event {
+	event := calendarEvent("live-"+live.ID.Hex(), live.LastModified, live.Timestamp, s.duration, live.Title, live.Content, live.UserProfile, live.JoinUserProfiles)
+	event.Status = ical.StatusConfirmed
+	if s.dayPageUrl != "" {
+		day := time.Unix(live.Timestamp, 0).In(s.defaultLocation).Format(periodLayoutDay)
+		event.Url = strings.ReplaceAll(s.dayPageUrl, "{day}", day)
+	}
+	return event
+}
+
+func (s scheduledLiveService) scheduledLiveEvent(scheduledLive *models.ScheduledLiveWithObjectId) *ical.Event {
+	event := calendarEvent("scheduled-live-"+scheduledLive.ID.Hex(), scheduledLive.LastModified, scheduledLive.Timestamp, s.duration,
+		scheduledLive.Title, scheduledLive.Content, scheduledLive.UserProfile, scheduledLive.JoinUserProfiles)
+	event.Status = ical.StatusTentative
+	return event
+}
+
+// calendarEvent lists the co-streamers as attendees and also in the description, since not every calendar app shows attendees
+func calendarEvent(uid string, lastModified int64, timestamp int64, duration time.Duration, title string, content string,
+	userProfile *models.DynamicUserProfile, joinUserProfiles *[]models.DynamicUserProfile) *ical.Event {
+	start := time.Unix(timestamp, 0)
+	event := &ical.Event{
+		Uid:         uid + "@mihiru-go",
+		Stamp:       time.Unix(0, lastModified*1e6),
+		Start:       start,
+		End:         start.Add(duration),
+		Summary:     title,
+		Description: content,
+	}
+	if userProfile != nil {
+		event.Organizer = &ical.Person{Name: userProfile.Uname, Uri: bilibili.SpaceUrl(userProfile.Uid)}
+	}
+	if joinUserProfiles != nil && len(*joinUserProfiles) > 0 {
+		names := make([]string, len(*joinUserProfiles))
+		for i, profile := range *joinUserProfiles {
+			names[i] = profile.Uname
+			event.Attendees = append(event.Attendees, ical.Person{Name: profile.Uname, Uri: bilibili.SpaceUrl(profile.Uid)})
+		}
+		if event.Description != "" {
+			event.Description += "\n\n"
+		}
+		event.Description += "连麦: " + strings.Join(names, ", ")
+	}
+	return event
+}
+
+func cleanCalendarCache() {
+	memoryCacheLock.Lock()
+	defer memoryCacheLock.Unlock()
+	memoryCacheGeneration++
+	livesCalendarCache = nil
+}
+
+func checkScheduledLive(scheduledLive *models.ScheduledLive) error {
+	if strings.TrimSpace(scheduledLive.Title) == "" {
+		return vo.NewErrorWithHttpStatus("缺少title参数", http.StatusBadRequest)
+	}
+	if scheduledLive.Timestamp <= 0 {
+		return vo.NewErrorWithHttpStatus("缺少timestamp参数", http.StatusBadRequest)
+	}
+	return nil
+}
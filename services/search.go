@@ -0,0 +1,298 @@
+package services
+
+import (
+	"html"
+	"mihiru-go/models"
+	"mihiru-go/util"
+	"mihiru-go/vo"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// searchLimit is the number of the newest candidates ranked of each kind, the page is marked truncated
+// when there are more, so that the client can ask for a narrower time range
+const searchLimit = 1000
+const searchMaxKeywords = 5
+const searchMaxPageSize = 100
+const snippetRadius = 40
+
+// weights of a keyword occurrence in each field
+const (
+	searchWeightTitle   = 3
+	searchWeightText    = 1
+	searchWeightSpeaker = 2
+	searchWeightPhrase  = 5
+)
+
+type searchMatcher struct {
+	keywords []string
+	patterns []*regexp.Regexp
+	any      *regexp.Regexp
+	phrase   *regexp.Regexp
+}
+
+func (m memoryService) Search(params *models.MemorySearchParams) (*vo.MemorySearchPageVo, error) {
+	keywords := strings.Fields(params.Keyword)
+	if len(keywords) == 0 {
+		return nil, vo.NewErrorWithHttpStatus("请输入搜索关键词", http.StatusBadRequest)
+	}
+	if len(keywords) > searchMaxKeywords {
+		keywords = keywords[:searchMaxKeywords]
+	}
+	kinds := make(map[string]bool)
+	for _, kind := range strings.Split(params.Kinds, ",") {
+		kind = strings.TrimSpace(kind)
+		switch kind {
+		case "":
+		case models.MemoryKindDynamic, models.MemoryKindLive, models.MemoryKindDialogue:
+			kinds[kind] = true
+		default:
+			return nil, vo.NewErrorWithHttpStatus("无效的类型参数", http.StatusBadRequest)
+		}
+	}
+	if len(kinds) == 0 {
+		kinds[models.MemoryKindDynamic] = true
+		kinds[models.MemoryKindLive] = true
+		kinds[models.MemoryKindDialogue] = true
+	}
+	if params.Speaker != "" {
+		// only dialogues have speakers
+		kinds = map[string]bool{models.MemoryKindDialogue: true}
+	}
+	loc, err := m.Location(params.Timezone)
+	if err != nil {
+		return nil, err
+	}
+	startTimestamp, endTimestamp, err := searchTimeRange(params.From, params.To, loc)
+	if err != nil {
+		return nil, err
+	}
+	matcher := newSearchMatcher(keywords)
+
+	var results []*vo.MemorySearchResultVo
+	truncated := false
+	if kinds[models.MemoryKindDynamic] {
+		// one more than the limit tells whether there are more candidates
+		dynamics, err := m.dynamicDatabase.SearchDynamic(keywords, startTimestamp, endTimestamp, searchLimit+1)
+		if err != nil {
+			util.LogError(err)
+			return nil, vo.NewErrorWithHttpStatus("搜索动态数据失败, 请稍后重试", http.StatusInternalServerError)
+		}
+		if len(dynamics) > searchLimit {
+			dynamics = dynamics[:searchLimit]
+			truncated = true
+		}
+		for _, dynamic := range dynamics {
+			result := matcher.match(map[string]string{"title": dynamic.Title}, map[string]string{"content": dynamic.Content, "desc": dynamic.Desc}, "")
+			if result == nil {
+				continue
+			}
+			result.Kind = models.MemoryKindDynamic
+			result.Id = dynamic.ID
+			result.Timestamp = dynamic.Timestamp
+			result.Title = dynamic.Title
+			result.DynamicType = dynamic.Type
+			results = append(results, result)
+		}
+	}
+	if kinds[models.MemoryKindLive] || kinds[models.MemoryKindDialogue] {
+		lives, err := m.liveDatabase.SearchLive(keywords, params.Speaker, startTimestamp, endTimestamp, searchLimit+1)
+		if err != nil {
+			util.LogError(err)
+			return nil, vo.NewErrorWithHttpStatus("搜索直播数据失败, 请稍后重试", http.StatusInternalServerError)
+		}
+		if len(lives) > searchLimit {
+			lives = lives[:searchLimit]
+			truncated = true
+		}
+		for _, live := range lives {
+			if kinds[models.MemoryKindLive] {
+				if result := matcher.match(map[string]string{"title": live.Title}, map[string]string{"content": live.Content}, ""); result != nil {
+					result.Kind = models.MemoryKindLive
+					result.Id = live.ID
+					result.Timestamp = live.Timestamp
+					result.Title = live.Title
+					results = append(results, result)
+				}
+			}
+			if !kinds[models.MemoryKindDialogue] || live.Cuts == nil {
+				continue
+			}
+			for cutIndex, cut := range *live.Cuts {
+				if cut.Dialogues == nil {
+					continue
+				}
+				for dialogueIndex, dialogue := range *cut.Dialogues {
+					if params.Speaker != "" && dialogue.Speaker != params.Speaker {
+						continue
+					}
+					result := matcher.match(nil, map[string]string{"text": dialogue.Text}, dialogue.Speaker)
+					if result == nil {
+						continue
+					}
+					cutIndex, dialogueIndex := cutIndex, dialogueIndex
+					result.Kind = models.MemoryKindDialogue
+					result.Id = live.ID
+					result.Timestamp = live.Timestamp
+					result.Title = live.Title
+					result.CutIndex = &cutIndex
+					result.CutTitle = cut.Title
+					result.DialogueIndex = &dialogueIndex
+					result.Speaker = dialogue.Speaker
+					results = append(results, result)
+				}
+			}
+		}
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Timestamp > results[j].Timestamp
+	})
+	pageSize := int64(10)
+	pageIndex := int64(0)
+	if params.PageSize != nil && *params.PageSize > 0 {
+		pageSize = *params.PageSize
+	}
+	if pageSize > searchMaxPageSize {
+		pageSize = searchMaxPageSize
+	}
+	if params.PageIndex != nil && *params.PageIndex > 0 {
+		pageIndex = *params.PageIndex
+	}
+	page := new(vo.MemorySearchPageVo)
+	count := int64(len(results))
+	page.PageSize = &pageSize
+	page.PageIndex = &pageIndex
+	page.Count = count
+	page.Truncated = truncated
+	page.PageCount = (count + pageSize - 1) / pageSize
+	page.Data = []*vo.MemorySearchResultVo{}
+	for i := pageIndex * pageSize; i < count && i < (pageIndex+1)*pageSize; i++ {
+		results[i].Day = time.Unix(results[i].Timestamp, 0).In(loc).Format(periodLayoutDay)
+		page.Data = append(page.Data, results[i])
+	}
+	return page, nil
+}
+
+func searchTimeRange(from string, to string, loc *time.Location) (int64, int64, error) {
+	var startTimestamp, endTimestamp int64
+	if from != "" {
+		date, err := time.ParseInLocation(periodLayoutDay, from, loc)
+		if err != nil {
+			return 0, 0, vo.NewErrorWithHttpStatus("无效的日期参数", http.StatusBadRequest)
+		}
+		startTimestamp = date.Unix()
+	}
+	if to != "" {
+		date, err := time.ParseInLocation(periodLayoutDay, to, loc)
+		if err != nil {
+			return 0, 0, vo.NewErrorWithHttpStatus("无效的日期参数", http.StatusBadRequest)
+		}
+		endTimestamp = date.AddDate(0, 0, 1).Unix()
+	}
+	return startTimestamp, endTimestamp, nil
+}
+
+func newSearchMatcher(keywords []string) *searchMatcher {
+	matcher := &searchMatcher{keywords: keywords}
+	quoted := make([]string, len(keywords))
+	for i, keyword := range keywords {
+		quoted[i] = regexp.QuoteMeta(keyword)
+		matcher.patterns = append(matcher.patterns, regexp.MustCompile("(?i)"+quoted[i]))
+	}
+	// longer keywords first, so that the highlight covers the longest match
+	sorted := append([]string{}, quoted...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+	matcher.any = regexp.MustCompile("(?i)" + strings.Join(sorted, "|"))
+	if len(keywords) > 1 {
+		matcher.phrase = regexp.MustCompile("(?i)" + strings.Join(quoted, `\s*`))
+	}
+	return matcher
+}
+
+// match scores the fields of one item, every keyword has to occur in at least one of them.
+// Returns nil when the item does not match.
+func (s *searchMatcher) match(titleFields map[string]string, textFields map[string]string, speaker string) *vo.MemorySearchResultVo {
+	result := &vo.MemorySearchResultVo{Highlights: make(map[string]string)}
+	for _, pattern := range s.patterns {
+		found := false
+		for _, text := range titleFields {
+			if n := len(pattern.FindAllStringIndex(text, -1)); n > 0 {
+				result.Score += n * searchWeightTitle
+				found = true
+			}
+		}
+		for _, text := range textFields {
+			if n := len(pattern.FindAllStringIndex(text, -1)); n > 0 {
+				result.Score += n * searchWeightText
+				found = true
+			}
+		}
+		if pattern.MatchString(speaker) {
+			result.Score += searchWeightSpeaker
+			found = true
+		}
+		if !found {
+			return nil
+		}
+	}
+	for name, text := range titleFields {
+		if s.any.MatchString(text) {
+			result.Highlights[name] = s.highlight(text)
+		}
+	}
+	for name, text := range textFields {
+		if s.phrase != nil && s.phrase.MatchString(text) {
+			result.Score += searchWeightPhrase
+		}
+		if s.any.MatchString(text) {
+			result.Highlights[name] = s.highlight(text)
+		}
+	}
+	return result
+}
+
+// highlight cuts a window of snippetRadius characters around the first match and wraps every match in <em>
+func (s *searchMatcher) highlight(text string) string {
+	first := s.any.FindStringIndex(text)
+	if first == nil {
+		return html.EscapeString(text)
+	}
+	start := first[0]
+	for i := 0; i < snippetRadius && start > 0; i++ {
+		_, size := utf8.DecodeLastRuneInString(text[:start])
+		start -= size
+	}
+	end := first[1]
+	for i := 0; i < snippetRadius && end < len(text); i++ {
+		_, size := utf8.DecodeRuneInString(text[end:])
+		end += size
+	}
+	window := text[start:end]
+	var builder strings.Builder
+	if start > 0 {
+		builder.WriteString("…")
+	}
+	last := 0
+	for _, index := range s.any.FindAllStringIndex(window, -1) {
+		builder.WriteString(html.EscapeString(window[last:index[0]]))
+		builder.WriteString("<em>")
+		builder.WriteString(html.EscapeString(window[index[0]:index[1]]))
+		builder.WriteString("</em>")
+		last = index[1]
+	}
+	builder.WriteString(html.EscapeString(window[last:]))
+	if end < len(text) {
+		builder.WriteString("…")
+	}
+	return builder.String()
+}
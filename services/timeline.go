@@ -0,0 +1,128 @@
+package services
+
+import (
+	"math"
+	"mihiru-go/models"
+	"mihiru-go/util"
+	"mihiru-go/vo"
+	"net/http"
+)
+
+const timelineDefaultLimit = 20
+const timelineMaxLimit = 100
+
+// Timeline returns a page of the merged items of every memory source before or after the cursor,
+// the latest items when neither is given. Items sharing the timestamp of the last one are never split
+// between pages, so a page may hold a few more than limit items
+func (m memoryService) Timeline(params *models.MemoryTimelineParams) (*vo.MemoryTimelineVo, error) {
+	if params.Before != nil && params.After != nil {
+		return nil, vo.NewErrorWithHttpStatus("before和after参数不能同时使用", http.StatusBadRequest)
+	}
+	if err := validRenderFormat(params.Render); err != nil {
+		return nil, err
+	}
+	limit := params.Limit
+	if limit <= 0 {
+		limit = timelineDefaultLimit
+	}
+	if limit > timelineMaxLimit {
+		limit = timelineMaxLimit
+	}
+	after := params.After != nil
+	var cursor int64 = math.MaxInt64
+	if after {
+		cursor = *params.After
+	} else if params.Before != nil {
+		cursor = *params.Before
+	}
+	sourceItems := make([][]models.MemoryItem, len(m.sources))
+	for i, source := range m.sources {
+		items, err := source.QueryByCursor(cursor, after, limit)
+		if err != nil {
+			util.LogError(err)
+			return nil, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+		}
+		sourceItems[i] = items
+	}
+	// the items closest to the cursor go first
+	closer := func(a models.MemoryItem, b models.MemoryItem) bool {
+		if after {
+			return a.GetTimestamp() < b.GetTimestamp()
+		}
+		return a.GetTimestamp() > b.GetTimestamp()
+	}
+	pageItems := make([][]models.MemoryItem, len(m.sources))
+	var count int64
+	var boundary int64
+	consumed := make([]int, len(m.sources))
+	mergeMemoryItems(sourceItems, closer, func(source int, item models.MemoryItem) bool {
+		if count == limit {
+			return false
+		}
+		pageItems[source] = append(pageItems[source], item)
+		consumed[source]++
+		boundary = item.GetTimestamp()
+		count++
+		return true
+	})
+	result := &vo.MemoryTimelineVo{Items: []*vo.MemoryItemVo{}}
+	if count == limit {
+		hasMore := false
+		// a source may hold more items at the boundary than it returned or than fitted in the page,
+		// replace them with every item at the boundary
+		for i, source := range m.sources {
+			kept := pageItems[i][:0]
+			for _, item := range pageItems[i] {
+				if item.GetTimestamp() != boundary {
+					kept = append(kept, item)
+				}
+			}
+			boundaryItems, err := source.QueryByTimestamp(boundary, boundary+1)
+			if err != nil {
+				util.LogError(err)
+				return nil, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+			}
+			pageItems[i] = append(kept, boundaryItems...)
+			for _, item := range sourceItems[i][consumed[i]:] {
+				if item.GetTimestamp() != boundary {
+					hasMore = true
+				}
+			}
+			// the source may have more items than the limit
+			if int64(len(sourceItems[i])) == limit {
+				hasMore = true
+			}
+		}
+		if hasMore {
+			result.Next = &boundary
+		}
+	}
+	if _, err := m.useArchivedMedia(pageItems); err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查询归档数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	mergeMemoryItems(pageItems, closer, func(source int, item models.MemoryItem) bool {
+		result.Items = append(result.Items, newMemoryItemVo(m.sources[source].Kind(), item))
+		return true
+	})
+	result.Items = renderMemoryItems(result.Items, params.Render)
+	return result, nil
+}
+
+// mergeMemoryItems visits the items of every source ordered by first, the items of each source have to be ordered already
+// and items that are not ordered by first follow the order of the sources. Stops when visit returns false
+func mergeMemoryItems(sourceItems [][]models.MemoryItem, first func(a models.MemoryItem, b models.MemoryItem) bool, visit func(source int, item models.MemoryItem) bool) {
+	indexes := make([]int, len(sourceItems))
+	for {
+		next := -1
+		for i, items := range sourceItems {
+			if indexes[i] < len(items) && (next < 0 || first(items[indexes[i]], sourceItems[next][indexes[next]])) {
+				next = i
+			}
+		}
+		if next < 0 || !visit(next, sourceItems[next][indexes[next]]) {
+			return
+		}
+		indexes[next]++
+	}
+}
@@ -0,0 +1,234 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mihiru-go/models"
+	"mihiru-go/util"
+	"mihiru-go/vo"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// lives with the dialogues of every cut can be large
+const importMaxLineSize = 16 * 1024 * 1024
+
+// sorted, see util.TextInArray
+var transferKinds = []string{models.MemoryKindDynamic, models.MemoryKindLive}
+
+// Export writes the dynamics and lives as NDJSON, one models.MemoryTransferLine per line,
+// the parameters are validated before anything is written
+func (m memoryService) Export(params *models.MemoryExportParams, w io.Writer) error {
+	kinds, err := transferKindSet(params.Kinds)
+	if err != nil {
+		return err
+	}
+	loc, err := m.Location(params.Timezone)
+	if err != nil {
+		return err
+	}
+	startTimestamp, endTimestamp, err := searchTimeRange(params.From, params.To, loc)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetEscapeHTML(false)
+	writeLine := func(kind string, item interface{}) error {
+		data, err := json.Marshal(item)
+		if err != nil {
+			return err
+		}
+		return encoder.Encode(&models.MemoryTransferLine{Kind: kind, Item: data})
+	}
+	if kinds[models.MemoryKindDynamic] {
+		err = m.dynamicDatabase.EachDynamic(startTimestamp, endTimestamp, func(dynamic *models.DynamicWithObjectId) error {
+			return writeLine(models.MemoryKindDynamic, &dynamic.Dynamic)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if kinds[models.MemoryKindLive] {
+		err = m.liveDatabase.EachLive(startTimestamp, endTimestamp, func(live *models.LiveWithObjectId) error {
+			return writeLine(models.MemoryKindLive, &live.Live)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Import upserts every line written by Export, dynamics by dynamic_id and lives by timestamp and title.
+// Invalid lines are reported by line number without stopping the import, dryRun only reports what would change
+func (m memoryService) Import(operator *vo.Operator, r io.Reader, dryRun bool) (*vo.MemoryImportResultVo, error) {
+	result := &vo.MemoryImportResultVo{DryRun: dryRun, Errors: []*vo.MemoryImportErrorVo{}}
+	fail := func(message string) {
+		result.Failed++
+		result.Errors = append(result.Errors, &vo.MemoryImportErrorVo{Line: result.Lines, Message: message})
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), importMaxLineSize)
+	for scanner.Scan() {
+		result.Lines++
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		var transferLine models.MemoryTransferLine
+		if err := json.Unmarshal(line, &transferLine); err != nil {
+			fail("无法解析的JSON: " + err.Error())
+			continue
+		}
+		if len(transferLine.Item) == 0 || string(transferLine.Item) == "null" {
+			fail("缺少item字段")
+			continue
+		}
+		var upsertResult string
+		var err error
+		switch transferLine.Kind {
+		case models.MemoryKindDynamic:
+			dynamic := new(models.Dynamic)
+			if err = strictUnmarshal(transferLine.Item, dynamic); err != nil {
+				fail("无法解析的动态数据: " + err.Error())
+				continue
+			}
+			if dynamic.DynamicId <= 0 {
+				fail("缺少dynamic_id参数")
+				continue
+			}
+			if dynamic.Timestamp <= 0 {
+				fail("缺少timestamp参数")
+				continue
+			}
+			upsertResult, err = m.importDynamic(operator, dynamic, dryRun)
+		case models.MemoryKindLive:
+			live := new(models.Live)
+			if err = strictUnmarshal(transferLine.Item, live); err != nil {
+				fail("无法解析的直播数据: " + err.Error())
+				continue
+			}
+			if live.Timestamp <= 0 {
+				fail("缺少timestamp参数")
+				continue
+			}
+			if strings.TrimSpace(live.Title) == "" {
+				fail("缺少title参数")
+				continue
+			}
+			upsertResult, err = m.importLive(operator, live, dryRun)
+		default:
+			fail(fmt.Sprintf("无效的类型: %q", transferLine.Kind))
+			continue
+		}
+		if err != nil {
+			util.LogError(err)
+			fail("保存数据失败")
+			continue
+		}
+		switch upsertResult {
+		case models.UpsertResultInserted:
+			result.Inserted++
+		case models.UpsertResultUpdated:
+			result.Updated++
+		default:
+			result.Unchanged++
+		}
+	}
+	if err := scanner.Err(); err == bufio.ErrTooLong {
+		return nil, vo.NewErrorWithHttpStatus(fmt.Sprintf("第%d行超过了最大长度", result.Lines+1), http.StatusBadRequest)
+	} else if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("读取导入数据失败", http.StatusBadRequest)
+	}
+	return result, nil
+}
+
+func (m memoryService) importDynamic(operator *vo.Operator, dynamic *models.Dynamic, dryRun bool) (string, error) {
+	if !dryRun {
+		result, _, err := m.upsertDynamic(operator, dynamic)
+		return result, err
+	}
+	existed, err := m.dynamicDatabase.GetDynamicByDynamicId(dynamic.DynamicId)
+	if err != nil {
+		return "", err
+	}
+	if existed == nil {
+		return models.UpsertResultInserted, nil
+	}
+	changed, err := itemChanged(&existed.Dynamic, dynamic)
+	if err != nil || !changed {
+		return models.UpsertResultUnchanged, err
+	}
+	return models.UpsertResultUpdated, nil
+}
+
+func (m memoryService) importLive(operator *vo.Operator, live *models.Live, dryRun bool) (string, error) {
+	existed, err := m.liveDatabase.GetLiveByTimestampAndTitle(live.Timestamp, live.Title)
+	if err != nil {
+		return "", err
+	}
+	if existed == nil {
+		if dryRun {
+			return models.UpsertResultInserted, nil
+		}
+		liveWithObjectId := new(models.LiveWithObjectId)
+		liveWithObjectId.Live = *live
+		liveWithObjectId.LastModified = time.Now().UnixNano() / 1e6
+		if err = m.liveDatabase.InsertLive(liveWithObjectId); err != nil {
+			return "", err
+		}
+		cleanCache(live.Timestamp)
+		m.auditService.Record(operator, models.AuditActionAdd, models.AuditTargetLive, liveWithObjectId.ID.Hex(), nil, liveWithObjectId)
+		return models.UpsertResultInserted, nil
+	}
+	changed, err := itemChanged(&existed.Live, live)
+	if err != nil || !changed {
+		return models.UpsertResultUnchanged, err
+	}
+	if dryRun {
+		return models.UpsertResultUpdated, nil
+	}
+	liveWithObjectId := new(models.LiveWithObjectId)
+	liveWithObjectId.ID = existed.ID
+	liveWithObjectId.Live = *live
+	liveWithObjectId.LastModified = time.Now().UnixNano() / 1e6
+	liveWithObjectId.SoftDeleteFields = existed.SoftDeleteFields
+	if err = m.liveDatabase.UpdateLive(liveWithObjectId); err != nil {
+		return "", err
+	}
+	cleanCache(live.Timestamp)
+	m.auditService.Record(operator, models.AuditActionUpdate, models.AuditTargetLive, existed.ID.Hex(), existed, liveWithObjectId)
+	return models.UpsertResultUpdated, nil
+}
+
+func transferKindSet(kinds string) (map[string]bool, error) {
+	kindSet := make(map[string]bool)
+	for _, kind := range strings.Split(kinds, ",") {
+		kind = strings.TrimSpace(kind)
+		if kind == "" {
+			continue
+		}
+		if !util.TextInArray(kind, transferKinds) {
+			return nil, vo.NewErrorWithHttpStatus("无效的类型参数", http.StatusBadRequest)
+		}
+		kindSet[kind] = true
+	}
+	if len(kindSet) == 0 {
+		for _, kind := range transferKinds {
+			kindSet[kind] = true
+		}
+	}
+	return kindSet, nil
+}
+
+// strictUnmarshal rejects unknown fields, so that a typo does not silently drop data
+func strictUnmarshal(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
@@ -0,0 +1,365 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"github.com/google/uuid"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io"
+	"mihiru-go/config"
+	"mihiru-go/database"
+	"mihiru-go/models"
+	"mihiru-go/util"
+	"mihiru-go/vo"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+type UploadService interface {
+	Create(operator *vo.Operator, uploadDto *models.UploadBaseFields) (*vo.UploadVo, error)
+	WriteChunk(id primitive.ObjectID, offset int64, r io.Reader) (*vo.UploadVo, error)
+	Get(id primitive.ObjectID) (*vo.UploadVo, error)
+	List(orphanOnly bool) ([]*vo.UploadVo, error)
+	Delete(operator *vo.Operator, id primitive.ObjectID) error
+}
+
+type uploadService struct {
+	uploadDatabase database.UploadDatabase
+	liveDatabase   database.LiveDatabase
+	auditService   AuditService
+}
+
+const uploadPartSuffix = ".part"
+
+var uploadKinds = []string{models.UploadKindCut, models.UploadKindRecord}
+
+var uploadExtensionRegexp = regexp.MustCompile(`^\.[0-9A-Za-z]{1,10}$`)
+
+// uploadLocks serializes the chunks of one upload, a *sync.Mutex by upload id, removed once the upload is done
+var uploadLocks sync.Map
+
+func NewUploadService(uploadDatabase database.UploadDatabase, liveDatabase database.LiveDatabase, auditService AuditService) UploadService {
+	return uploadService{uploadDatabase, liveDatabase, auditService}
+}
+
+// Create starts an upload, the file is then sent by WriteChunk and stored as base-folder/kind/uuid.ext like the voices
+func (u uploadService) Create(operator *vo.Operator, uploadDto *models.UploadBaseFields) (*vo.UploadVo, error) {
+	configs := config.GetConfigs()
+	if configs.GetString("upload.base-folder") == "" || configs.GetString("upload.base-path") == "" {
+		return nil, vo.NewErrorWithHttpStatus("未配置上传文件夹与访问路径", http.StatusBadRequest)
+	}
+	if !util.TextInArray(uploadDto.Kind, uploadKinds) {
+		return nil, vo.NewErrorWithHttpStatus("无效的类型参数", http.StatusBadRequest)
+	}
+	if uploadDto.Size <= 0 {
+		return nil, vo.NewErrorWithHttpStatus("缺少size参数", http.StatusBadRequest)
+	}
+	if maxSize := configs.GetInt64("upload.max-size"); maxSize > 0 && uploadDto.Size > maxSize {
+		return nil, vo.NewErrorWithHttpStatus(fmt.Sprintf("文件大小超过了%d字节的限制", maxSize), http.StatusBadRequest)
+	}
+	extension := strings.ToLower(path.Ext(uploadDto.FileName))
+	if !uploadExtensionRegexp.MatchString(extension) {
+		return nil, vo.NewErrorWithHttpStatus("无效的文件扩展名", http.StatusBadRequest)
+	}
+	uploadDto.Checksum = strings.ToLower(strings.TrimSpace(uploadDto.Checksum))
+	if uploadDto.Checksum != "" {
+		if checksum, err := hex.DecodeString(uploadDto.Checksum); err != nil || len(checksum) != sha256.Size {
+			return nil, vo.NewErrorWithHttpStatus("无效的checksum参数, 需为sha256的十六进制值", http.StatusBadRequest)
+		}
+	}
+	upload := new(models.UploadWithObjectId)
+	upload.UploadBaseFields = *uploadDto
+	upload.Status = models.UploadStatusUploading
+	upload.FilePath = upload.Kind + "/" + uuid.New().String() + extension
+	upload.Url = configs.GetString("upload.base-path") + upload.FilePath
+	upload.AddTime = time.Now().UnixNano() / 1e6
+	upload.UpdateTime = upload.AddTime
+	err := createFolderIfNotExists(configs.GetString("upload.base-folder") + upload.Kind)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("建立保存文件夹失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	err = u.uploadDatabase.InsertUpload(upload)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("添加数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	u.auditService.Record(operator, models.AuditActionAdd, models.AuditTargetUpload, upload.ID.Hex(), nil, upload)
+	return &vo.UploadVo{UploadWithObjectId: *upload}, nil
+}
+
+// WriteChunk appends the bytes at offset, which must equal the received size, so an interrupted upload resumes
+// from the received size returned by Get. The last chunk verifies the checksum and moves the file into place
+func (u uploadService) WriteChunk(id primitive.ObjectID, offset int64, r io.Reader) (*vo.UploadVo, error) {
+	lock, _ := uploadLocks.LoadOrStore(id, new(sync.Mutex))
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+	upload, err := u.uploadDatabase.GetUploadById(id)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查找数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if upload == nil {
+		return nil, vo.NewErrorWithHttpStatus("数据不存在", http.StatusNotFound)
+	}
+	if upload.Status != models.UploadStatusUploading {
+		return nil, vo.NewErrorWithHttpStatus("文件已上传完成", http.StatusConflict)
+	}
+	if offset != upload.Received {
+		return nil, vo.NewErrorWithHttpStatus(fmt.Sprintf("偏移量不匹配, 已接收%d字节", upload.Received), http.StatusConflict)
+	}
+	hash := sha256.New()
+	if len(upload.HashState) > 0 {
+		if err = hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(upload.HashState); err != nil {
+			util.LogError(err)
+			return nil, vo.NewErrorWithHttpStatus("恢复上传状态失败, 请重新上传", http.StatusInternalServerError)
+		}
+	}
+	localPath := u.localPath(upload)
+	// when finishing failed after the file was moved into place, the retry of the last chunk only finishes again
+	if upload.Received < upload.Size || !uploadMoved(localPath) {
+		limit := upload.Size - offset
+		if maxChunkSize := config.GetConfigs().GetInt64("upload.max-chunk-size"); maxChunkSize > 0 && limit > maxChunkSize {
+			limit = maxChunkSize
+		}
+		partPath := localPath + uploadPartSuffix
+		written, err := writePart(partPath, offset, hash, io.LimitReader(r, limit+1))
+		if err != nil {
+			util.LogError(err)
+			return nil, vo.NewErrorWithHttpStatus("保存文件失败, 请稍后重试", http.StatusInternalServerError)
+		}
+		if written > limit {
+			// the bytes after offset are overwritten by the next chunk
+			return nil, vo.NewErrorWithHttpStatus(fmt.Sprintf("分片超过了%d字节的限制", limit), http.StatusBadRequest)
+		}
+		hashState, err := hash.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			util.LogError(err)
+			return nil, vo.NewErrorWithHttpStatus("保存上传状态失败, 请稍后重试", http.StatusInternalServerError)
+		}
+		upload.Received = offset + written
+		upload.HashState = hashState
+		upload.UpdateTime = time.Now().UnixNano() / 1e6
+		updated, err := u.uploadDatabase.UpdateUploadProgress(id, offset, upload.Received, hashState, upload.UpdateTime)
+		if err != nil {
+			util.LogError(err)
+			return nil, vo.NewErrorWithHttpStatus("更新数据失败, 请稍后重试", http.StatusInternalServerError)
+		}
+		if !updated {
+			return nil, vo.NewErrorWithHttpStatus("数据已被修改, 请刷新后重试", http.StatusConflict)
+		}
+	}
+	if upload.Received == upload.Size {
+		if err = u.finish(upload, hex.EncodeToString(hash.Sum(nil))); err != nil {
+			return nil, err
+		}
+		// the later chunks are rejected by the status, whichever lock they hold
+		uploadLocks.Delete(id)
+	}
+	return &vo.UploadVo{UploadWithObjectId: *upload}, nil
+}
+
+func (u uploadService) finish(upload *models.UploadWithObjectId, checksum string) error {
+	localPath := u.localPath(upload)
+	if upload.Checksum != "" && upload.Checksum != checksum {
+		// start over, the received bytes can not be trusted
+		_, err := u.uploadDatabase.UpdateUploadProgress(upload.ID, upload.Received, 0, nil, time.Now().UnixNano()/1e6)
+		if err != nil {
+			util.LogError(err)
+		}
+		return vo.NewErrorWithHttpStatus("文件校验失败, 请重新上传", http.StatusBadRequest)
+	}
+	if !uploadMoved(localPath) {
+		if err := os.Rename(localPath+uploadPartSuffix, localPath); err != nil {
+			util.LogError(err)
+			return vo.NewErrorWithHttpStatus("保存文件失败, 请稍后重试", http.StatusInternalServerError)
+		}
+	}
+	if duration, err := probeDuration(localPath); err != nil {
+		util.LogError(err)
+	} else if duration > 0 {
+		upload.Duration = duration
+	}
+	upload.Checksum = checksum
+	upload.Status = models.UploadStatusDone
+	upload.HashState = nil
+	if err := u.uploadDatabase.FinishUpload(upload); err != nil {
+		util.LogError(err)
+		return vo.NewErrorWithHttpStatus("更新数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func (u uploadService) Get(id primitive.ObjectID) (*vo.UploadVo, error) {
+	upload, err := u.uploadDatabase.GetUploadById(id)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查找数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if upload == nil {
+		return nil, vo.NewErrorWithHttpStatus("数据不存在", http.StatusNotFound)
+	}
+	referenced, err := u.referencedFiles()
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查找数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	orphan := isOrphanUpload(upload, referenced)
+	if orphan {
+		uploadLocks.Delete(id)
+	}
+	return &vo.UploadVo{UploadWithObjectId: *upload, Orphan: orphan}, nil
+}
+
+func (u uploadService) List(orphanOnly bool) ([]*vo.UploadVo, error) {
+	uploads, err := u.uploadDatabase.ListUpload()
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查找数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	referenced, err := u.referencedFiles()
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查找数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	data := make([]*vo.UploadVo, 0, len(uploads))
+	for _, upload := range uploads {
+		orphan := isOrphanUpload(upload, referenced)
+		if orphan {
+			uploadLocks.Delete(upload.ID)
+		}
+		if orphanOnly && !orphan {
+			continue
+		}
+		data = append(data, &vo.UploadVo{UploadWithObjectId: *upload, Orphan: orphan})
+	}
+	return data, nil
+}
+
+// Delete removes the file with the upload, a finished file still referenced by a live can not be deleted
+func (u uploadService) Delete(operator *vo.Operator, id primitive.ObjectID) error {
+	upload, err := u.uploadDatabase.GetUploadById(id)
+	if err != nil {
+		util.LogError(err)
+		return vo.NewErrorWithHttpStatus("查找数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if upload == nil {
+		return vo.NewErrorWithHttpStatus("数据不存在", http.StatusNotFound)
+	}
+	referenced, err := u.referencedFiles()
+	if err != nil {
+		util.LogError(err)
+		return vo.NewErrorWithHttpStatus("查找数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if referenced[upload.Url] {
+		return vo.NewErrorWithHttpStatus("文件仍被直播引用, 无法删除", http.StatusConflict)
+	}
+	localPath := u.localPath(upload)
+	if upload.Status == models.UploadStatusUploading {
+		localPath += uploadPartSuffix
+	}
+	if err = os.Remove(localPath); err != nil && !os.IsNotExist(err) {
+		util.LogError(err)
+		return vo.NewErrorWithHttpStatus("删除文件失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	err = u.uploadDatabase.DeleteUpload(id)
+	if err != nil {
+		util.LogError(err)
+		return vo.NewErrorWithHttpStatus("删除数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	uploadLocks.Delete(id)
+	upload.HashState = nil
+	u.auditService.Record(operator, models.AuditActionDelete, models.AuditTargetUpload, id.Hex(), upload, nil)
+	return nil
+}
+
+func (u uploadService) localPath(upload *models.UploadWithObjectId) string {
+	return config.GetConfigs().GetString("upload.base-folder") + upload.FilePath
+}
+
+// referencedFiles is the set of the full records and cut files of all lives
+func (u uploadService) referencedFiles() (map[string]bool, error) {
+	files, err := u.liveDatabase.ListLiveMediaFiles()
+	if err != nil {
+		return nil, err
+	}
+	referenced := make(map[string]bool, len(files))
+	for _, file := range files {
+		referenced[file] = true
+	}
+	return referenced, nil
+}
+
+// uploadMoved tells whether the part file was already renamed to the final path
+func uploadMoved(localPath string) bool {
+	if _, err := os.Stat(localPath + uploadPartSuffix); !os.IsNotExist(err) {
+		return false
+	}
+	_, err := os.Stat(localPath)
+	return err == nil
+}
+
+// isOrphanUpload tells whether a finished file is not referenced by any live or an upload expired,
+// the lock of an orphaned upload is dropped by the callers
+func isOrphanUpload(upload *models.UploadWithObjectId, referenced map[string]bool) bool {
+	if upload.Status == models.UploadStatusDone {
+		return !referenced[upload.Url]
+	}
+	expire := config.GetConfigs().GetDuration("upload.expire")
+	if expire <= 0 {
+		expire = 24 * time.Hour
+	}
+	return time.Now().UnixNano()/1e6-upload.UpdateTime > expire.Milliseconds()
+}
+
+// writePart writes r into the part file from offset, dropping whatever an interrupted chunk left after it
+func writePart(partPath string, offset int64, hash io.Writer, r io.Reader) (int64, error) {
+	file, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return 0, err
+	}
+	if err = file.Truncate(offset); err != nil {
+		_ = file.Close()
+		return 0, err
+	}
+	if _, err = file.Seek(offset, io.SeekStart); err != nil {
+		_ = file.Close()
+		return 0, err
+	}
+	written, err := io.Copy(io.MultiWriter(file, hash), r)
+	closeErr := file.Close()
+	if err != nil {
+		return written, err
+	}
+	return written, closeErr
+}
+
+// probeDuration returns the duration in milliseconds by upload.ffprobe, 0 when it is not configured
+func probeDuration(filePath string) (int64, error) {
+	ffprobe := config.GetConfigs().GetString("upload.ffprobe")
+	if ffprobe == "" {
+		return 0, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	output, err := exec.CommandContext(ctx, ffprobe, "-v", "error", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", filePath).Output()
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseFloat(strings.TrimSpace(string(output)), 64)
+	if err != nil {
+		return 0, err
+	}
+	return int64(seconds * 1000), nil
+}
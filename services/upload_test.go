@@ -0,0 +1,112 @@
+package services
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"io/ioutil"
+	"mihiru-go/config"
+	"mihiru-go/models"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// mockUploadDatabase keeps one upload in memory, FinishUpload fails as many times as failFinish
+type mockUploadDatabase struct {
+	upload     *models.UploadWithObjectId
+	failFinish int
+}
+
+func (m *mockUploadDatabase) InsertUpload(upload *models.UploadWithObjectId) error {
+	m.upload = upload
+	return nil
+}
+
+func (m *mockUploadDatabase) GetUploadById(id primitive.ObjectID) (*models.UploadWithObjectId, error) {
+	if m.upload == nil || m.upload.ID != id {
+		return nil, nil
+	}
+	upload := *m.upload
+	return &upload, nil
+}
+
+func (m *mockUploadDatabase) UpdateUploadProgress(id primitive.ObjectID, offset int64, received int64, hashState []byte, updateTime int64) (bool, error) {
+	if m.upload == nil || m.upload.ID != id || m.upload.Received != offset {
+		return false, nil
+	}
+	m.upload.Received = received
+	m.upload.HashState = hashState
+	m.upload.UpdateTime = updateTime
+	return true, nil
+}
+
+func (m *mockUploadDatabase) FinishUpload(upload *models.UploadWithObjectId) error {
+	if m.failFinish > 0 {
+		m.failFinish--
+		return errors.New("connection reset")
+	}
+	finished := *upload
+	m.upload = &finished
+	return nil
+}
+
+func (m *mockUploadDatabase) ListUpload() ([]*models.UploadWithObjectId, error) {
+	return []*models.UploadWithObjectId{m.upload}, nil
+}
+
+func (m *mockUploadDatabase) DeleteUpload(id primitive.ObjectID) error {
+	m.upload = nil
+	return nil
+}
+
+func TestWriteChunkRetriesFinish(t *testing.T) {
+	if config.GetConfigs() == nil {
+		config.Init("sample")
+	}
+	folder, err := ioutil.TempDir("", "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+	config.GetConfigs().Set("upload.base-folder", folder+"/")
+	config.GetConfigs().Set("upload.ffprobe", "")
+
+	content := []byte("live record")
+	checksum := sha256.Sum256(content)
+	upload := &models.UploadWithObjectId{ObjectIdFields: models.ObjectIdFields{ID: primitive.NewObjectID()}}
+	upload.FilePath = "record.mp4"
+	upload.Size = int64(len(content))
+	upload.Checksum = hex.EncodeToString(checksum[:])
+	upload.Status = models.UploadStatusUploading
+	uploadDatabase := &mockUploadDatabase{upload: upload, failFinish: 1}
+	service := uploadService{uploadDatabase: uploadDatabase}
+
+	_, err = service.WriteChunk(upload.ID, 0, bytes.NewReader(content))
+	assertHttpStatus(t, err, http.StatusInternalServerError)
+	if _, ok := uploadLocks.Load(upload.ID); !ok {
+		t.Error("the lock of an unfinished upload is kept")
+	}
+
+	// the client retries the last chunk, the moved file must not be replaced by an empty part
+	result, err := service.WriteChunk(upload.ID, upload.Size, bytes.NewReader(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Status != models.UploadStatusDone || uploadDatabase.upload.Status != models.UploadStatusDone {
+		t.Errorf("expected the upload to be done, got %s", uploadDatabase.upload.Status)
+	}
+	saved, err := ioutil.ReadFile(filepath.Join(folder, upload.FilePath))
+	if err != nil || !bytes.Equal(saved, content) {
+		t.Errorf("unexpected file content %q, %v", saved, err)
+	}
+	if _, err = os.Stat(filepath.Join(folder, upload.FilePath+uploadPartSuffix)); !os.IsNotExist(err) {
+		t.Errorf("expected no part file, got %v", err)
+	}
+	if _, ok := uploadLocks.Load(upload.ID); ok {
+		t.Error("the lock of a finished upload is removed")
+	}
+}
@@ -2,6 +2,7 @@ package services
 
 import (
 	"crypto/hmac"
+	cryptorand "crypto/rand"
 	"crypto/sha512"
 	"encoding/hex"
 	"go.mongodb.org/mongo-driver/bson/primitive"
@@ -14,21 +15,34 @@ import (
 	"mihiru-go/util"
 	"mihiru-go/vo"
 	"net/http"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 	"unsafe"
 )
 
 type UserService interface {
-	Add(userDto *dto.UserDto) (*vo.UserVo, error)
+	Add(operator *vo.Operator, userDto *dto.UserDto) (*vo.UserVo, error)
 	Login(loginDto *dto.LoginDto) (string, string, error)
-	ChangePassword(token string, changePasswordDto dto.ChangePasswordDto) error
+	LoginByOidc(identity *models.OidcIdentity) (string, string, error)
+	ChangePassword(operator *vo.Operator, token string, changePasswordDto dto.ChangePasswordDto) error
 	CheckToken(token string) *vo.UserVo
 	InitUser()
+	List(pageParams *models.PageParams) (*vo.UserPageVo, error)
+	Get(id primitive.ObjectID) (*vo.UserVo, error)
+	Update(operator *vo.Operator, id primitive.ObjectID, updateUserDto *dto.UpdateUserDto) (*vo.UserVo, error)
+	SetDisabled(operator *vo.Operator, id primitive.ObjectID, disabled bool) (*vo.UserVo, error)
+	Delete(operator *vo.Operator, id primitive.ObjectID) error
+	Roles() map[string][]string
+	SetVoiceLivers(operator *vo.Operator, id primitive.ObjectID, livers []string) (*vo.UserVo, error)
 }
 
 type userService struct {
 	passwordEncoderKey []byte
+	rolePermissions    map[string][]string
 	db                 database.UserDatabase
+	auditService       AuditService
 }
 
 var tokenMap = make(map[primitive.ObjectID]string)
@@ -42,14 +56,16 @@ const (
 	letterIdxMax  = 63 / letterIdxBits   // # of letter indices fitting in 63 bits
 )
 
-func NewUserService(db database.UserDatabase) UserService {
+func NewUserService(db database.UserDatabase, auditService AuditService) UserService {
 	return userService{
 		passwordEncoderKey: []byte(config.GetConfigs().GetString("security.password-key")),
+		rolePermissions:    loadRolePermissions(),
 		db:                 db,
+		auditService:       auditService,
 	}
 }
 
-func (u userService) Add(userDto *dto.UserDto) (*vo.UserVo, error) {
+func (u userService) Add(operator *vo.Operator, userDto *dto.UserDto) (*vo.UserVo, error) {
 	user := new(models.UserWithObjectId)
 	user.LoginName = userDto.LoginName
 	user.Password = encodePassword(userDto.Password, u.passwordEncoderKey)
@@ -60,10 +76,12 @@ func (u userService) Add(userDto *dto.UserDto) (*vo.UserVo, error) {
 		util.LogError(err)
 		return nil, vo.NewErrorWithHttpStatus("添加数据失败, 请稍后重试", http.StatusInternalServerError)
 	}
-	return convertToUserVo(user), nil
+	userVo := u.convertToUserVo(user)
+	u.auditService.Record(operator, models.AuditActionAdd, models.AuditTargetUser, user.ID.Hex(), nil, userVo)
+	return userVo, nil
 }
 
-func (u userService) ChangePassword(token string, changePasswordDto dto.ChangePasswordDto) error {
+func (u userService) ChangePassword(operator *vo.Operator, token string, changePasswordDto dto.ChangePasswordDto) error {
 	userVo := loginInfoMap[token]
 	if userVo == nil {
 		return vo.NewErrorWithHttpStatus("用户未登录或登录已失效", http.StatusForbidden)
@@ -85,6 +103,7 @@ func (u userService) ChangePassword(token string, changePasswordDto dto.ChangePa
 		return vo.NewErrorWithHttpStatus("更新用户信息失败, 请稍后重试", http.StatusInternalServerError)
 	}
 	delete(loginInfoMap, token)
+	u.auditService.Record(operator, models.AuditActionChangePassword, models.AuditTargetUser, userWithObjectId.ID.Hex(), nil, nil)
 	return nil
 }
 
@@ -94,18 +113,243 @@ func (u userService) Login(loginDto *dto.LoginDto) (string, string, error) {
 		util.LogError(err)
 		return "", "", vo.NewErrorWithHttpStatus("查询用户信息失败, 请稍后重试", http.StatusInternalServerError)
 	}
-	if user == nil || encodePassword(loginDto.Password, u.passwordEncoderKey) != user.Password {
+	if user == nil || user.Password == "" || encodePassword(loginDto.Password, u.passwordEncoderKey) != user.Password {
 		return "", "", vo.NewErrorWithHttpStatus("账号或密码错误", http.StatusBadRequest)
 	}
+	if user.Disabled {
+		return "", "", vo.NewErrorWithHttpStatus("账号已被禁用", http.StatusForbidden)
+	}
+	return u.createToken(user), user.Name, nil
+}
+
+func (u userService) LoginByOidc(identity *models.OidcIdentity) (string, string, error) {
+	configs := config.GetConfigs()
+	user, err := u.db.GetUserByOidcSubject(identity.Subject)
+	if err != nil {
+		util.LogError(err)
+		return "", "", vo.NewErrorWithHttpStatus("查询用户信息失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if user == nil && configs.GetString("oidc.match-by") == "email" && identity.Email != "" && identity.EmailVerified {
+		user, err = u.db.GetUserByEmail(identity.Email)
+		if err != nil {
+			util.LogError(err)
+			return "", "", vo.NewErrorWithHttpStatus("查询用户信息失败, 请稍后重试", http.StatusInternalServerError)
+		}
+		if user != nil && user.OidcSubject != "" && user.OidcSubject != identity.Subject {
+			return "", "", vo.NewErrorWithHttpStatus("该邮箱已绑定其他账号", http.StatusForbidden)
+		}
+	}
+	mappedRoles := mapOidcRoles(identity.Groups)
+	if user == nil {
+		if !configs.GetBool("oidc.auto-create") {
+			return "", "", vo.NewErrorWithHttpStatus("未找到与该账号关联的用户", http.StatusForbidden)
+		}
+		user = new(models.UserWithObjectId)
+		user.LoginName, err = u.availableLoginName(identity)
+		if err != nil {
+			return "", "", err
+		}
+		user.Name = identity.Name
+		if user.Name == "" {
+			user.Name = user.LoginName
+		}
+		user.Email = identity.Email
+		user.OidcSubject = identity.Subject
+		user.Roles = append(configs.GetStringSlice("oidc.default-roles"), mappedRoles...)
+		err = u.db.InsertUser(user)
+		if err != nil {
+			util.LogError(err)
+			return "", "", vo.NewErrorWithHttpStatus("添加数据失败, 请稍后重试", http.StatusInternalServerError)
+		}
+		u.auditService.Record(&vo.Operator{UserVo: u.convertToUserVo(user)}, models.AuditActionAdd, models.AuditTargetUser, user.ID.Hex(), nil, u.convertToUserVo(user))
+	} else if user.OidcSubject == "" || (configs.GetBool("oidc.sync-roles") && mappedRoles != nil) {
+		user.OidcSubject = identity.Subject
+		if configs.GetBool("oidc.sync-roles") && mappedRoles != nil {
+			user.Roles = append(configs.GetStringSlice("oidc.default-roles"), mappedRoles...)
+		}
+		err = u.db.UpdateUser(user)
+		if err != nil {
+			util.LogError(err)
+			return "", "", vo.NewErrorWithHttpStatus("更新用户信息失败, 请稍后重试", http.StatusInternalServerError)
+		}
+	}
+	if user.Disabled {
+		return "", "", vo.NewErrorWithHttpStatus("账号已被禁用", http.StatusForbidden)
+	}
+	return u.createToken(user), user.Name, nil
+}
+
+func (u userService) CheckToken(token string) *vo.UserVo {
+	userVo := loginInfoMap[token]
+	if userVo == nil || userVo.Disabled {
+		return nil
+	}
+	return userVo
+}
+
+func (u userService) List(pageParams *models.PageParams) (*vo.UserPageVo, error) {
+	users, err := u.db.ListUser(pageParams)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查询数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	pageVo := new(vo.UserPageVo)
+	pageVo.PageResult = users.PageResult
+	pageVo.Data = []*vo.UserVo{}
+	for _, user := range users.Data {
+		pageVo.Data = append(pageVo.Data, u.convertToUserVo(user))
+	}
+	return pageVo, nil
+}
+
+func (u userService) Get(id primitive.ObjectID) (*vo.UserVo, error) {
+	user, err := u.getUser(id)
+	if err != nil {
+		return nil, err
+	}
+	return u.convertToUserVo(user), nil
+}
+
+func (u userService) Update(operator *vo.Operator, id primitive.ObjectID, updateUserDto *dto.UpdateUserDto) (*vo.UserVo, error) {
+	user, err := u.getUser(id)
+	if err != nil {
+		return nil, err
+	}
+	before := u.convertToUserVo(user)
+	if updateUserDto.Name != "" {
+		user.Name = updateUserDto.Name
+	}
+	if updateUserDto.Email != nil {
+		user.Email = strings.TrimSpace(*updateUserDto.Email)
+	}
+	if updateUserDto.Roles != nil {
+		user.Roles = *updateUserDto.Roles
+	}
+	err = u.db.UpdateUser(user)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("更新用户信息失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	u.refreshLoginInfo(user)
+	userVo := u.convertToUserVo(user)
+	u.auditService.Record(operator, models.AuditActionUpdate, models.AuditTargetUser, id.Hex(), before, userVo)
+	return userVo, nil
+}
+
+func (u userService) SetDisabled(operator *vo.Operator, id primitive.ObjectID, disabled bool) (*vo.UserVo, error) {
+	if disabled && operator.ID == id {
+		return nil, vo.NewErrorWithHttpStatus("不能禁用当前登录的用户", http.StatusBadRequest)
+	}
+	user, err := u.getUser(id)
+	if err != nil {
+		return nil, err
+	}
+	user.Disabled = disabled
+	err = u.db.UpdateUser(user)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("更新用户信息失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if disabled {
+		removeLoginInfo(user.ID)
+	}
+	action := models.AuditActionEnable
+	if disabled {
+		action = models.AuditActionDisable
+	}
+	userVo := u.convertToUserVo(user)
+	u.auditService.Record(operator, action, models.AuditTargetUser, id.Hex(), nil, userVo)
+	return userVo, nil
+}
+
+func (u userService) Delete(operator *vo.Operator, id primitive.ObjectID) error {
+	if operator.ID == id {
+		return vo.NewErrorWithHttpStatus("不能删除当前登录的用户", http.StatusBadRequest)
+	}
+	user, err := u.getUser(id)
+	if err != nil {
+		return err
+	}
+	err = u.db.DeleteUser(user.ID)
+	if err != nil {
+		util.LogError(err)
+		return vo.NewErrorWithHttpStatus("删除数据失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	removeLoginInfo(user.ID)
+	u.auditService.Record(operator, models.AuditActionDelete, models.AuditTargetUser, id.Hex(), u.convertToUserVo(user), nil)
+	return nil
+}
+
+func (u userService) SetVoiceLivers(operator *vo.Operator, id primitive.ObjectID, livers []string) (*vo.UserVo, error) {
+	user, err := u.getUser(id)
+	if err != nil {
+		return nil, err
+	}
+	before := u.convertToUserVo(user)
+	user.VoiceLivers = []string{}
+	for _, liver := range livers {
+		liver = strings.TrimSpace(liver)
+		if liver != "" {
+			user.VoiceLivers = append(user.VoiceLivers, liver)
+		}
+	}
+	err = u.db.UpdateUser(user)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("更新用户信息失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	u.refreshLoginInfo(user)
+	userVo := u.convertToUserVo(user)
+	u.auditService.Record(operator, models.AuditActionGrant, models.AuditTargetUser, id.Hex(), before, userVo)
+	return userVo, nil
+}
+
+func (u userService) Roles() map[string][]string {
+	return u.rolePermissions
+}
+
+func (u userService) createToken(user *models.UserWithObjectId) string {
 	token := randString(64, randSource)
-	loginInfoMap[token] = convertToUserVo(user)
+	loginInfoMap[token] = u.convertToUserVo(user)
 	delete(loginInfoMap, tokenMap[user.ID])
 	tokenMap[user.ID] = token
-	return token, user.Name, nil
+	return token
 }
 
-func (u userService) CheckToken(token string) *vo.UserVo {
-	return loginInfoMap[token]
+func (u userService) availableLoginName(identity *models.OidcIdentity) (string, error) {
+	loginName := identity.PreferredUsername
+	if loginName == "" {
+		loginName = identity.Email
+	}
+	if loginName == "" {
+		loginName = "oidc_" + identity.Subject
+	}
+	for i := 1; ; i++ {
+		candidate := loginName
+		if i > 1 {
+			candidate = loginName + "_" + strconv.Itoa(i)
+		}
+		user, err := u.db.GetUserByLoginName(candidate)
+		if err != nil {
+			util.LogError(err)
+			return "", vo.NewErrorWithHttpStatus("查询用户信息失败, 请稍后重试", http.StatusInternalServerError)
+		}
+		if user == nil {
+			return candidate, nil
+		}
+	}
+}
+
+func (u userService) getUser(id primitive.ObjectID) (*models.UserWithObjectId, error) {
+	user, err := u.db.GetUserById(id)
+	if err != nil {
+		util.LogError(err)
+		return nil, vo.NewErrorWithHttpStatus("查询用户信息失败, 请稍后重试", http.StatusInternalServerError)
+	}
+	if user == nil {
+		return nil, vo.NewErrorWithHttpStatus("用户不存在", http.StatusNotFound)
+	}
+	return user, nil
 }
 
 func (u userService) InitUser() {
@@ -132,13 +376,76 @@ func encodePassword(password string, passwordEncoderKey []byte) string {
 	return hex.EncodeToString(hash.Sum(nil))
 }
 
-func convertToUserVo(user *models.UserWithObjectId) *vo.UserVo {
+func (u userService) refreshLoginInfo(user *models.UserWithObjectId) {
+	if token, existed := tokenMap[user.ID]; existed {
+		loginInfoMap[token] = u.convertToUserVo(user)
+	}
+}
+
+func removeLoginInfo(id primitive.ObjectID) {
+	if token, existed := tokenMap[id]; existed {
+		delete(loginInfoMap, token)
+		delete(tokenMap, id)
+	}
+}
+
+func (u userService) convertToUserVo(user *models.UserWithObjectId) *vo.UserVo {
 	userVo := new(vo.UserVo)
 	userVo.ID = user.ID
 	userVo.UserBaseFields = user.UserBaseFields
+	userVo.UserStatusFields = user.UserStatusFields
+	userVo.UserGrantFields = user.UserGrantFields
+	userVo.UserOidcFields = user.UserOidcFields
+	userVo.Permissions = u.permissionsOfRoles(user.Roles)
 	return userVo
 }
 
+func (u userService) permissionsOfRoles(roles []string) []string {
+	permissionSet := make(map[string]bool)
+	for _, role := range roles {
+		for _, permission := range u.rolePermissions[role] {
+			if permission == models.PermissionAll {
+				for _, p := range models.AllPermissions {
+					permissionSet[p] = true
+				}
+			} else {
+				permissionSet[permission] = true
+			}
+		}
+	}
+	permissions := make([]string, 0, len(permissionSet))
+	for permission := range permissionSet {
+		permissions = append(permissions, permission)
+	}
+	sort.Strings(permissions)
+	return permissions
+}
+
+func mapOidcRoles(groups []string) []string {
+	roleMapping := config.GetConfigs().GetStringMapString("oidc.role-mapping")
+	if len(roleMapping) == 0 {
+		return nil
+	}
+	roles := []string{}
+	for _, group := range groups {
+		if role, existed := roleMapping[strings.ToLower(group)]; existed {
+			roles = append(roles, role)
+		}
+	}
+	return roles
+}
+
+func loadRolePermissions() map[string][]string {
+	rolePermissions := make(map[string][]string)
+	for role, permissions := range models.DefaultRolePermissions {
+		rolePermissions[role] = permissions
+	}
+	for role, permissions := range config.GetConfigs().GetStringMapStringSlice("security.roles") {
+		rolePermissions[role] = permissions
+	}
+	return rolePermissions
+}
+
 func randString(n int, src rand.Source) string {
 	b := make([]byte, n)
 	// A src.Int63() generates 63 random bits, enough for letterIdxMax characters!
@@ -156,3 +463,21 @@ func randString(n int, src rand.Source) string {
 
 	return *(*string)(unsafe.Pointer(&b))
 }
+
+// secureRandString draws the letters from crypto/rand, for secrets that must not be predictable from the time
+func secureRandString(n int) (string, error) {
+	b := make([]byte, 0, n)
+	buffer := make([]byte, n)
+	for len(b) < n {
+		if _, err := cryptorand.Read(buffer); err != nil {
+			return "", err
+		}
+		for _, random := range buffer {
+			// rejecting the indexes out of range keeps every letter equally likely
+			if idx := int(random & letterIdxMask); idx < len(letterBytes) && len(b) < n {
+				b = append(b, letterBytes[idx])
+			}
+		}
+	}
+	return string(b), nil
+}
@@ -16,25 +16,29 @@ import (
 )
 
 type VoiceService interface {
-	AddVoice(voiceDto *models.VoiceBaseFields, file *multipart.FileHeader, c *gin.Context) error
-	UpdateVoice(id primitive.ObjectID, voiceDto *models.VoiceBaseFields, file *multipart.FileHeader, c *gin.Context) error
-	DeleteVoice(id primitive.ObjectID) error
+	AddVoice(operator *vo.Operator, voiceDto *models.VoiceBaseFields, file *multipart.FileHeader, c *gin.Context) error
+	UpdateVoice(operator *vo.Operator, id primitive.ObjectID, voiceDto *models.VoiceBaseFields, file *multipart.FileHeader, c *gin.Context) error
+	DeleteVoice(operator *vo.Operator, id primitive.ObjectID) error
 	LiverVoices(liver string) ([]*vo.LiveVoicesCategoryVo, int64, error)
 }
 
 type voiceService struct {
 	voiceDatabase database.VoiceDatabase
+	auditService  AuditService
 }
 
 var liveVoiceCacheMap = make(map[string][]*vo.LiveVoicesCategoryVo)
 var liveVoiceVersionCacheMap = make(map[string]int64)
 
-func NewVoiceService(voiceDatabase database.VoiceDatabase) VoiceService {
-	return voiceService{voiceDatabase}
+func NewVoiceService(voiceDatabase database.VoiceDatabase, auditService AuditService) VoiceService {
+	return voiceService{voiceDatabase, auditService}
 }
 
-func (v voiceService) AddVoice(voiceDto *models.VoiceBaseFields, file *multipart.FileHeader, c *gin.Context) error {
-	voice := new(models.Voice)
+func (v voiceService) AddVoice(operator *vo.Operator, voiceDto *models.VoiceBaseFields, file *multipart.FileHeader, c *gin.Context) error {
+	if !canManageLiverVoices(operator, voiceDto.Liver) {
+		return vo.NewErrorWithHttpStatus("无权管理该主播的语音", http.StatusForbidden)
+	}
+	voice := new(models.VoiceWithObjectId)
 	voice.VoiceBaseFields = *voiceDto
 	voice.AddTime = time.Now().UnixNano() / 1e6
 	if voice.SortNo <= 0 {
@@ -62,6 +66,7 @@ func (v voiceService) AddVoice(voiceDto *models.VoiceBaseFields, file *multipart
 		util.LogError(err)
 		return vo.NewErrorWithHttpStatus("添加数据失败, 请稍后重试", http.StatusInternalServerError)
 	}
+	v.auditService.Record(operator, models.AuditActionAdd, models.AuditTargetVoice, voice.ID.Hex(), nil, voice)
 	_, _, err = v.refreshCache(voice.Liver)
 	if err != nil {
 		util.LogError(err)
@@ -70,7 +75,7 @@ func (v voiceService) AddVoice(voiceDto *models.VoiceBaseFields, file *multipart
 	return nil
 }
 
-func (v voiceService) UpdateVoice(id primitive.ObjectID, voiceDto *models.VoiceBaseFields, file *multipart.FileHeader, c *gin.Context) error {
+func (v voiceService) UpdateVoice(operator *vo.Operator, id primitive.ObjectID, voiceDto *models.VoiceBaseFields, file *multipart.FileHeader, c *gin.Context) error {
 	voice, err := v.voiceDatabase.GetVoiceById(id)
 	if err != nil {
 		util.LogError(err)
@@ -79,6 +84,10 @@ func (v voiceService) UpdateVoice(id primitive.ObjectID, voiceDto *models.VoiceB
 	if voice == nil {
 		return vo.NewErrorWithHttpStatus("数据不存在", http.StatusNotFound)
 	}
+	if !canManageLiverVoices(operator, voice.Liver) || !canManageLiverVoices(operator, voiceDto.Liver) {
+		return vo.NewErrorWithHttpStatus("无权管理该主播的语音", http.StatusForbidden)
+	}
+	before := *voice
 	voice.VoiceBaseFields = *voiceDto
 	if voice.SortNo <= 0 {
 		voice.SortNo = voice.AddTime
@@ -106,6 +115,14 @@ func (v voiceService) UpdateVoice(id primitive.ObjectID, voiceDto *models.VoiceB
 		util.LogError(err)
 		return vo.NewErrorWithHttpStatus("添加数据失败, 请稍后重试", http.StatusInternalServerError)
 	}
+	v.auditService.Record(operator, models.AuditActionUpdate, models.AuditTargetVoice, id.Hex(), before, voice)
+	if before.Liver != voice.Liver {
+		_, _, err = v.refreshCache(before.Liver)
+		if err != nil {
+			util.LogError(err)
+			return vo.NewErrorWithHttpStatus("刷新失败, 请稍后重试", http.StatusInternalServerError)
+		}
+	}
 	_, _, err = v.refreshCache(voice.Liver)
 	if err != nil {
 		util.LogError(err)
@@ -114,7 +131,7 @@ func (v voiceService) UpdateVoice(id primitive.ObjectID, voiceDto *models.VoiceB
 	return nil
 }
 
-func (v voiceService) DeleteVoice(id primitive.ObjectID) error {
+func (v voiceService) DeleteVoice(operator *vo.Operator, id primitive.ObjectID) error {
 	voice, err := v.voiceDatabase.GetVoiceById(id)
 	if err != nil {
 		util.LogError(err)
@@ -123,11 +140,15 @@ func (v voiceService) DeleteVoice(id primitive.ObjectID) error {
 	if voice == nil {
 		return vo.NewErrorWithHttpStatus("数据不存在", http.StatusNotFound)
 	}
+	if !canManageLiverVoices(operator, voice.Liver) {
+		return vo.NewErrorWithHttpStatus("无权管理该主播的语音", http.StatusForbidden)
+	}
 	err = v.voiceDatabase.DeleteVoice(id)
 	if err != nil {
 		util.LogError(err)
 		return vo.NewErrorWithHttpStatus("删除数据失败, 请稍后重试", http.StatusInternalServerError)
 	}
+	v.auditService.Record(operator, models.AuditActionDelete, models.AuditTargetVoice, id.Hex(), voice, nil)
 	_, _, err = v.refreshCache(voice.Liver)
 	if err != nil {
 		util.LogError(err)
@@ -173,6 +194,18 @@ func (v voiceService) refreshCache(liver string) ([]*vo.LiveVoicesCategoryVo, in
 	return result, liveVoiceVersionCacheMap[liver], nil
 }
 
+func canManageLiverVoices(operator *vo.Operator, liver string) bool {
+	if operator.HasPermission(models.PermissionVoiceWriteAll) {
+		return true
+	}
+	for _, voiceLiver := range operator.VoiceLivers {
+		if voiceLiver == liver {
+			return true
+		}
+	}
+	return false
+}
+
 func createFolderIfNotExists(folder string) error {
 	_, err := os.Stat(folder)
 	if err != nil && os.IsNotExist(err) {
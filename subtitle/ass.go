@@ -0,0 +1,157 @@
+package subtitle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"mihiru-go/models"
+	"strings"
+)
+
+const assDefaultStyle = "Default"
+
+// assColors are the primary colours given to speakers in order of appearance, in &HBBGGRR format
+var assColors = []string{"&H00FFFFFF", "&H00B4E6FF", "&H00FFD2A0", "&H00A0FFB4", "&H00D2A0FF", "&H00A0F0FF", "&H00FFA0DC"}
+
+var assStyleFormat = "Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding"
+var assEventFormat = "Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text"
+var assStyleNameReplacer = strings.NewReplacer(",", "，", "\n", " ")
+
+// assTextEscaper keeps braces from starting override blocks, renderers show \{ and \} as the braces
+var assTextEscaper = strings.NewReplacer("{", `\{`, "}", `\}`, "\n", `\N`)
+
+func renderAss(title string, cues []cue) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString("[Script Info]\n")
+	fmt.Fprintf(&buffer, "Title: %s\n", assStyleNameReplacer.Replace(title))
+	buffer.WriteString("ScriptType: v4.00+\nWrapStyle: 0\nPlayResX: 1920\nPlayResY: 1080\nScaledBorderAndShadow: yes\n\n")
+
+	buffer.WriteString("[V4+ Styles]\n")
+	fmt.Fprintf(&buffer, "Format: %s\n", assStyleFormat)
+	writeAssStyle(&buffer, assDefaultStyle, assColors[0])
+	styles := make(map[string]string)
+	for _, c := range cues {
+		if c.speaker == "" || styles[c.speaker] != "" {
+			continue
+		}
+		name := assStyleNameReplacer.Replace(c.speaker)
+		styles[c.speaker] = name
+		writeAssStyle(&buffer, name, assColors[len(styles)%len(assColors)])
+	}
+
+	buffer.WriteString("\n[Events]\n")
+	fmt.Fprintf(&buffer, "Format: %s\n", assEventFormat)
+	for _, c := range cues {
+		style := assDefaultStyle
+		if c.speaker != "" {
+			style = styles[c.speaker]
+		}
+		text := assTextEscaper.Replace(cueText(c.text))
+		fmt.Fprintf(&buffer, "Dialogue: 0,%s,%s,%s,%s,0,0,0,,%s\n",
+			formatAssTime(c.start), formatAssTime(c.end), style, assStyleNameReplacer.Replace(c.speaker), text)
+	}
+	return buffer.Bytes()
+}
+
+func writeAssStyle(buffer *bytes.Buffer, name string, color string) {
+	fmt.Fprintf(buffer, "Style: %s,Arial,60,%s,&H000000FF,&H00000000,&H80000000,0,0,0,0,100,100,0,0,1,3,0,2,20,20,40,1\n", name, color)
+}
+
+// parseAss reads the Dialogue lines of the [Events] section, the speaker is the Name field or the style
+func parseAss(text string) ([]models.LiveCutDialogue, error) {
+	dialogues := []models.LiveCutDialogue{}
+	section := ""
+	var format []string
+	for i, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.ToLower(line)
+			continue
+		}
+		if section != "[events]" {
+			continue
+		}
+		if strings.HasPrefix(line, "Format:") {
+			format = strings.Split(strings.TrimPrefix(line, "Format:"), ",")
+			for j := range format {
+				format[j] = strings.ToLower(strings.TrimSpace(format[j]))
+			}
+			continue
+		}
+		if !strings.HasPrefix(line, "Dialogue:") {
+			continue
+		}
+		if format == nil {
+			return nil, fmt.Errorf("line %d: missing Format line in [Events]", i+1)
+		}
+		// the text field is last and may contain commas
+		values := strings.SplitN(strings.TrimPrefix(line, "Dialogue:"), ",", len(format))
+		if len(values) != len(format) {
+			return nil, fmt.Errorf("line %d: expected %d fields", i+1, len(format))
+		}
+		fields := make(map[string]string)
+		for j, name := range format {
+			fields[name] = strings.TrimSpace(values[j])
+		}
+		start, err := parseTime(fields["start"])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err.Error())
+		}
+		end, err := parseTime(fields["end"])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", i+1, err.Error())
+		}
+		speaker := fields["name"]
+		if speaker == "" && fields["style"] != assDefaultStyle {
+			speaker = fields["style"]
+		}
+		dialogues = append(dialogues, models.LiveCutDialogue{Speaker: speaker, Text: assPlainText(fields["text"]), Start: &start, End: &end})
+	}
+	if format == nil {
+		return nil, errors.New("missing [Events] section")
+	}
+	return dialogues, nil
+}
+
+// assPlainText removes the override blocks and turns the escapes back into text
+func assPlainText(text string) string {
+	var builder strings.Builder
+	inOverride := false
+	for i := 0; i < len(text); i++ {
+		if inOverride {
+			inOverride = text[i] != '}'
+			continue
+		}
+		if text[i] == '{' {
+			inOverride = true
+			continue
+		}
+		if text[i] == '\\' && i+1 < len(text) {
+			switch text[i+1] {
+			case 'N', 'n':
+				builder.WriteByte('\n')
+				i++
+				continue
+			case 'h':
+				builder.WriteByte(' ')
+				i++
+				continue
+			case '{', '}':
+				builder.WriteByte(text[i+1])
+				i++
+				continue
+			}
+		}
+		builder.WriteByte(text[i])
+	}
+	return builder.String()
+}
+
+// formatAssTime formats milliseconds as h:mm:ss.cc
+func formatAssTime(milliseconds int64) string {
+	if milliseconds < 0 {
+		milliseconds = 0
+	}
+	return fmt.Sprintf("%d:%02d:%02d.%02d",
+		milliseconds/3600000, milliseconds/60000%60, milliseconds/1000%60, milliseconds%1000/10)
+}
@@ -0,0 +1,236 @@
+package subtitle
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"mihiru-go/models"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const (
+	FormatSrt = "srt"
+	FormatVtt = "vtt"
+	FormatAss = "ass"
+)
+
+// defaultDuration is used for dialogues without timing, in milliseconds
+const defaultDuration = 3000
+
+var ErrUnknownFormat = errors.New("unknown subtitle format")
+
+func ContentType(format string) string {
+	switch format {
+	case FormatSrt:
+		return "application/x-subrip; charset=utf-8"
+	case FormatVtt:
+		return "text/vtt; charset=utf-8"
+	case FormatAss:
+		return "text/x-ssa; charset=utf-8"
+	}
+	return "text/plain; charset=utf-8"
+}
+
+func Render(format string, title string, dialogues []models.LiveCutDialogue) ([]byte, error) {
+	cues := timedCues(dialogues)
+	switch format {
+	case FormatSrt:
+		return renderSrt(cues), nil
+	case FormatVtt:
+		return renderVtt(cues), nil
+	case FormatAss:
+		return renderAss(title, cues), nil
+	}
+	return nil, ErrUnknownFormat
+}
+
+// Parse reads the dialogues of a subtitle file. A "Speaker: " prefix in srt is only taken as the speaker when it is
+// one of the known speakers, otherwise lines like "注意: ..." would lose their beginning
+func Parse(format string, data []byte, speakers []string) ([]models.LiveCutDialogue, error) {
+	data = bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	switch format {
+	case FormatSrt:
+		return parseCues(text, false, speakers)
+	case FormatVtt:
+		return parseCues(text, true, nil)
+	case FormatAss:
+		return parseAss(text)
+	}
+	return nil, ErrUnknownFormat
+}
+
+type cue struct {
+	start   int64
+	end     int64
+	speaker string
+	text    string
+}
+
+// timedCues fills in missing timing, a dialogue without start follows the previous one
+func timedCues(dialogues []models.LiveCutDialogue) []cue {
+	cues := make([]cue, 0, len(dialogues))
+	var last int64
+	for _, dialogue := range dialogues {
+		c := cue{start: last, speaker: dialogue.Speaker, text: dialogue.Text}
+		if dialogue.Start != nil {
+			c.start = *dialogue.Start
+		}
+		c.end = c.start + defaultDuration
+		if dialogue.End != nil && *dialogue.End > c.start {
+			c.end = *dialogue.End
+		}
+		last = c.end
+		cues = append(cues, c)
+	}
+	return cues
+}
+
+func renderSrt(cues []cue) []byte {
+	var buffer bytes.Buffer
+	for i, c := range cues {
+		fmt.Fprintf(&buffer, "%d\n%s --> %s\n", i+1, formatTime(c.start, ","), formatTime(c.end, ","))
+		text := cueText(c.text)
+		if c.speaker != "" {
+			text = c.speaker + ": " + text
+		}
+		buffer.WriteString(text)
+		buffer.WriteString("\n\n")
+	}
+	return buffer.Bytes()
+}
+
+func renderVtt(cues []cue) []byte {
+	var buffer bytes.Buffer
+	buffer.WriteString("WEBVTT\n\n")
+	for i, c := range cues {
+		fmt.Fprintf(&buffer, "%d\n%s --> %s\n", i+1, formatTime(c.start, "."), formatTime(c.end, "."))
+		text := vttEscaper.Replace(cueText(c.text))
+		if c.speaker != "" {
+			text = "<v " + vttEscaper.Replace(c.speaker) + ">" + text
+		}
+		buffer.WriteString(text)
+		buffer.WriteString("\n\n")
+	}
+	return buffer.Bytes()
+}
+
+var vttEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+var vttUnescaper = strings.NewReplacer("&amp;", "&", "&lt;", "<", "&gt;", ">", "&nbsp;", " ")
+var cueTimePattern = regexp.MustCompile(`^\s*((?:\d+:)?\d{1,2}:\d{1,2}[,.]\d{1,3})\s*-->\s*((?:\d+:)?\d{1,2}:\d{1,2}[,.]\d{1,3})`)
+var vttVoicePattern = regexp.MustCompile(`^<v(?:\.[^ >]*)?\s+([^>]*)>`)
+var vttTagPattern = regexp.MustCompile(`</?[^>]*>`)
+var srtSpeakerPattern = regexp.MustCompile(`^([^:：\s][^:：]{0,31})[:：]\s*`)
+
+// cueText removes blank lines, which would end a cue in srt and vtt
+func cueText(text string) string {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, line)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// parseCues reads srt and vtt, a speaker is taken from "<v Speaker>" in vtt and a known "Speaker: " in srt
+func parseCues(text string, vtt bool, speakers []string) ([]models.LiveCutDialogue, error) {
+	knownSpeakers := make(map[string]bool)
+	for _, speaker := range speakers {
+		knownSpeakers[speaker] = true
+	}
+	dialogues := []models.LiveCutDialogue{}
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	lineNumber := 0
+	var current *models.LiveCutDialogue
+	var lines []string
+	flush := func() {
+		if current != nil {
+			current.Text = strings.Join(lines, "\n")
+			dialogues = append(dialogues, *current)
+		}
+		current = nil
+		lines = nil
+	}
+	for scanner.Scan() {
+		lineNumber++
+		line := scanner.Text()
+		if current == nil {
+			matches := cueTimePattern.FindStringSubmatch(line)
+			if matches == nil {
+				if vtt && lineNumber == 1 && !strings.HasPrefix(line, "WEBVTT") {
+					return nil, errors.New("missing WEBVTT header")
+				}
+				continue
+			}
+			start, err := parseTime(matches[1])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNumber, err.Error())
+			}
+			end, err := parseTime(matches[2])
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %s", lineNumber, err.Error())
+			}
+			current = &models.LiveCutDialogue{Start: &start, End: &end}
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			flush()
+			continue
+		}
+		if vtt {
+			if len(lines) == 0 {
+				if voice := vttVoicePattern.FindStringSubmatch(line); voice != nil {
+					current.Speaker = vttUnescaper.Replace(strings.TrimSpace(voice[1]))
+				}
+			}
+			line = vttUnescaper.Replace(vttTagPattern.ReplaceAllString(line, ""))
+		} else if len(lines) == 0 {
+			if speaker := srtSpeakerPattern.FindStringSubmatch(line); speaker != nil && knownSpeakers[speaker[1]] {
+				current.Speaker = speaker[1]
+				line = line[len(speaker[0]):]
+			}
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return dialogues, nil
+}
+
+// formatTime formats milliseconds as hh:mm:ss followed by the separator and milliseconds
+func formatTime(milliseconds int64, separator string) string {
+	if milliseconds < 0 {
+		milliseconds = 0
+	}
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d",
+		milliseconds/3600000, milliseconds/60000%60, milliseconds/1000%60, separator, milliseconds%1000)
+}
+
+// parseTime parses [h:]mm:ss(.|,)fraction into milliseconds
+func parseTime(value string) (int64, error) {
+	value = strings.ReplaceAll(strings.TrimSpace(value), ",", ".")
+	parts := strings.Split(value, ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %s", value)
+	}
+	var milliseconds int64
+	for _, part := range parts[:len(parts)-1] {
+		number, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time %s", value)
+		}
+		milliseconds = milliseconds*60 + number
+	}
+	seconds, err := strconv.ParseFloat(parts[len(parts)-1], 64)
+	if err != nil || seconds < 0 {
+		return 0, fmt.Errorf("invalid time %s", value)
+	}
+	return milliseconds*60000 + int64(seconds*1000+0.5), nil
+}
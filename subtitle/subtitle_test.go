@@ -0,0 +1,88 @@
+package subtitle
+
+import (
+	"mihiru-go/models"
+	"reflect"
+	"testing"
+)
+
+func dialogue(speaker string, text string, start int64, end int64) models.LiveCutDialogue {
+	return models.LiveCutDialogue{Speaker: speaker, Text: text, Start: &start, End: &end}
+}
+
+func TestRoundTrip(t *testing.T) {
+	dialogues := []models.LiveCutDialogue{
+		dialogue("米鲁", "大家好", 0, 1500),
+		dialogue("", "注意: 这不是说话人", 1500, 3000),
+		dialogue("小明", "第一行\n第二行", 3000, 4250),
+		dialogue("米鲁", "a, b & <c> {\\i1}", 3723450, 3725000),
+		dialogue("", "{花括号} 和 &amp;", 3725000, 3726000),
+	}
+	for _, format := range []string{FormatSrt, FormatVtt, FormatAss} {
+		t.Run(format, func(t *testing.T) {
+			data, err := Render(format, "测试", dialogues)
+			if err != nil {
+				t.Fatal(err)
+			}
+			parsed, err := Parse(format, data, []string{"米鲁", "小明"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(parsed, dialogues) {
+				t.Errorf("unexpected dialogues\n%s\ngot:  %s\nwant: %s", data, describe(parsed), describe(dialogues))
+			}
+		})
+	}
+}
+
+func TestParseSrtSpeaker(t *testing.T) {
+	data := []byte("1\n00:00:01,000 --> 00:00:02,000\n米鲁: 你好\n\n2\n00:00:02,000 --> 00:00:03,500\n注意：不是说话人\n\n")
+	tests := []struct {
+		name     string
+		speakers []string
+		want     []models.LiveCutDialogue
+	}{
+		{
+			name:     "known speaker",
+			speakers: []string{"米鲁"},
+			want:     []models.LiveCutDialogue{dialogue("米鲁", "你好", 1000, 2000), dialogue("", "注意：不是说话人", 2000, 3500)},
+		},
+		{
+			name: "no known speakers",
+			want: []models.LiveCutDialogue{dialogue("", "米鲁: 你好", 1000, 2000), dialogue("", "注意：不是说话人", 2000, 3500)},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			parsed, err := Parse(FormatSrt, data, test.speakers)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(parsed, test.want) {
+				t.Errorf("got %s, want %s", describe(parsed), describe(test.want))
+			}
+		})
+	}
+}
+
+func TestParseAssText(t *testing.T) {
+	data := []byte("[Script Info]\nTitle: 测试\n\n[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text\n" +
+		"Dialogue: 0,0:00:01.00,0:00:02.50,Default,,0,0,0,,{\\b1}粗体{\\b0}\\N\\{字面\\}\\h空格, 逗号\n" +
+		"Dialogue: 0,0:00:02.50,0:00:04.00,小明,,0,0,0,,样式名\n")
+	parsed, err := Parse(FormatAss, data, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.LiveCutDialogue{dialogue("", "粗体\n{字面} 空格, 逗号", 1000, 2500), dialogue("小明", "样式名", 2500, 4000)}
+	if !reflect.DeepEqual(parsed, want) {
+		t.Errorf("got %s, want %s", describe(parsed), describe(want))
+	}
+}
+
+func describe(dialogues []models.LiveCutDialogue) string {
+	description := ""
+	for _, d := range dialogues {
+		description += "\n  " + d.Speaker + "|" + d.Text + "|" + formatTime(*d.Start, ".") + "|" + formatTime(*d.End, ".")
+	}
+	return description
+}
@@ -2,6 +2,7 @@ package util
 
 import (
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"log"
 	"mihiru-go/vo"
 	"net/http"
@@ -9,6 +10,8 @@ import (
 	"sort"
 )
 
+const contextKeyLoginUser = "loginUser"
+
 func TextInArray(target string, sortedArray []string) bool {
 	index := sort.SearchStrings(sortedArray, target)
 	if index < len(sortedArray) && sortedArray[index] == target {
@@ -32,3 +35,44 @@ func ErrorResponse(c *gin.Context, err error) {
 	LogError(err)
 	c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"message": "出现未知错误, 请稍后重试"})
 }
+
+func SetLoginUser(c *gin.Context, user *vo.UserVo) {
+	c.Set(contextKeyLoginUser, user)
+}
+
+func GetLoginUser(c *gin.Context) *vo.UserVo {
+	if user, exists := c.Get(contextKeyLoginUser); exists {
+		return user.(*vo.UserVo)
+	}
+	return nil
+}
+
+func GetOperator(c *gin.Context) *vo.Operator {
+	return &vo.Operator{UserVo: GetLoginUser(c), ClientIp: c.ClientIP()}
+}
+
+// BsonToJsonValue converts documents decoded into interface{} (primitive.D/primitive.A) into maps and slices,
+// so they are rendered as plain JSON objects instead of key/value pair lists
+func BsonToJsonValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case primitive.D:
+		m := make(map[string]interface{}, len(v))
+		for _, e := range v {
+			m[e.Key] = BsonToJsonValue(e.Value)
+		}
+		return m
+	case primitive.M:
+		m := make(map[string]interface{}, len(v))
+		for key, e := range v {
+			m[key] = BsonToJsonValue(e)
+		}
+		return m
+	case primitive.A:
+		a := make([]interface{}, len(v))
+		for i, e := range v {
+			a[i] = BsonToJsonValue(e)
+		}
+		return a
+	}
+	return value
+}
@@ -0,0 +1,13 @@
+package vo
+
+import "mihiru-go/models"
+
+type Operator struct {
+	*UserVo
+	ClientIp string
+}
+
+type AuditLogPageVo struct {
+	models.PageResult
+	Data []*models.AuditLogWithObjectId `json:"data"`
+}
@@ -0,0 +1,130 @@
+package vo
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"mihiru-go/models"
+)
+
+type DynamicUpsertVo struct {
+	Result  string                      `json:"result"`
+	Dynamic *models.DynamicWithObjectId `json:"dynamic"`
+}
+
+type DynamicBatchResultVo struct {
+	Inserted  int `json:"inserted"`
+	Updated   int `json:"updated"`
+	Unchanged int `json:"unchanged"`
+}
+
+type CrawlerStatusVo struct {
+	Enabled             bool                `json:"enabled"`
+	Running             bool                `json:"running"`
+	Uids                []int64             `json:"uids"`
+	LastRunTime         int64               `json:"last_run_time"`
+	LastSuccessTime     int64               `json:"last_success_time"`
+	LastError           string              `json:"last_error"`
+	ConsecutiveFailures int                 `json:"consecutive_failures"`
+	NextRunTime         int64               `json:"next_run_time"`
+	LastResult          *CrawlerRunResultVo `json:"last_result"`
+}
+
+type CrawlerRunResultVo struct {
+	DynamicBatchResultVo
+	LivesAdded int `json:"lives_added"`
+}
+
+type MemoryHeatmapVo struct {
+	Year     string             `json:"year"`
+	Total    int64              `json:"total"`
+	MaxCount int64              `json:"max_count"`
+	Days     []*models.DayCount `json:"days"`
+}
+
+type MemorySearchResultVo struct {
+	Kind          string             `json:"kind"`
+	Id            primitive.ObjectID `json:"id"`
+	Timestamp     int64              `json:"timestamp"`
+	Day           string             `json:"day"`
+	Score         int                `json:"score"`
+	Title         string             `json:"title"`
+	DynamicType   int16              `json:"dynamic_type,omitempty"`
+	CutIndex      *int               `json:"cut_index,omitempty"`
+	CutTitle      string             `json:"cut_title,omitempty"`
+	DialogueIndex *int               `json:"dialogue_index,omitempty"`
+	Speaker       string             `json:"speaker,omitempty"`
+	Highlights    map[string]string  `json:"highlights"` //field name to html escaped snippet, matches wrapped in <em>
+}
+
+type MemorySearchPageVo struct {
+	models.PageResult
+	Truncated bool                    `json:"truncated"` //only the newest candidates were ranked, narrow the time range to see the older ones
+	Data      []*MemorySearchResultVo `json:"data"`
+}
+
+type ArchiverStatusVo struct {
+	Enabled        bool                       `json:"enabled"`
+	Running        bool                       `json:"running"`
+	LastRunTime    int64                      `json:"last_run_time"`
+	LastFinishTime int64                      `json:"last_finish_time"`
+	LastError      string                     `json:"last_error"`
+	NextRunTime    int64                      `json:"next_run_time"`
+	CurrentRun     *ArchiverRunResultVo       `json:"current_run"`
+	LastResult     *ArchiverRunResultVo       `json:"last_result"`
+	Counts         []*models.MediaStatusCount `json:"counts"`
+}
+
+type ArchiverRunResultVo struct {
+	Discovered   int64  `json:"discovered"`
+	Downloaded   int64  `json:"downloaded"`
+	Deduplicated int64  `json:"deduplicated"`
+	Failed       int64  `json:"failed"`
+	CurrentUrl   string `json:"current_url,omitempty"`
+}
+
+type MemoryItemVo struct {
+	Kind         string             `json:"kind"`
+	Id           primitive.ObjectID `json:"id"`
+	Timestamp    int64              `json:"timestamp"`
+	LastModified int64              `json:"last_modified"`
+	Payload      interface{}        `json:"payload"` //the dynamic or live itself, by kind
+	Rendered     *RenderedContentVo `json:"rendered,omitempty"`
+}
+
+// RenderedContentVo is the content of a dynamic in the requested render format, Origin for the origin of a repost
+type RenderedContentVo struct {
+	Html   string                  `json:"html,omitempty"`
+	Tokens []*models.RichTextToken `json:"tokens,omitempty"`
+	Origin *RenderedContentVo      `json:"origin,omitempty"`
+}
+
+type MemoryOnThisDayVo struct {
+	Date  string                   `json:"date"`
+	Years []*MemoryOnThisDayYearVo `json:"years"`
+}
+
+type MemoryOnThisDayYearVo struct {
+	Year     string           `json:"year"`
+	Day      string           `json:"day"`
+	Items    []*MemoryItemVo  `json:"items"`
+	Articles []*ArticleListVo `json:"articles"`
+}
+
+type MemoryTimelineVo struct {
+	Items []*MemoryItemVo `json:"items"`
+	Next  *int64          `json:"next"` //the cursor of the next page in the same direction, nil when there are no more items
+}
+
+type MemoryImportResultVo struct {
+	DryRun    bool                   `json:"dry_run"`
+	Lines     int                    `json:"lines"`
+	Inserted  int                    `json:"inserted"`
+	Updated   int                    `json:"updated"`
+	Unchanged int                    `json:"unchanged"`
+	Failed    int                    `json:"failed"`
+	Errors    []*MemoryImportErrorVo `json:"errors"`
+}
+
+type MemoryImportErrorVo struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,11 @@
+package vo
+
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"mihiru-go/models"
+)
+
+type ScheduledLiveVo struct {
+	models.ScheduledLiveWithObjectId
+	LiveId *primitive.ObjectID `json:"live_id"` //the live started near the scheduled time, nil until it is recorded
+}
@@ -0,0 +1,8 @@
+package vo
+
+import "mihiru-go/models"
+
+type UploadVo struct {
+	models.UploadWithObjectId
+	Orphan bool `json:"orphan"` //a finished file not referenced by any live, or an upload abandoned for longer than upload.expire
+}
@@ -1,8 +1,37 @@
 package vo
 
-import "mihiru-go/models"
+import (
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"mihiru-go/models"
+)
 
 type UserVo struct {
 	models.ObjectIdFields
 	models.UserBaseFields
+	models.UserStatusFields
+	models.UserGrantFields
+	models.UserOidcFields
+	Permissions []string            `json:"permissions"`
+	ApiKeyOwner *primitive.ObjectID `json:"apiKeyOwner,omitempty"` //the user who created the api key, only set for api key callers
+}
+
+func (u *UserVo) HasPermission(permission string) bool {
+	for _, p := range u.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+type UserPageVo struct {
+	models.PageResult
+	Data []*UserVo `json:"data"`
+}
+
+type ApiKeyVo struct {
+	models.ObjectIdFields
+	models.ApiKeyBaseFields
+	models.ApiKeyAutoGenFields
+	Key string `json:"key,omitempty"`
 }